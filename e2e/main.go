@@ -0,0 +1,207 @@
+// Command e2e runs the check, in, and out commands of a built resource
+// binary against a fake Lambda API, and reports the scenarios that fail.
+//
+//	go run ./e2e -binary bin/lambda-resource-linux-amd64
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sydsvenskan/lambda-resource/resource/lambdatest"
+	"github.com/pkg/errors"
+)
+
+// functionName is the name of the function of the scenarios
+const functionName = "e2e-function"
+
+// scenario runs the binary against a fake with a function that has two
+// published versions, and the alias PROD pointing at version 1.
+type scenario struct {
+	name string
+	run  func(r *runner, fake *lambdatest.Server) error
+}
+
+// runner runs the commands of the binary
+type runner struct {
+	binary string
+	source map[string]interface{}
+	dirs   []string
+}
+
+// result is the output of a command
+type result struct {
+	stdout   []byte
+	stderr   []byte
+	exitCode int
+	dir      string
+}
+
+func main() {
+	binary := flag.String("binary", "bin/lambda-resource-linux-amd64",
+		"the resource binary to test")
+	verbose := flag.Bool("v", false, "print the build log of failed scenarios")
+	flag.Parse()
+
+	path, err := filepath.Abs(*binary)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	failed := 0
+	for _, s := range scenarios {
+		if err := runScenario(path, s); err != nil {
+			failed++
+			fmt.Printf("FAIL %s: %s\n", s.name, firstLine(err.Error()))
+			if *verbose {
+				fmt.Println(err.Error())
+			}
+			continue
+		}
+		fmt.Printf("ok   %s\n", s.name)
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d scenarios failed\n", failed, len(scenarios))
+		os.Exit(1)
+	}
+}
+
+// runScenario sets up a new fake for the scenario and runs it
+func runScenario(binary string, s scenario) error {
+	fake := lambdatest.NewServer()
+	defer fake.Close()
+
+	fake.CreateFunction(functionName, "nodejs20.x", "index.handler", []byte("v1"))
+	if _, err := fake.Publish(functionName); err != nil {
+		return err
+	}
+	if err := fake.SetAlias(functionName, "PROD", "1"); err != nil {
+		return err
+	}
+	if _, err := fake.Publish(functionName); err != nil {
+		return err
+	}
+
+	r := &runner{
+		binary: binary,
+		source: map[string]interface{}{
+			"function_name":     functionName,
+			"region_name":       lambdatest.Region,
+			"endpoint":          fake.URL,
+			"access_key_id":     "AKIAE2EFAKE",
+			"secret_access_key": "e2e-secret",
+		},
+	}
+	defer r.cleanup()
+
+	return s.run(r, fake)
+}
+
+// run runs the command with the request in a new directory, and the files
+// in the directory.
+func (r *runner) run(
+	command string, request map[string]interface{}, files map[string][]byte,
+) (*result, error) {
+	dir, err := ioutil.TempDir("", "lambda-resource-e2e")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create a temporary directory")
+	}
+	r.dirs = append(r.dirs, dir)
+
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			return nil, errors.Wrapf(err, "failed to write %q", name)
+		}
+	}
+
+	request["source"] = r.source
+	input, err := json.Marshal(request)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal the request")
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(r.binary, command, dir)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	res := &result{dir: dir}
+	err = cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		res.exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "failed to run %s", command)
+	}
+	res.stdout = stdout.Bytes()
+	res.stderr = stderr.Bytes()
+	return res, nil
+}
+
+// cleanup removes the directories that the commands ran in
+func (r *runner) cleanup() {
+	for _, dir := range r.dirs {
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// succeeded returns an error with the build log if the command failed
+func (res *result) succeeded() error {
+	if res.exitCode != 0 {
+		return errors.Errorf("exited with %d\n%s", res.exitCode, res.stderr)
+	}
+	return nil
+}
+
+// decode decodes the response of the command
+func (res *result) decode(v interface{}) error {
+	if err := json.Unmarshal(res.stdout, v); err != nil {
+		return errors.Wrapf(err, "invalid response %q\n%s", res.stdout, res.stderr)
+	}
+	return nil
+}
+
+// file reads a file that the command wrote
+func (res *result) file(name string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(res.dir, name))
+	if err != nil {
+		return "", errors.Wrapf(err, "the command didn't write %q\n%s", name, res.stderr)
+	}
+	return string(data), nil
+}
+
+// zipArchive returns a zip archive with the files
+func zipArchive(files map[string]string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		fw, err := w.Create(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to create %q", name)
+		}
+		if _, err := fw.Write([]byte(content)); err != nil {
+			return nil, errors.Wrapf(err, "failed to write %q", name)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to finish the zip archive")
+	}
+	return buf.Bytes(), nil
+}
+
+func firstLine(s string) string {
+	if i := strings.Index(s, "\n"); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
@@ -0,0 +1,593 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/Sydsvenskan/lambda-resource/resource/lambdatest"
+)
+
+// versions is the response of check
+type versions []map[string]string
+
+// numbers returns the version numbers
+func (v versions) numbers() []string {
+	numbers := make([]string, len(v))
+	for i, version := range v {
+		numbers[i] = version["version"]
+	}
+	return numbers
+}
+
+// response is the response of in and out
+type response struct {
+	Version map[string]string `json:"version"`
+}
+
+var scenarios = []scenario{
+	{"check returns the latest version on the first check", checkFirst},
+	{"check returns the versions after the current one", checkNewer},
+	{"check tracks the version of an alias", checkAlias},
+	{"get writes the function configuration", getVersion},
+	{"get invokes the function with the payload", getInvoke},
+	{"get fails when the function fails", getFunctionError},
+	{"get writes allowed function errors to error.json", getAllowedFunctionError},
+	{"put publishes the code and updates the alias", putCode},
+	{"put points the alias at an existing version", putAlias},
+	{"put returns the revision of the tracked alias", putTrackedAlias},
+	{"get of a deletion version doesn't call AWS", getDeleted},
+	{"put rolls the alias back without changing its description", putRollback},
+	{"put rejects invalid params without calling AWS", putInvalid},
+	{"put writes the failed step to error.json", putErrorFile},
+	{"put reads params from files", putFileParams},
+	{"put deploys a function from a SAM template", putSAMTemplate},
+	{"get with debug_aws doesn't log the function configuration", getDebugAWS},
+	{"check emits every version published with trace_tags", checkTraced},
+}
+
+func checkFirst(r *runner, fake *lambdatest.Server) error {
+	res, err := r.run("check", map[string]interface{}{}, nil)
+	if err != nil {
+		return err
+	}
+	if err := res.succeeded(); err != nil {
+		return err
+	}
+
+	var got versions
+	if err := res.decode(&got); err != nil {
+		return err
+	}
+	return expectEqual("versions", got.numbers(), []string{"2"})
+}
+
+func checkNewer(r *runner, fake *lambdatest.Server) error {
+	if _, err := fake.Publish(functionName); err != nil {
+		return err
+	}
+
+	res, err := r.run("check", map[string]interface{}{
+		"version": map[string]string{"version": "2"},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if err := res.succeeded(); err != nil {
+		return err
+	}
+
+	var got versions
+	if err := res.decode(&got); err != nil {
+		return err
+	}
+	return expectEqual("versions", got.numbers(), []string{"3"})
+}
+
+func checkAlias(r *runner, fake *lambdatest.Server) error {
+	r.source["alias"] = "PROD"
+
+	res, err := r.run("check", map[string]interface{}{}, nil)
+	if err != nil {
+		return err
+	}
+	if err := res.succeeded(); err != nil {
+		return err
+	}
+
+	var got versions
+	if err := res.decode(&got); err != nil {
+		return err
+	}
+	return expectEqual("versions", got.numbers(), []string{"1"})
+}
+
+func getVersion(r *runner, fake *lambdatest.Server) error {
+	res, err := r.run("in", map[string]interface{}{
+		"version": map[string]string{"version": "1"},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if err := res.succeeded(); err != nil {
+		return err
+	}
+
+	var got response
+	if err := res.decode(&got); err != nil {
+		return err
+	}
+	if err := expectEqual("version", got.Version, map[string]string{"version": "1"}); err != nil {
+		return err
+	}
+
+	for name, want := range map[string]string{
+		"version": "1",
+		"arn":     "arn:aws:lambda:eu-west-1:123456789012:function:" + functionName + ":1",
+		"runtime": "nodejs20.x",
+	} {
+		data, err := res.file(name)
+		if err != nil {
+			return err
+		}
+		if err := expectEqual(name, data, want); err != nil {
+			return err
+		}
+	}
+	_, err = res.file("function.json")
+	return err
+}
+
+func getInvoke(r *runner, fake *lambdatest.Server) error {
+	var invoked []byte
+	fake.Invoke = func(function, version string, payload []byte) ([]byte, string) {
+		invoked = payload
+		return []byte(`{"greeting":"hello"}`), ""
+	}
+
+	res, err := r.run("in", map[string]interface{}{
+		"version": map[string]string{"version": "2"},
+		"params":  map[string]interface{}{"payload": map[string]string{"name": "e2e"}},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if err := res.succeeded(); err != nil {
+		return err
+	}
+
+	if err := expectEqual("invoked payload", string(invoked), `{"name":"e2e"}`); err != nil {
+		return err
+	}
+	payload, err := res.file("result.payload.json")
+	if err != nil {
+		return err
+	}
+	return expectEqual("payload", strings.TrimSpace(payload), `{"greeting":"hello"}`)
+}
+
+func getFunctionError(r *runner, fake *lambdatest.Server) error {
+	fake.Invoke = func(function, version string, payload []byte) ([]byte, string) {
+		return []byte(`{"errorMessage":"boom","errorType":"Error"}`), "Unhandled"
+	}
+
+	res, err := r.run("in", map[string]interface{}{
+		"version": map[string]string{"version": "2"},
+		"params":  map[string]interface{}{"payload": map[string]string{}},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if res.exitCode == 0 {
+		return fmt.Errorf("expected the get to fail\n%s", res.stderr)
+	}
+	if !strings.Contains(string(res.stderr), "boom") {
+		return fmt.Errorf("the build log doesn't contain the function error\n%s", res.stderr)
+	}
+
+	got, err := res.errorFile()
+	if err != nil {
+		return err
+	}
+	if err := expectEqual("category", got["category"], "function_error"); err != nil {
+		return err
+	}
+	return expectEqual("error message", got["errorMessage"], "boom")
+}
+
+func getAllowedFunctionError(r *runner, fake *lambdatest.Server) error {
+	fake.Invoke = func(function, version string, payload []byte) ([]byte, string) {
+		return []byte(`{"errorMessage":"boom","errorType":"Error"}`), "Handled"
+	}
+
+	res, err := r.run("in", map[string]interface{}{
+		"version": map[string]string{"version": "2"},
+		"params": map[string]interface{}{
+			"payload":               map[string]string{},
+			"allow_function_errors": "handled",
+		},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if err := res.succeeded(); err != nil {
+		return err
+	}
+
+	got, err := res.errorFile()
+	if err != nil {
+		return err
+	}
+	if err := expectEqual("category", got["category"], "function_error"); err != nil {
+		return err
+	}
+	return expectEqual("error message", got["errorMessage"], "boom")
+}
+
+func getDeleted(r *runner, fake *lambdatest.Server) error {
+	r.source["alias"] = "PROD"
+
+	res, err := r.run("in", map[string]interface{}{
+		"version": map[string]string{"timestamp": "1700000000"},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if err := res.succeeded(); err != nil {
+		return err
+	}
+	return expectEqual("calls", fake.Calls(), []string(nil))
+}
+
+func putCode(r *runner, fake *lambdatest.Server) error {
+	zipFile, err := zipArchive(map[string]string{
+		"index.js": "exports.handler = async () => 'v3'\n",
+	})
+	if err != nil {
+		return err
+	}
+
+	res, err := r.run("out", map[string]interface{}{
+		"params": map[string]interface{}{
+			"zip_file": "function.zip",
+			"alias":    "PROD",
+		},
+	}, map[string][]byte{"function.zip": zipFile})
+	if err != nil {
+		return err
+	}
+	if err := res.succeeded(); err != nil {
+		return err
+	}
+
+	var got response
+	if err := res.decode(&got); err != nil {
+		return err
+	}
+	if err := expectChecked(r, got.Version); err != nil {
+		return err
+	}
+	if err := expectEqual("published versions", fake.Versions(functionName),
+		[]string{"1", "2", "3"}); err != nil {
+		return err
+	}
+	alias, _ := fake.Alias(functionName, "PROD")
+	return expectEqual("alias version", alias, "3")
+}
+
+func putAlias(r *runner, fake *lambdatest.Server) error {
+	res, err := r.run("out", map[string]interface{}{
+		"params": map[string]interface{}{
+			"version": "2",
+			"alias":   "PROD",
+		},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if err := res.succeeded(); err != nil {
+		return err
+	}
+
+	var got response
+	if err := res.decode(&got); err != nil {
+		return err
+	}
+	if err := expectChecked(r, got.Version); err != nil {
+		return err
+	}
+	alias, _ := fake.Alias(functionName, "PROD")
+	return expectEqual("alias version", alias, "2")
+}
+
+func putTrackedAlias(r *runner, fake *lambdatest.Server) error {
+	r.source["alias"] = "PROD"
+
+	res, err := r.run("out", map[string]interface{}{
+		"params": map[string]interface{}{
+			"version": "2",
+			"alias":   "PROD",
+		},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if err := res.succeeded(); err != nil {
+		return err
+	}
+
+	var got response
+	if err := res.decode(&got); err != nil {
+		return err
+	}
+	if got.Version["alias_revision"] == "" {
+		return fmt.Errorf("the version has no alias revision: %v", got.Version)
+	}
+	return expectChecked(r, got.Version)
+}
+
+func putRollback(r *runner, fake *lambdatest.Server) error {
+	res, err := r.run("out", map[string]interface{}{
+		"params": map[string]interface{}{
+			"version": "2",
+			"alias":   "PROD",
+		},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if err := res.succeeded(); err != nil {
+		return err
+	}
+	tags := fake.Tags(functionName)
+	if err := expectEqual("previous version tag", tags["concourse:previous-version:PROD"], "1"); err != nil {
+		return err
+	}
+
+	res, err = r.run("out", map[string]interface{}{
+		"params": map[string]interface{}{
+			"rollback": true,
+			"alias":    "PROD",
+		},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if err := res.succeeded(); err != nil {
+		return err
+	}
+
+	alias, _ := fake.Alias(functionName, "PROD")
+	if err := expectEqual("alias version", alias, "1"); err != nil {
+		return err
+	}
+	description, _ := fake.AliasDescription(functionName, "PROD")
+	return expectEqual("alias description", description, "")
+}
+
+func putInvalid(r *runner, fake *lambdatest.Server) error {
+	res, err := r.run("out", map[string]interface{}{
+		"params": map[string]interface{}{
+			"promote_from": "TEST",
+		},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if err := expectEqual("exit code", res.exitCode, 2); err != nil {
+		return err
+	}
+	return expectEqual("calls", len(fake.Calls()), 0)
+}
+
+func putErrorFile(r *runner, fake *lambdatest.Server) error {
+	res, err := r.run("out", map[string]interface{}{
+		"params": map[string]interface{}{
+			"version": "9",
+			"alias":   "PROD",
+		},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if res.exitCode == 0 {
+		return fmt.Errorf("expected the put to fail\n%s", res.stderr)
+	}
+
+	got, err := res.errorFile()
+	if err != nil {
+		return err
+	}
+	if err := expectEqual("step", got["step"], "updating the alias"); err != nil {
+		return err
+	}
+	if id, _ := got["request_id"].(string); id == "" {
+		return fmt.Errorf("error.json has no request id: %v", got)
+	}
+	return nil
+}
+
+func putFileParams(r *runner, fake *lambdatest.Server) error {
+	res, err := r.run("out", map[string]interface{}{
+		"params": map[string]interface{}{
+			"version":    "2",
+			"alias_file": "alias",
+		},
+	}, map[string][]byte{"alias": []byte("PROD\n")})
+	if err != nil {
+		return err
+	}
+	if err := res.succeeded(); err != nil {
+		return err
+	}
+
+	alias, _ := fake.Alias(functionName, "PROD")
+	return expectEqual("alias version", alias, "2")
+}
+
+func putSAMTemplate(r *runner, fake *lambdatest.Server) error {
+	zipFile, err := zipArchive(map[string]string{
+		"index.js": "exports.handler = async () => 'sam'\n",
+	})
+	if err != nil {
+		return err
+	}
+	template := []byte(`Transform: AWS::Serverless-2016-10-31
+Globals:
+  Function:
+    Runtime: nodejs20.x
+Resources:
+  Table:
+    Type: AWS::DynamoDB::Table
+  Api:
+    Type: AWS::Serverless::Function
+    Properties:
+      CodeUri: api.zip
+      Handler: index.handler
+      Environment:
+        Variables:
+          TABLE: !Ref Table
+  Worker:
+    Type: AWS::Serverless::Function
+    Properties:
+      CodeUri: worker/
+`)
+
+	res, err := r.run("out", map[string]interface{}{
+		"params": map[string]interface{}{
+			"sam_template": "template.yaml",
+			"sam_function": "Api",
+			"alias":        "PROD",
+		},
+	}, map[string][]byte{"template.yaml": template, "api.zip": zipFile})
+	if err != nil {
+		return err
+	}
+	if err := res.succeeded(); err != nil {
+		return err
+	}
+
+	if err := expectEqual("published versions", fake.Versions(functionName),
+		[]string{"1", "2", "3"}); err != nil {
+		return err
+	}
+	alias, _ := fake.Alias(functionName, "PROD")
+	return expectEqual("alias version", alias, "3")
+}
+
+func getDebugAWS(r *runner, fake *lambdatest.Server) error {
+	r.source["debug_aws"] = true
+
+	res, err := r.run("in", map[string]interface{}{
+		"version": map[string]string{"version": "1"},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if err := res.succeeded(); err != nil {
+		return err
+	}
+
+	if !strings.Contains(string(res.stderr), "GetFunctionConfiguration") {
+		return fmt.Errorf("the AWS requests weren't logged\n%s", res.stderr)
+	}
+	if strings.Contains(string(res.stderr), `"CodeSha256"`) {
+		return fmt.Errorf("the function configuration was logged\n%s", res.stderr)
+	}
+	return nil
+}
+
+func checkTraced(r *runner, fake *lambdatest.Server) error {
+	for _, ref := range []string{"a1", "b2"} {
+		zipFile, err := zipArchive(map[string]string{
+			"index.js": "exports.handler = async () => '" + ref + "'\n",
+		})
+		if err != nil {
+			return err
+		}
+
+		res, err := r.run("out", map[string]interface{}{
+			"params": map[string]interface{}{
+				"zip_file":   "function.zip",
+				"trace_tags": true,
+				"ref_file":   "ref",
+			},
+		}, map[string][]byte{"function.zip": zipFile, "ref": []byte(ref)})
+		if err != nil {
+			return err
+		}
+		if err := res.succeeded(); err != nil {
+			return err
+		}
+	}
+
+	r.source["initial_version"] = "all"
+	for filter, want := range map[string][]string{
+		"":   {"3", "4"},
+		"a1": {"3"},
+	} {
+		r.source["trace_tag_filter"] = map[string]string{}
+		if filter != "" {
+			r.source["trace_tag_filter"] = map[string]string{"concourse:ref": filter}
+		}
+
+		res, err := r.run("check", map[string]interface{}{}, nil)
+		if err != nil {
+			return err
+		}
+		if err := res.succeeded(); err != nil {
+			return err
+		}
+
+		var got versions
+		if err := res.decode(&got); err != nil {
+			return err
+		}
+		if err := expectEqual("traced versions", got.numbers(), want); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expectChecked checks that the version of a put is the version that
+// check emits, so that Concourse records it once.
+func expectChecked(r *runner, version map[string]string) error {
+	res, err := r.run("check", map[string]interface{}{}, nil)
+	if err != nil {
+		return err
+	}
+	if err := res.succeeded(); err != nil {
+		return err
+	}
+
+	var checked versions
+	if err := res.decode(&checked); err != nil {
+		return err
+	}
+	if len(checked) != 1 {
+		return fmt.Errorf("expected one checked version, got %v", checked)
+	}
+	return expectEqual("version", version, checked[0])
+}
+
+// errorFile decodes the error.json that the command wrote
+func (res *result) errorFile() (map[string]interface{}, error) {
+	data, err := res.file("error.json")
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &fields); err != nil {
+		return nil, fmt.Errorf("invalid error.json: %s", err)
+	}
+	return fields, nil
+}
+
+func expectEqual(what string, got, want interface{}) error {
+	if !reflect.DeepEqual(got, want) {
+		return fmt.Errorf("unexpected %s: got %v, want %v", what, got, want)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package concourse
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// commandNames are the commands that can be given as the first argument
+// when the executable isn't linked under the name of a command, f.ex.
+// "lambda-resource check".
+var commandNames = map[string]bool{
+	"check":    true,
+	"in":       true,
+	"out":      true,
+	"info":     true,
+	"artifact": true,
+}
+
+// parseArgs reads the command name, the flags, and the remaining
+// arguments.
+func (ctx *CommandContext) parseArgs(args []string) ([]string, error) {
+	ctx.executable = args[0]
+	ctx.commandName = filepath.Base(args[0])
+
+	args, err := ctx.parseFlags(args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	if !commandNames[ctx.commandName] && len(args) > 0 && commandNames[args[0]] {
+		ctx.subcommand = true
+		ctx.commandName = args[0]
+		args = args[1:]
+	}
+
+	return args, nil
+}
+
+// parseFlags removes the flags from the arguments. Flags can be given
+// anywhere after the command, as "--name value" or "--name=value", flags
+// without values as "--name".
+func (ctx *CommandContext) parseFlags(args []string) ([]string, error) {
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "--") {
+			rest = append(rest, arg)
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(arg[2:], "=")
+		switch name {
+		case "input":
+			if !hasValue {
+				if i+1 >= len(args) {
+					return nil, errors.New("--input needs a file name")
+				}
+				i++
+				value = args[i]
+			}
+
+			data, err := ioutil.ReadFile(value)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to read the input file")
+			}
+			ctx.in = bytes.NewReader(data)
+		case "validate":
+			if hasValue {
+				return nil, errors.Errorf("%s doesn't take a value", arg)
+			}
+			ctx.validateOnly = true
+		case "version":
+			if hasValue {
+				return nil, errors.Errorf("%s doesn't take a value", arg)
+			}
+			ctx.printVersion = true
+		default:
+			return nil, errors.Errorf("unknown flag %q", arg)
+		}
+	}
+	return rest, nil
+}
+
+// validateInput validates the decoded input of the command without running
+// it, for the --validate flag. Nothing is written to the output.
+func (ctx *CommandContext) validateInput(cmd CommandHandler) error {
+	if v, ok := cmd.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			ctx.Errorf("invalid input: %s", err.Error())
+			return ConfigError(errors.Wrap(err, "invalid input"))
+		}
+	}
+
+	ctx.Infof("the input is valid for %s", ctx.commandName)
+	return nil
+}
@@ -0,0 +1,549 @@
+package concourse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CommandContext is passed to the in, out, and check commands. It's a
+// context.Context that is cancelled when the command times out, and a
+// Logger that writes leveled messages to Log. Secrets that are registered
+// with Redact are replaced in everything written to Log.
+type CommandContext struct {
+	context.Context
+	*Logger
+
+	directory    string
+	commandName  string
+	executable   string
+	subcommand   bool
+	validateOnly bool
+	printVersion bool
+	v2           bool
+	responsePath string
+	redactor     *redactWriter
+	in           io.Reader
+	out          io.Writer
+	Log          io.Writer
+}
+
+// Resource is the default resource implementation
+type Resource struct {
+	Check CommandHandler
+	In    CommandHandler
+	Out   CommandHandler
+	// Middleware is the middleware that the commands run in, the first
+	// one is the outermost.
+	Middleware []Middleware
+	// Build identifies the build of the executable
+	Build Build
+}
+
+// CheckHandler returns the registered check handler
+func (r *Resource) CheckHandler() CommandHandler {
+	return r.Check
+}
+
+// InHandler returns the registered get handler
+func (r *Resource) InHandler() CommandHandler {
+	return r.In
+}
+
+// OutHandler returns the registered put handler
+func (r *Resource) OutHandler() CommandHandler {
+	return r.Out
+}
+
+// ResourceHandler provides handler implementations
+type ResourceHandler interface {
+	CheckHandler() CommandHandler
+	InHandler() CommandHandler
+	OutHandler() CommandHandler
+}
+
+// CommandHandler handles a /opt/resource/{in,out,check} concourse command
+type CommandHandler interface {
+	HandleCommand(ctx *CommandContext) (*CommandResponse, error)
+}
+
+// LogFormatHandler is implemented by command handlers that choose the
+// format of the log. The format is read after the input has been decoded,
+// messages before that are written as text.
+type LogFormatHandler interface {
+	LogFormat() (Format, error)
+}
+
+// TimeoutHandler is implemented by command handlers that limit how long
+// the command may run. The timeout is read after the input has been
+// decoded, zero means no timeout.
+type TimeoutHandler interface {
+	CommandTimeout() (time.Duration, error)
+}
+
+// ResourceVersion is arbitrary version info that identifies ar
+type ResourceVersion map[string]string
+
+// CommandResponse is what get's returned to Concourse (JSON on Stdout) when
+// we've successfully completed the task.
+type CommandResponse struct {
+	Version  ResourceVersion           `json:"version"`
+	Versions []ResourceVersion         `json:"-"`
+	Metadata []CommandResponseMetadata `json:"metadata"`
+}
+
+// VersionsResponse is a utility function to create a check response
+func VersionsResponse(version ...ResourceVersion) []ResourceVersion {
+	return version
+}
+
+// AddMeta is a helper function for appending new metadata entries.
+// Concourse shows the entries in the order that they were added.
+func (cr *CommandResponse) AddMeta(name, value string) {
+	cr.Metadata = append(cr.Metadata, CommandResponseMetadata{
+		Name:  name,
+		Value: value,
+	})
+}
+
+// SetMeta sets the value of the first entry with the name, keeping its
+// position, or appends a new entry if there is none.
+func (cr *CommandResponse) SetMeta(name, value string) {
+	for i := range cr.Metadata {
+		if cr.Metadata[i].Name == name {
+			cr.Metadata[i].Value = value
+			return
+		}
+	}
+	cr.AddMeta(name, value)
+}
+
+// Meta returns the value of the first entry with the name
+func (cr *CommandResponse) Meta(name string) (string, bool) {
+	for _, m := range cr.Metadata {
+		if m.Name == name {
+			return m.Value, true
+		}
+	}
+	return "", false
+}
+
+// AddMetaLines appends an entry with one line per value, Concourse keeps
+// the line breaks. Nothing is added if there are no lines.
+func (cr *CommandResponse) AddMetaLines(name string, lines ...string) {
+	if len(lines) == 0 {
+		return
+	}
+	cr.AddMeta(name, strings.Join(lines, "\n"))
+}
+
+// AddMetaURL appends an entry with a URL. Concourse shows values that are
+// absolute http or https URLs as links, so other values are rejected.
+func (cr *CommandResponse) AddMetaURL(name, link string) error {
+	u, err := url.Parse(link)
+	if err != nil {
+		return errors.Wrapf(err, "invalid URL for %q", name)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.Errorf("%q isn't an absolute http or https URL", link)
+	}
+	cr.AddMeta(name, u.String())
+	return nil
+}
+
+// CommandResponseMetadata is a metadata entry in our CommandResponse.
+type CommandResponseMetadata struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// NewContext creates a new context
+func NewContext(
+	args []string, in io.Reader, out io.Writer, log io.Writer,
+) (*CommandContext, error) {
+	redactor := &redactWriter{w: log}
+	logger := NewLogger(redactor)
+	ctx := &CommandContext{
+		Context:  context.Background(),
+		Logger:   logger,
+		redactor: redactor,
+		in:       in,
+		out:      out,
+		Log:      &logWriter{logger: logger},
+	}
+
+	args, err := ctx.parseArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	// Protocol v2 commands are run as "artifact <command> [directory]"
+	if ctx.commandName == "artifact" && len(args) > 0 {
+		ctx.v2 = true
+		ctx.commandName = "artifact " + args[0]
+		if name, ok := v2Commands[args[0]]; ok {
+			ctx.commandName = name
+		}
+		args = args[1:]
+	}
+
+	// The command changes into the directory, so a relative directory would
+	// be joined twice by File and JSON.
+	if len(args) > 0 {
+		dir, err := filepath.Abs(args[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid directory %q", args[0])
+		}
+		ctx.directory = dir
+	}
+
+	return ctx, nil
+}
+
+// CommandName returns the name of the command: "check", "in", or "out"
+func (ctx *CommandContext) CommandName() string {
+	return ctx.commandName
+}
+
+// Handle runs the command and exits with a non-zero status if it fails,
+// the exit code depends on the category of the error. The context is
+// cancelled when the process gets SIGTERM or SIGINT, f.ex. when Concourse
+// aborts the build.
+func (ctx *CommandContext) Handle(handler ResourceHandler) {
+	signalCtx, stop := signal.NotifyContext(ctx.Context, syscall.SIGTERM, os.Interrupt)
+	ctx.Context = signalCtx
+
+	err := ctx.Run(handler)
+	stop()
+	if err != nil {
+		os.Exit(ErrorCategory(err).ExitCode)
+	}
+}
+
+// Run runs the command and writes the response to the output. Errors are
+// logged and returned. A panic in the command is logged with its stack
+// trace and returned as an error. The last line of the log of a failed
+// command is a JSON summary of the error with its category.
+func (ctx *CommandContext) Run(handler ResourceHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			ctx.Errorf("the command panicked: %v", r)
+			_, _ = ctx.Log.Write(debug.Stack())
+			err = NewError(CategoryPanic, ExitFailure,
+				errors.Errorf("the command panicked: %v", r))
+		}
+		if err != nil {
+			ctx.logErrorSummary(err)
+		}
+	}()
+
+	if ctx.printVersion {
+		bh, ok := handler.(BuildHandler)
+		if !ok {
+			return errors.New("the resource doesn't have a version")
+		}
+		_, err := fmt.Fprintln(ctx.out, bh.ResourceBuild().String())
+		return errors.Wrap(err, "failed to write the version")
+	}
+
+	var cmdHandler CommandHandler
+
+	switch ctx.commandName {
+	case "info":
+		ctx.logBuild(handler)
+		return ctx.writeInfo()
+	case "out":
+		cmdHandler = handler.OutHandler()
+		if cmdHandler == nil {
+			ctx.Warnf("the command %q is not implemented", ctx.commandName)
+			_, _ = ctx.out.Write([]byte("{}"))
+			return nil
+		}
+	case "in":
+		cmdHandler = handler.InHandler()
+		if cmdHandler == nil {
+			ctx.Warnf("the command %q is not implemented", ctx.commandName)
+			_, _ = ctx.out.Write([]byte("{}"))
+			return nil
+		}
+	case "check":
+		cmdHandler = handler.CheckHandler()
+		if cmdHandler == nil {
+			ctx.Warnf("the command %q is not implemented", ctx.commandName)
+			_, _ = ctx.out.Write([]byte("[]"))
+			return nil
+		}
+	default:
+		ctx.Errorf("unknown command: %q", ctx.commandName)
+		return ConfigError(errors.Errorf("unknown command: %q", ctx.commandName))
+	}
+
+	ctx.SetField("command", ctx.commandName)
+
+	// Decode the input as the selected command, and switch to the log
+	// format that it asks for before anything else is logged.
+	if err := ctx.decode(cmdHandler); err != nil {
+		ctx.logBuild(handler)
+		ctx.Errorf("%s", err.Error())
+		return ConfigError(err)
+	}
+	if fh, ok := cmdHandler.(LogFormatHandler); ok {
+		format, err := fh.LogFormat()
+		if err != nil {
+			ctx.logBuild(handler)
+			ctx.Errorf("invalid log format: %s", err.Error())
+			return ConfigError(errors.Wrap(err, "invalid log format"))
+		}
+		ctx.SetFormat(format)
+	}
+	ctx.logBuild(handler)
+
+	// Change directory if specified
+	if ctx.directory != "" {
+		if err := os.Chdir(ctx.directory); err != nil {
+			ctx.Errorf("failed to change directory to %q", ctx.directory)
+		}
+	}
+
+	// Limit how long the command may run
+	var timeout time.Duration
+	if th, ok := cmdHandler.(TimeoutHandler); ok {
+		t, err := th.CommandTimeout()
+		if err != nil {
+			ctx.Errorf("invalid command timeout: %s", err.Error())
+			return ConfigError(errors.Wrap(err, "invalid command timeout"))
+		}
+		timeout = t
+	}
+
+	// With --validate the input is checked without running the command
+	if ctx.validateOnly {
+		return ctx.validateInput(cmdHandler)
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx.Context, cancel = context.WithTimeout(ctx.Context, timeout)
+		defer cancel()
+	}
+
+	// Run the command handler in the middleware, with validation as the
+	// innermost middleware.
+	var middleware []Middleware
+	if mh, ok := handler.(MiddlewareHandler); ok {
+		middleware = append(middleware, mh.CommandMiddleware()...)
+	}
+	middleware = append(middleware, Validate())
+
+	res, err := chain(handleCommand, middleware...)(ctx, cmdHandler)
+	if err != nil {
+		aborted := ctx.Err() == context.Canceled
+		switch {
+		case ctx.Err() == context.DeadlineExceeded:
+			ctx.Errorf("the command timed out after %s", timeout)
+		case aborted:
+			ctx.Errorf("the command was aborted")
+		}
+		ctx.Errorf("failed to run command: %s", err.Error())
+
+		err = errors.Wrap(err, "failed to run command")
+		if aborted && ErrorCategory(err).Category == CategoryUnknown {
+			err = NewError(CategoryAborted, ExitAborted, err)
+		}
+		return err
+	}
+
+	if ctx.v2 {
+		if err := ctx.writeV2Response(res); err != nil {
+			ctx.Errorf("%s", err.Error())
+			return err
+		}
+		return nil
+	}
+
+	// Encode our output, with some special-casing for check
+	encoder := json.NewEncoder(ctx.out)
+	if ctx.commandName == "check" {
+		err = encoder.Encode(res.Versions)
+	} else {
+		err = encoder.Encode(res)
+	}
+	if err != nil {
+		ctx.Errorf("failed to encode response: %s", err.Error())
+		return errors.Wrap(err, "failed to encode response")
+	}
+
+	return nil
+}
+
+// logBuild logs the build of the resource, if it's known
+func (ctx *CommandContext) logBuild(handler ResourceHandler) {
+	if bh, ok := handler.(BuildHandler); ok {
+		ctx.Infof("%s", bh.ResourceBuild())
+	}
+}
+
+// decode decodes the input into the command handler. Keys that don't match
+// a field of the handler are reported as unrecognized, so that misspelled
+// params aren't silently ignored.
+func (ctx *CommandContext) decode(cmdHandler CommandHandler) error {
+	data, err := ioutil.ReadAll(ctx.in)
+	if err != nil {
+		return errors.Wrap(err, "failed to read input json")
+	}
+
+	if ctx.v2 {
+		data, err = ctx.v1Request(data, cmdHandler)
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err = ctx.resolveFileParams(data, cmdHandler)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(cmdHandler)
+	if err == nil {
+		return nil
+	}
+
+	unknown, uerr := unknownFields(data, cmdHandler)
+	if uerr == nil && len(unknown) > 0 {
+		return errors.Errorf("unrecognized params: %s", strings.Join(unknown, ", "))
+	}
+	return errors.Wrap(err, "failed to decode input json")
+}
+
+// JSON encodes and writes out a JSON result in the output directory.
+func (ctx *CommandContext) JSON(path string, obj interface{}) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return errors.Wrapf(err,
+			"failed to marshal JSON for writing to %s/%s", ctx.directory, path)
+	}
+	return ctx.File(path, data)
+}
+
+// Path returns the path of a file in the output directory.
+func (ctx *CommandContext) Path(name string) string {
+	return path.Join(ctx.directory, name)
+}
+
+// File writes out a file in the output directory. The mode defaults to
+// 0666 (before the umask), and the parent directories of the file are
+// created. Names that are absolute or escape the output directory, also
+// through symlinks in it, are rejected.
+func (ctx *CommandContext) File(name string, data []byte, mode ...os.FileMode) error {
+	fullPath, err := ctx.filePath(name)
+	if err != nil {
+		return err
+	}
+
+	perm := os.FileMode(0666)
+	if len(mode) > 0 {
+		perm = mode[0]
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return errors.Wrapf(err, "failed to create the directory of %s", fullPath)
+	}
+	if err := ioutil.WriteFile(fullPath, data, perm); err != nil {
+		return errors.Wrapf(err, "failed to write data to %s", fullPath)
+	}
+
+	// The mode is only used when the file is created
+	if len(mode) > 0 {
+		return errors.Wrapf(os.Chmod(fullPath, perm),
+			"failed to set the mode of %s", fullPath)
+	}
+	return nil
+}
+
+// filePath returns the path of a file in the output directory, or an error
+// if the name, or a symlink in the directory, would put it outside of the
+// directory.
+func (ctx *CommandContext) filePath(name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return "", errors.Errorf(
+			"the file name %q must be relative to the output directory", name)
+	}
+
+	clean := filepath.Clean(name)
+	if clean == "." {
+		return "", errors.Errorf("%q isn't a file name", name)
+	}
+	if clean == ".." ||
+		strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", errors.Errorf(
+			"the file name %q is outside of the output directory", name)
+	}
+
+	fullPath := filepath.Join(ctx.directory, clean)
+
+	// Symlinks that were written to the directory, f.ex. by an unpacked
+	// archive, must not lead the file outside of it.
+	root, err := resolveExisting(ctx.directory)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to resolve the output directory")
+	}
+	resolved, err := resolveExisting(fullPath)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to resolve the file name %q", name)
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." ||
+		strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.Errorf(
+			"the file name %q is a symlink to outside of the output directory", name)
+	}
+
+	return fullPath, nil
+}
+
+// resolveExisting resolves the symlinks in the part of the path that
+// exists, the rest of the path is appended as it is. Dangling symlinks
+// are rejected since writing to them would create their target.
+func resolveExisting(name string) (string, error) {
+	if name == "" {
+		name = "."
+	}
+
+	rest := ""
+	for {
+		resolved, err := filepath.EvalSymlinks(name)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		if _, err := os.Lstat(name); err == nil {
+			return "", errors.Errorf("%q is a symlink to a missing file", name)
+		}
+
+		parent := filepath.Dir(name)
+		if parent == name {
+			return filepath.Join(name, rest), nil
+		}
+		rest = filepath.Join(filepath.Base(name), rest)
+		name = parent
+	}
+}
@@ -0,0 +1,105 @@
+package concourse
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFilePath(t *testing.T) {
+	dir := filepath.FromSlash("/tmp/build/out")
+	ctx := &CommandContext{directory: dir}
+
+	valid := map[string]string{
+		"version":       "version",
+		"logs/logs.txt": "logs/logs.txt",
+		"a/../version":  "version",
+		"./version":     "version",
+		"..version":     "..version",
+	}
+	for name, want := range valid {
+		got, err := ctx.filePath(name)
+		if err != nil {
+			t.Errorf("filePath(%q) failed: %v", name, err)
+			continue
+		}
+		if want := filepath.Join(dir, filepath.FromSlash(want)); got != want {
+			t.Errorf("filePath(%q) = %q, want %q", name, got, want)
+		}
+	}
+
+	for _, name := range []string{"", ".", "..", "../version", "a/../../version", "/etc/passwd"} {
+		if got, err := ctx.filePath(name); err == nil {
+			t.Errorf("filePath(%q) = %q, want an error", name, got)
+		}
+	}
+}
+
+func TestFilePathSymlinks(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "filepath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	dir := filepath.Join(tmp, "out")
+	outside := filepath.Join(tmp, "outside")
+	for _, d := range []string{filepath.Join(dir, "sub"), outside} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"inside":  filepath.Join(dir, "sub"),
+		"escape":  outside,
+		"file":    filepath.Join(outside, "file"),
+		"missing": filepath.Join(outside, "missing"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(outside, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := &CommandContext{directory: dir}
+	for _, name := range []string{"version", "sub/version", "inside/version", "new/dir/version"} {
+		if _, err := ctx.filePath(name); err != nil {
+			t.Errorf("filePath(%q) failed: %v", name, err)
+		}
+	}
+	for _, name := range []string{"escape/version", "escape/new/version", "file", "missing"} {
+		if got, err := ctx.filePath(name); err == nil {
+			t.Errorf("filePath(%q) = %q, want an error", name, got)
+		}
+	}
+}
+
+func TestRunNotImplemented(t *testing.T) {
+	tests := map[string]string{
+		"check": "[]",
+		"in":    "{}",
+		"out":   "{}",
+	}
+	for command, want := range tests {
+		var stdout bytes.Buffer
+		ctx, err := NewContext(
+			[]string{command, "."}, strings.NewReader("{}"), &stdout, ioutil.Discard,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ctx.Run(&Resource{}); err != nil {
+			t.Errorf("%s failed: %v", command, err)
+		}
+		if stdout.String() != want {
+			t.Errorf("%s wrote %q, want %q", command, stdout.String(), want)
+		}
+	}
+}
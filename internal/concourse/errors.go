@@ -0,0 +1,118 @@
+package concourse
+
+import (
+	"encoding/json"
+)
+
+const (
+	// ExitFailure is the exit code of commands that fail for an
+	// uncategorised reason.
+	ExitFailure = 1
+	// ExitConfig is the exit code of commands that fail because of invalid
+	// input.
+	ExitConfig = 2
+	// ExitAborted is the exit code of commands that were aborted by a
+	// signal, following the shell convention for SIGTERM.
+	ExitAborted = 128 + 15
+)
+
+const (
+	// CategoryUnknown is the category of uncategorised errors
+	CategoryUnknown = "unknown"
+	// CategoryConfig is the category of errors caused by invalid input
+	CategoryConfig = "config"
+	// CategoryPanic is the category of commands that panicked
+	CategoryPanic = "panic"
+	// CategoryAborted is the category of commands that were aborted
+	CategoryAborted = "aborted"
+)
+
+// Error is an error with a category and the exit code it should result in.
+// The category and exit code of the first Error in the cause chain of a
+// command error are used when the command exits.
+type Error struct {
+	Category string
+	ExitCode int
+	Err      error
+}
+
+// NewError categorises an error, nil errors are returned as nil
+func NewError(category string, exitCode int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Category: category, ExitCode: exitCode, Err: err}
+}
+
+// ConfigError categorises an error as caused by invalid input
+func ConfigError(err error) error {
+	return NewError(CategoryConfig, ExitConfig, err)
+}
+
+// Error returns the message of the underlying error
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Cause returns the underlying error
+func (e *Error) Cause() error {
+	return e.Err
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrorCategory returns the first Error in the cause chain of the error,
+// or an uncategorised Error.
+func ErrorCategory(err error) *Error {
+	for cause := err; cause != nil; {
+		if e, ok := cause.(*Error); ok {
+			return e
+		}
+		switch wrapper := cause.(type) {
+		case interface{ Cause() error }:
+			cause = wrapper.Cause()
+		case interface{ Unwrap() error }:
+			cause = wrapper.Unwrap()
+		default:
+			cause = nil
+		}
+	}
+	return &Error{Category: CategoryUnknown, ExitCode: ExitFailure, Err: err}
+}
+
+// errorSummary is the last line that is written to the log when a command
+// fails, for tools that classify build failures.
+type errorSummary struct {
+	Category string `json:"category"`
+	ExitCode int    `json:"exit_code"`
+	Message  string `json:"message"`
+}
+
+// logErrorSummary writes a JSON summary of the error to the log. In the
+// JSON log format the summary is an error message with the category and
+// exit code as fields.
+func (ctx *CommandContext) logErrorSummary(err error) {
+	category := ErrorCategory(err)
+	if ctx.Logger.format == FormatJSON {
+		ctx.Logger.write(LevelError, err.Error(), map[string]interface{}{
+			"category":  category.Category,
+			"exit_code": category.ExitCode,
+		})
+		return
+	}
+
+	data, merr := json.Marshal(map[string]errorSummary{
+		"error": {
+			Category: category.Category,
+			ExitCode: category.ExitCode,
+			Message:  err.Error(),
+		},
+	})
+	if merr != nil {
+		return
+	}
+	_, _ = ctx.Log.Write(append(data, '\n'))
+}
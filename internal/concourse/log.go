@@ -0,0 +1,191 @@
+package concourse
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Level is the severity of a log message
+type Level int
+
+// Log levels
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// String returns the name of the level
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warning"
+	case LevelError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// Format is the format of the log
+type Format string
+
+// Log formats
+const (
+	// FormatText writes messages as plain lines, prefixed with the level
+	// unless they are informational.
+	FormatText Format = "text"
+	// FormatJSON writes every message as a JSON object on a line of its
+	// own, with the time, level, message, and fields.
+	FormatJSON Format = "json"
+)
+
+// ParseFormat parses the name of a log format, empty means text
+func ParseFormat(name string) (Format, error) {
+	switch Format(name) {
+	case "", FormatText:
+		return FormatText, nil
+	case FormatJSON:
+		return FormatJSON, nil
+	}
+	return "", errors.Errorf("unsupported log format %q", name)
+}
+
+// Logger writes leveled messages to the build log. Messages below the
+// level of the logger are discarded.
+type Logger struct {
+	w      io.Writer
+	level  Level
+	format Format
+	fields map[string]interface{}
+}
+
+// logRecord is a message in the JSON format
+type logRecord struct {
+	Time    string                 `json:"time"`
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// NewLogger creates a logger that writes info and more severe messages to w
+func NewLogger(w io.Writer) *Logger {
+	return &Logger{w: w, level: LevelInfo, format: FormatText}
+}
+
+// SetFormat sets the format of the messages
+func (l *Logger) SetFormat(format Format) {
+	l.format = format
+}
+
+// SetField adds a field to every message. Fields are only written in the
+// JSON format.
+func (l *Logger) SetField(key string, value interface{}) {
+	if l.fields == nil {
+		l.fields = map[string]interface{}{}
+	}
+	l.fields[key] = value
+}
+
+// SetLevel sets the lowest level that is logged
+func (l *Logger) SetLevel(level Level) {
+	l.level = level
+}
+
+// Enabled checks if messages of the level are logged
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+// Logf writes a message with the level. Info messages are written as is,
+// other messages are prefixed with the level.
+func (l *Logger) Logf(level Level, format string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	l.write(level, fmt.Sprintf(format, args...), nil)
+}
+
+// write writes a message in the format of the logger, the fields are
+// added to the fields of the logger.
+func (l *Logger) write(level Level, msg string, fields map[string]interface{}) {
+	if l.format == FormatJSON {
+		record := logRecord{
+			Time:    time.Now().UTC().Format(time.RFC3339Nano),
+			Level:   level.String(),
+			Message: strings.TrimSuffix(msg, "\n"),
+		}
+		if len(l.fields) > 0 || len(fields) > 0 {
+			record.Fields = make(map[string]interface{}, len(l.fields)+len(fields))
+			for k, v := range l.fields {
+				record.Fields[k] = v
+			}
+			for k, v := range fields {
+				record.Fields[k] = v
+			}
+		}
+
+		data, err := json.Marshal(record)
+		if err != nil {
+			return
+		}
+		_, _ = l.w.Write(append(data, '\n'))
+		return
+	}
+
+	if level != LevelInfo {
+		msg = strings.ToUpper(level.String()) + ": " + msg
+	}
+	if !strings.HasSuffix(msg, "\n") {
+		msg += "\n"
+	}
+	_, _ = io.WriteString(l.w, msg)
+}
+
+// logWriter is the writer of CommandContext.Log. In the text format the
+// data is written as is, in the JSON format every write becomes an info
+// message.
+type logWriter struct {
+	logger *Logger
+}
+
+// Write writes p to the log
+func (w *logWriter) Write(p []byte) (int, error) {
+	if w.logger.format != FormatJSON {
+		return w.logger.w.Write(p)
+	}
+	if len(p) > 0 {
+		w.logger.write(LevelInfo, string(p), nil)
+	}
+	return len(p), nil
+}
+
+// Debugf writes a debug message
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.Logf(LevelDebug, format, args...)
+}
+
+// Infof writes an informational message
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.Logf(LevelInfo, format, args...)
+}
+
+// Warnf writes a warning
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.Logf(LevelWarn, format, args...)
+}
+
+// Errorf writes an error message
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.Logf(LevelError, format, args...)
+}
@@ -0,0 +1,75 @@
+package concourse
+
+import (
+	"net/url"
+	"os"
+	"strings"
+)
+
+// The environment variables that Concourse sets to describe the build
+const (
+	EnvBuildID           = "BUILD_ID"
+	EnvBuildName         = "BUILD_NAME"
+	EnvBuildJobName      = "BUILD_JOB_NAME"
+	EnvBuildPipelineName = "BUILD_PIPELINE_NAME"
+	EnvBuildTeamName     = "BUILD_TEAM_NAME"
+	EnvATCExternalURL    = "ATC_EXTERNAL_URL"
+)
+
+// BuildMetadata is the metadata of the build that runs the command. The
+// fields are empty for check, which doesn't run in a build, and the job
+// fields are empty for one-off builds.
+type BuildMetadata struct {
+	ID           string
+	Name         string
+	JobName      string
+	PipelineName string
+	TeamName     string
+	ExternalURL  string
+}
+
+// BuildMetadata returns the metadata of the build from the environment
+func (ctx *CommandContext) BuildMetadata() BuildMetadata {
+	return BuildMetadata{
+		ID:           os.Getenv(EnvBuildID),
+		Name:         os.Getenv(EnvBuildName),
+		JobName:      os.Getenv(EnvBuildJobName),
+		PipelineName: os.Getenv(EnvBuildPipelineName),
+		TeamName:     os.Getenv(EnvBuildTeamName),
+		ExternalURL:  os.Getenv(EnvATCExternalURL),
+	}
+}
+
+// Env returns the metadata keyed by the names of the environment variables
+func (m BuildMetadata) Env() map[string]string {
+	return map[string]string{
+		EnvBuildID:           m.ID,
+		EnvBuildName:         m.Name,
+		EnvBuildJobName:      m.JobName,
+		EnvBuildPipelineName: m.PipelineName,
+		EnvBuildTeamName:     m.TeamName,
+		EnvATCExternalURL:    m.ExternalURL,
+	}
+}
+
+// URL returns the URL of the build in the web UI, or an empty string if
+// the metadata isn't enough to build it.
+func (m BuildMetadata) URL() string {
+	if m.ExternalURL == "" {
+		return ""
+	}
+	base := strings.TrimRight(m.ExternalURL, "/")
+
+	if m.TeamName == "" || m.PipelineName == "" || m.JobName == "" || m.Name == "" {
+		if m.ID == "" {
+			return ""
+		}
+		return base + "/builds/" + url.PathEscape(m.ID)
+	}
+
+	return base +
+		"/teams/" + url.PathEscape(m.TeamName) +
+		"/pipelines/" + url.PathEscape(m.PipelineName) +
+		"/jobs/" + url.PathEscape(m.JobName) +
+		"/builds/" + url.PathEscape(m.Name)
+}
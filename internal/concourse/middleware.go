@@ -0,0 +1,92 @@
+package concourse
+
+import (
+	"runtime/debug"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CommandFunc runs a decoded command
+type CommandFunc func(ctx *CommandContext, cmd CommandHandler) (*CommandResponse, error)
+
+// Middleware wraps the running of a command, f.ex. to do something before
+// and after every command.
+type Middleware func(next CommandFunc) CommandFunc
+
+// MiddlewareHandler is implemented by resource handlers that run their
+// commands in middleware. The first middleware is the outermost one.
+type MiddlewareHandler interface {
+	CommandMiddleware() []Middleware
+}
+
+// CommandMiddleware returns the registered middleware
+func (r *Resource) CommandMiddleware() []Middleware {
+	return r.Middleware
+}
+
+// handleCommand is the innermost CommandFunc
+func handleCommand(ctx *CommandContext, cmd CommandHandler) (*CommandResponse, error) {
+	return cmd.HandleCommand(ctx)
+}
+
+// chain wraps run in the middleware, the first middleware is outermost
+func chain(run CommandFunc, middleware ...Middleware) CommandFunc {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		run = middleware[i](run)
+	}
+	return run
+}
+
+// Validate calls Validate on commands that implement Validator, and
+// returns validation errors as config errors without running the command.
+// Run adds it as the innermost middleware.
+func Validate() Middleware {
+	return func(next CommandFunc) CommandFunc {
+		return func(ctx *CommandContext, cmd CommandHandler) (*CommandResponse, error) {
+			if v, ok := cmd.(Validator); ok {
+				if err := v.Validate(); err != nil {
+					return nil, ConfigError(errors.Wrap(err, "invalid input"))
+				}
+			}
+			return next(ctx, cmd)
+		}
+	}
+}
+
+// Recover turns a panic in the command into an error, and logs the stack
+// trace. Run recovers from panics as well, but middleware that is outside
+// of Recover sees the panic as an error.
+func Recover() Middleware {
+	return func(next CommandFunc) CommandFunc {
+		return func(ctx *CommandContext, cmd CommandHandler) (res *CommandResponse, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					_, _ = ctx.Log.Write(debug.Stack())
+					err = NewError(CategoryPanic, ExitFailure,
+						errors.Errorf("the command panicked: %v", r))
+				}
+			}()
+			return next(ctx, cmd)
+		}
+	}
+}
+
+// Timing logs how long the command took as a debug message
+func Timing() Middleware {
+	return func(next CommandFunc) CommandFunc {
+		return func(ctx *CommandContext, cmd CommandHandler) (*CommandResponse, error) {
+			start := time.Now()
+			res, err := next(ctx, cmd)
+
+			result := "succeeded"
+			if err != nil {
+				result = "failed"
+			}
+			ctx.Debugf("%s %s after %s", ctx.commandName, result,
+				time.Since(start).Round(time.Millisecond))
+
+			return res, err
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package concourse
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FileParamSuffix is the suffix of params that are read from a file. Any
+// param can be given as "<name>_file", f.ex. "alias_file", unless the
+// command has a param with that name.
+const FileParamSuffix = "_file"
+
+// fileParamField returns the field of the params type that a
+// "<name>_file" key sets, if the key isn't a field of its own.
+func fileParamField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || !strings.HasSuffix(key, FileParamSuffix) {
+		return reflect.StructField{}, false
+	}
+
+	fields := jsonFields(t)
+	if _, ok := matchField(fields, key); ok {
+		return reflect.StructField{}, false
+	}
+	return matchField(fields, strings.TrimSuffix(key, FileParamSuffix))
+}
+
+// resolveFileParams replaces the params that are given as "<name>_file"
+// with the contents of the files. Relative paths are relative to the
+// directory of the command.
+func (ctx *CommandContext) resolveFileParams(data []byte, cmdHandler CommandHandler) ([]byte, error) {
+	t := reflect.TypeOf(cmdHandler)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return data, nil
+	}
+	paramsField, ok := jsonFields(t)["params"]
+	if !ok {
+		return data, nil
+	}
+
+	// Malformed input is left for the decoder to report
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return data, nil
+	}
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(doc["params"], &params); err != nil || params == nil {
+		return data, nil
+	}
+
+	var keys []string
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	resolved := map[string]json.RawMessage{}
+	for _, key := range keys {
+		field, ok := fileParamField(paramsField.Type, key)
+		if !ok {
+			resolved[key] = params[key]
+			continue
+		}
+
+		name := strings.TrimSuffix(key, FileParamSuffix)
+		if _, ok := params[name]; ok {
+			return nil, errors.Errorf("%s and %s can't be combined", name, key)
+		}
+
+		var path string
+		if err := json.Unmarshal(params[key], &path); err != nil || path == "" {
+			return nil, errors.Errorf("%s must be the name of a file", key)
+		}
+		value, err := ctx.readFileParam(path, field.Type)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", key)
+		}
+		resolved[name] = value
+	}
+
+	encoded, err := json.Marshal(resolved)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode the params")
+	}
+	doc["params"] = encoded
+	return json.Marshal(doc)
+}
+
+// readFileParam reads the value of a param from a file, without trailing
+// line breaks. Strings are used as they are, other values must be JSON,
+// f.ex. "256" or "true".
+func (ctx *CommandContext) readFileParam(path string, t reflect.Type) (json.RawMessage, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(ctx.directory, path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimRight(data, "\r\n")
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.String {
+		return json.Marshal(string(data))
+	}
+	if !json.Valid(data) {
+		return nil, errors.Errorf("the contents of %q aren't a valid %s value", path, t.Kind())
+	}
+	return data, nil
+}
@@ -0,0 +1,129 @@
+package concourse
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type fileParamsCommand struct {
+	Params struct {
+		Alias      *string `json:"alias"`
+		MemorySize *int    `json:"memory_size"`
+		Publish    bool    `json:"publish"`
+		ConfigFile *string `json:"config_file"`
+	} `json:"params"`
+}
+
+func (cmd *fileParamsCommand) HandleCommand(ctx *CommandContext) (*CommandResponse, error) {
+	return &CommandResponse{}, nil
+}
+
+func TestResolveFileParams(t *testing.T) {
+	dir, err := ioutil.TempDir("", "params")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"alias":    "PROD\n",
+		"memory":   "256\r\n",
+		"publish":  "true",
+		"config":   "config.yml",
+		"abs/file": "STAGE",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := &CommandContext{directory: dir}
+
+	tests := []struct {
+		name   string
+		params string
+		want   string
+	}{
+		{
+			name:   "strings without line breaks",
+			params: `{"alias_file":"alias"}`,
+			want:   `{"alias":"PROD"}`,
+		},
+		{
+			name:   "JSON values",
+			params: `{"memory_size_file":"memory","publish_file":"publish"}`,
+			want:   `{"memory_size":256,"publish":true}`,
+		},
+		{
+			name:   "absolute paths",
+			params: `{"alias_file":"` + filepath.ToSlash(filepath.Join(dir, "abs", "file")) + `"}`,
+			want:   `{"alias":"STAGE"}`,
+		},
+		{
+			name:   "params that end in _file",
+			params: `{"config_file":"config"}`,
+			want:   `{"config_file":"config"}`,
+		},
+		{
+			name:   "unknown params",
+			params: `{"unknown_file":"alias"}`,
+			want:   `{"unknown_file":"alias"}`,
+		},
+	}
+	for _, tt := range tests {
+		data, err := ctx.resolveFileParams(
+			[]byte(`{"source":{},"params":`+tt.params+`}`), &fileParamsCommand{})
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		var got, want struct {
+			Params map[string]interface{} `json:"params"`
+		}
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if err := json.Unmarshal([]byte(`{"params":`+tt.want+`}`), &want); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got.Params, want.Params) {
+			t.Errorf("%s: got %v, want %v", tt.name, got.Params, want.Params)
+		}
+	}
+}
+
+func TestResolveFileParamsErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "params")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "memory"), []byte("lots"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := &CommandContext{directory: dir}
+
+	tests := map[string]string{
+		"both given":    `{"alias":"PROD","alias_file":"alias"}`,
+		"missing file":  `{"alias_file":"missing"}`,
+		"not a name":    `{"alias_file":42}`,
+		"invalid value": `{"memory_size_file":"memory"}`,
+	}
+	for name, params := range tests {
+		_, err := ctx.resolveFileParams(
+			[]byte(`{"params":`+params+`}`), &fileParamsCommand{})
+		if err == nil {
+			t.Errorf("%s: resolved %s without an error", name, params)
+		}
+	}
+}
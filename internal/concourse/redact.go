@@ -0,0 +1,82 @@
+package concourse
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+)
+
+// RedactedValue replaces secrets in the log
+const RedactedValue = "<redacted>"
+
+// minSecretLength is the length of the shortest secret that is redacted,
+// shorter values would garble the log.
+const minSecretLength = 4
+
+// redactWriter replaces secrets in everything that is written through it.
+// Secrets are only replaced within a single write, so writers should write
+// whole lines.
+type redactWriter struct {
+	w io.Writer
+
+	mu      sync.RWMutex
+	secrets [][]byte
+}
+
+// add registers secrets, both as they are and JSON escaped
+func (r *redactWriter) add(secrets ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, secret := range secrets {
+		if len(secret) < minSecretLength {
+			continue
+		}
+		r.secrets = appendSecret(r.secrets, []byte(secret))
+
+		if escaped, err := json.Marshal(secret); err == nil {
+			r.secrets = appendSecret(r.secrets, escaped[1:len(escaped)-1])
+		}
+	}
+
+	// Longer secrets go first, so that a secret that contains another
+	// is replaced as a whole.
+	sort.Slice(r.secrets, func(i, j int) bool {
+		return len(r.secrets[i]) > len(r.secrets[j])
+	})
+}
+
+func appendSecret(secrets [][]byte, secret []byte) [][]byte {
+	for _, s := range secrets {
+		if bytes.Equal(s, secret) {
+			return secrets
+		}
+	}
+	return append(secrets, secret)
+}
+
+// Write writes p with the secrets replaced
+func (r *redactWriter) Write(p []byte) (int, error) {
+	r.mu.RLock()
+	data := p
+	for _, secret := range r.secrets {
+		if bytes.Contains(data, secret) {
+			data = bytes.Replace(data, secret, []byte(RedactedValue), -1)
+		}
+	}
+	r.mu.RUnlock()
+
+	if _, err := r.w.Write(data); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Redact registers secrets that are replaced with RedactedValue in
+// everything that is written to the log. Values shorter than four
+// characters are ignored.
+func (ctx *CommandContext) Redact(secrets ...string) {
+	ctx.redactor.add(secrets...)
+}
@@ -0,0 +1,46 @@
+package concourse
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRedactWriter(t *testing.T) {
+	var buf bytes.Buffer
+	r := &redactWriter{w: &buf}
+	r.add("s3cr3t", "abc", `pa"ss\word`, "s3cr3t-and-more")
+
+	tests := []struct {
+		in, want string
+	}{
+		{"key=s3cr3t\n", "key=<redacted>\n"},
+		{"s3cr3t s3cr3t\n", "<redacted> <redacted>\n"},
+		{"s3cr3t-and-more\n", "<redacted>\n"},
+		{`{"password":"pa\"ss\\word"}` + "\n", `{"password":"<redacted>"}` + "\n"},
+		{`pa"ss\word` + "\n", "<redacted>\n"},
+		{"abc is too short\n", "abc is too short\n"},
+	}
+	for _, tt := range tests {
+		buf.Reset()
+		n, err := r.Write([]byte(tt.in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(tt.in) {
+			t.Errorf("Write(%q) = %d, want %d", tt.in, n, len(tt.in))
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("Write(%q) wrote %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRedactWriterDuplicates(t *testing.T) {
+	r := &redactWriter{w: &bytes.Buffer{}}
+	r.add("s3cr3t")
+	r.add("s3cr3t")
+
+	if len(r.secrets) != 1 {
+		t.Errorf("got %d secrets, want 1", len(r.secrets))
+	}
+}
@@ -0,0 +1,146 @@
+// Package resourcetest runs the commands of a resource in-process, with
+// the input from JSON fixtures, and captures the output for assertions
+// against golden files.
+package resourcetest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+	"github.com/pkg/errors"
+)
+
+// UpdateEnv is the environment variable that makes Golden write the
+// golden files instead of comparing against them.
+const UpdateEnv = "RESOURCETEST_UPDATE"
+
+// Result is the captured output of a command
+type Result struct {
+	// Stdout is the response that was written for Concourse
+	Stdout []byte
+	// Stderr is the build log
+	Stderr []byte
+	// Dir is the directory that the command ran in
+	Dir string
+	// Err is the error that the command failed with
+	Err error
+}
+
+// Run runs the command ("check", "in", or "out") with the payload as
+// input, in a new temporary directory. The caller should remove the
+// directory with Cleanup.
+func Run(
+	handler concourse.ResourceHandler, command string, payload []byte,
+) (*Result, error) {
+	dir, err := ioutil.TempDir("", "resourcetest")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create a temporary directory")
+	}
+	return RunInDir(handler, command, dir, payload)
+}
+
+// RunFixture runs the command with the contents of the fixture file as
+// input, in a new temporary directory.
+func RunFixture(
+	handler concourse.ResourceHandler, command, fixture string,
+) (*Result, error) {
+	payload, err := ioutil.ReadFile(fixture)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read fixture %q", fixture)
+	}
+	return Run(handler, command, payload)
+}
+
+// RunInDir runs the command with the payload as input in an existing
+// directory, f.ex. one with the inputs of a put. The working directory of
+// the process is restored afterwards.
+func RunInDir(
+	handler concourse.ResourceHandler, command, dir string, payload []byte,
+) (*Result, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the working directory")
+	}
+	defer os.Chdir(wd)
+
+	var stdout, stderr bytes.Buffer
+	ctx, err := concourse.NewContext(
+		[]string{command, dir}, bytes.NewReader(payload), &stdout, &stderr,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create command context")
+	}
+
+	runErr := ctx.Run(handler)
+
+	return &Result{
+		Stdout: stdout.Bytes(),
+		Stderr: stderr.Bytes(),
+		Dir:    dir,
+		Err:    runErr,
+	}, nil
+}
+
+// Files returns the contents of the files that are in the directory,
+// keyed by their path relative to the directory.
+func (r *Result) Files() (map[string][]byte, error) {
+	files := map[string][]byte{}
+	err := filepath.Walk(r.Dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(r.Dir, name)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = data
+		return nil
+	})
+	return files, errors.Wrapf(err, "failed to read the files in %q", r.Dir)
+}
+
+// Cleanup removes the directory that the command ran in
+func (r *Result) Cleanup() error {
+	return os.RemoveAll(r.Dir)
+}
+
+// TB is the part of testing.TB that is used to report mismatches
+type TB interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// Golden compares the data with the contents of the golden file. When
+// RESOURCETEST_UPDATE is set the golden file is written instead.
+func Golden(t TB, golden string, data []byte) {
+	t.Helper()
+
+	if os.Getenv(UpdateEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+			t.Errorf("failed to create the directory of %q: %v", golden, err)
+			return
+		}
+		if err := ioutil.WriteFile(golden, data, 0644); err != nil {
+			t.Errorf("failed to write golden file %q: %v", golden, err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Errorf("failed to read golden file %q (set %s to create it): %v",
+			golden, UpdateEnv, err)
+		return
+	}
+	if !bytes.Equal(data, want) {
+		t.Errorf("output doesn't match %q:\ngot:\n%s\nwant:\n%s", golden, data, want)
+	}
+}
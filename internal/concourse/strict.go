@@ -0,0 +1,148 @@
+package concourse
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Validator is implemented by command handlers that validate their input.
+// Validate is called after the input has been decoded, and before the
+// command is run.
+type Validator interface {
+	Validate() error
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// unknownFields returns the dotted paths of the object keys in the JSON
+// data that don't match a field in v, sorted by path.
+func unknownFields(data []byte, v interface{}) ([]string, error) {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	var unknown []string
+	collectUnknownFields(doc, reflect.TypeOf(v), "", &unknown)
+	sort.Strings(unknown)
+	return unknown, nil
+}
+
+func collectUnknownFields(
+	doc interface{}, t reflect.Type, path string, unknown *[]string,
+) {
+	for t.Kind() == reflect.Ptr {
+		if customUnmarshaler(t) {
+			return
+		}
+		t = t.Elem()
+	}
+	if customUnmarshaler(t) {
+		return
+	}
+
+	switch value := doc.(type) {
+	case map[string]interface{}:
+		switch t.Kind() {
+		case reflect.Map:
+			for key, item := range value {
+				collectUnknownFields(item, t.Elem(), joinPath(path, key), unknown)
+			}
+		case reflect.Struct:
+			fields := jsonFields(t)
+			for key, item := range value {
+				field, ok := matchField(fields, key)
+				if !ok {
+					*unknown = append(*unknown, joinPath(path, key))
+					continue
+				}
+				collectUnknownFields(item, field.Type, joinPath(path, key), unknown)
+			}
+		}
+	case []interface{}:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return
+		}
+		for _, item := range value {
+			collectUnknownFields(item, t.Elem(), path+"[]", unknown)
+		}
+	}
+}
+
+// customUnmarshaler reports if values of the type decode themselves, in
+// which case any keys are accepted.
+func customUnmarshaler(t reflect.Type) bool {
+	if t.Kind() == reflect.Interface {
+		return true
+	}
+	pt := reflect.PtrTo(t)
+	return t.Implements(jsonUnmarshalerType) || pt.Implements(jsonUnmarshalerType) ||
+		t.Implements(textUnmarshalerType) || pt.Implements(textUnmarshalerType)
+}
+
+// jsonFields returns the fields that encoding/json decodes into, keyed by
+// name, including the fields of embedded structs.
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for n, ef := range jsonFields(ft) {
+					if _, ok := fields[n]; !ok {
+						fields[n] = ef
+					}
+				}
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+// matchField finds the field for a key the way encoding/json does,
+// preferring an exact match over a case-insensitive one.
+func matchField(
+	fields map[string]reflect.StructField, key string,
+) (reflect.StructField, bool) {
+	if f, ok := fields[key]; ok {
+		return f, true
+	}
+	for name, f := range fields {
+		if strings.EqualFold(name, key) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
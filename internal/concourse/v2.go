@@ -0,0 +1,170 @@
+package concourse
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Protocol v2 is the artifacts interface of the generalized resources RFC
+// (https://github.com/concourse/rfcs/pull/24). The "info" executable
+// describes the interface, and "artifact check", "artifact get <dir>", and
+// "artifact put <dir>" run the check, in, and out handlers. The request
+// has a single config instead of source and params, and the responses are
+// written to the response path as a stream of JSON objects.
+
+// APIVersionV2 is the version of the artifacts interface
+const APIVersionV2 = "2.0"
+
+// v2Commands maps the artifact commands to the handlers
+var v2Commands = map[string]string{
+	"check": "check",
+	"get":   "in",
+	"put":   "out",
+}
+
+// InfoResponse is written by the "info" command
+type InfoResponse struct {
+	Artifacts ArtifactsInfo `json:"artifacts"`
+}
+
+// ArtifactsInfo describes the artifacts interface of the resource
+type ArtifactsInfo struct {
+	APIVersion string `json:"api_version"`
+	Check      string `json:"check"`
+	Get        string `json:"get"`
+	Put        string `json:"put"`
+}
+
+// v2Request is the input of the artifact commands
+type v2Request struct {
+	Config       map[string]json.RawMessage `json:"config"`
+	Version      json.RawMessage            `json:"version,omitempty"`
+	ResponsePath string                     `json:"response_path"`
+}
+
+// v2Response is a response object written to the response path
+type v2Response struct {
+	Version  ResourceVersion           `json:"version"`
+	Metadata []CommandResponseMetadata `json:"metadata,omitempty"`
+}
+
+// writeInfo writes the description of the artifacts interface
+func (ctx *CommandContext) writeInfo() error {
+	artifact := filepath.Join(filepath.Dir(ctx.executable), "artifact")
+	if ctx.subcommand {
+		artifact = ctx.executable + " artifact"
+	}
+	info := InfoResponse{
+		Artifacts: ArtifactsInfo{
+			APIVersion: APIVersionV2,
+			Check:      artifact + " check",
+			Get:        artifact + " get",
+			Put:        artifact + " put",
+		},
+	}
+	return errors.Wrap(json.NewEncoder(ctx.out).Encode(info),
+		"failed to encode info response")
+}
+
+// v1Request translates a v2 request to the v1 input of the handler. The
+// config keys are split between the source and the params of the handler
+// by their field names, keys that match both go to both.
+func (ctx *CommandContext) v1Request(data []byte, cmdHandler CommandHandler) ([]byte, error) {
+	var req v2Request
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, errors.Wrap(err, "failed to decode input json")
+	}
+	if req.ResponsePath == "" {
+		return nil, errors.New("the request has no response_path")
+	}
+	ctx.responsePath = req.ResponsePath
+
+	t := reflect.TypeOf(cmdHandler)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	fields := jsonFields(t)
+
+	source := map[string]json.RawMessage{}
+	params := map[string]json.RawMessage{}
+	var unknown []string
+	for key, value := range req.Config {
+		matched := false
+		for name, target := range map[string]map[string]json.RawMessage{
+			"source": source, "params": params,
+		} {
+			field, ok := fields[name]
+			if !ok {
+				continue
+			}
+			_, fileParam := fileParamField(field.Type, key)
+			if !hasJSONField(field.Type, key) && !(name == "params" && fileParam) {
+				continue
+			}
+			target[key] = value
+			matched = true
+		}
+		if !matched {
+			unknown = append(unknown, "config."+key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, errors.Errorf("unrecognized params: %s", strings.Join(unknown, ", "))
+	}
+
+	v1 := map[string]interface{}{"source": source}
+	if _, ok := fields["params"]; ok {
+		v1["params"] = params
+	}
+	if len(req.Version) > 0 {
+		v1["version"] = req.Version
+	}
+
+	out, err := json.Marshal(v1)
+	return out, errors.Wrap(err, "failed to translate the v2 request")
+}
+
+// hasJSONField checks if the struct type has a field for the key
+func hasJSONField(t reflect.Type, key string) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	_, ok := matchField(jsonFields(t), key)
+	return ok
+}
+
+// writeV2Response writes the versions of a check, or the version of a
+// get or put, to the response path.
+func (ctx *CommandContext) writeV2Response(res *CommandResponse) error {
+	f, err := os.OpenFile(ctx.responsePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open response path %q", ctx.responsePath)
+	}
+
+	encoder := json.NewEncoder(f)
+	if ctx.commandName == "check" {
+		for _, version := range res.Versions {
+			if err = encoder.Encode(v2Response{Version: version}); err != nil {
+				break
+			}
+		}
+	} else {
+		err = encoder.Encode(v2Response{Version: res.Version, Metadata: res.Metadata})
+	}
+	if err != nil {
+		_ = f.Close()
+		return errors.Wrapf(err, "failed to write to response path %q", ctx.responsePath)
+	}
+
+	return errors.Wrapf(f.Close(), "failed to write to response path %q", ctx.responsePath)
+}
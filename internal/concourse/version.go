@@ -0,0 +1,48 @@
+package concourse
+
+import "strings"
+
+// Build identifies the build of the resource executable. The fields are
+// usually set at build time with -ldflags "-X ...".
+type Build struct {
+	Name    string
+	Version string
+	Commit  string
+	Date    string
+}
+
+// BuildHandler is implemented by resource handlers that know which build
+// of the executable they are. The build is printed by --version and logged
+// at the start of every command.
+type BuildHandler interface {
+	ResourceBuild() Build
+}
+
+// ResourceBuild returns the registered build
+func (r *Resource) ResourceBuild() Build {
+	return r.Build
+}
+
+// String formats the build as f.ex. "my-resource 1.2.0 (commit 4f2a9c1,
+// built 2024-05-01T10:00:00Z)", leaving out the empty fields.
+func (b Build) String() string {
+	s := b.Version
+	if s == "" {
+		s = "unknown version"
+	}
+	if b.Name != "" {
+		s = b.Name + " " + s
+	}
+
+	var details []string
+	if b.Commit != "" {
+		details = append(details, "commit "+b.Commit)
+	}
+	if b.Date != "" {
+		details = append(details, "built "+b.Date)
+	}
+	if len(details) > 0 {
+		s += " (" + strings.Join(details, ", ") + ")"
+	}
+	return s
+}
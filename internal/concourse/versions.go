@@ -0,0 +1,219 @@
+package concourse
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// VersionComparator compares two values of a version key. It returns a
+// negative number if a sorts before b, a positive number if b sorts before
+// a, and zero if they are equal, or an error if a value can't be parsed.
+type VersionComparator func(a, b string) (int, error)
+
+// VersionKey is a key of the versions to sort by
+type VersionKey struct {
+	Name    string
+	Compare VersionComparator
+
+	// Optional keys can be missing from versions, versions without the
+	// key sort before those that have it.
+	Optional bool
+}
+
+// SortVersions sorts the versions in ascending order by the keys, later
+// keys are only compared when the earlier ones are equal. The sort is
+// stable. An error is returned if a value is missing or malformed, the
+// versions are left in an undefined order then.
+func SortVersions(versions []ResourceVersion, keys ...VersionKey) error {
+	var sortErr error
+	sort.SliceStable(versions, func(i, j int) bool {
+		if sortErr != nil {
+			return false
+		}
+		c, err := compareVersions(versions[i], versions[j], keys)
+		if err != nil {
+			sortErr = err
+			return false
+		}
+		return c < 0
+	})
+
+	// A single version is never compared, so check it explicitly.
+	if sortErr == nil && len(versions) == 1 {
+		_, sortErr = compareVersions(versions[0], versions[0], keys)
+	}
+
+	return errors.Wrap(sortErr, "failed to sort versions")
+}
+
+// compareVersions compares two versions by the keys
+func compareVersions(a, b ResourceVersion, keys []VersionKey) (int, error) {
+	for _, key := range keys {
+		av, aok := a[key.Name]
+		bv, bok := b[key.Name]
+
+		if !aok || !bok {
+			if !key.Optional {
+				return 0, errors.Errorf("a version has no %q", key.Name)
+			}
+			switch {
+			case aok:
+				return 1, nil
+			case bok:
+				return -1, nil
+			}
+			continue
+		}
+
+		c, err := key.Compare(av, bv)
+		if err != nil {
+			return 0, errors.Wrapf(err, "invalid %q", key.Name)
+		}
+		if c != 0 {
+			return c, nil
+		}
+	}
+	return 0, nil
+}
+
+// CompareNumeric compares integer values
+func CompareNumeric(a, b string) (int, error) {
+	an, err := strconv.ParseInt(a, 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("%q isn't a number", a)
+	}
+	bn, err := strconv.ParseInt(b, 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("%q isn't a number", b)
+	}
+
+	switch {
+	case an < bn:
+		return -1, nil
+	case an > bn:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// CompareTimestamp returns a comparator for timestamps in the layout, f.ex.
+// time.RFC3339.
+func CompareTimestamp(layout string) VersionComparator {
+	return func(a, b string) (int, error) {
+		at, err := time.Parse(layout, a)
+		if err != nil {
+			return 0, errors.Wrapf(err, "%q isn't a timestamp", a)
+		}
+		bt, err := time.Parse(layout, b)
+		if err != nil {
+			return 0, errors.Wrapf(err, "%q isn't a timestamp", b)
+		}
+
+		switch {
+		case at.Before(bt):
+			return -1, nil
+		case at.After(bt):
+			return 1, nil
+		}
+		return 0, nil
+	}
+}
+
+var semverPattern = regexp.MustCompile(
+	`^v?(\d+)\.(\d+)\.(\d+)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// CompareSemver compares semantic versions by precedence, build metadata
+// is ignored. A leading "v" is allowed.
+func CompareSemver(a, b string) (int, error) {
+	am := semverPattern.FindStringSubmatch(a)
+	if am == nil {
+		return 0, errors.Errorf("%q isn't a semantic version", a)
+	}
+	bm := semverPattern.FindStringSubmatch(b)
+	if bm == nil {
+		return 0, errors.Errorf("%q isn't a semantic version", b)
+	}
+
+	for i := 1; i <= 3; i++ {
+		if c := compareNumbers(am[i], bm[i]); c != 0 {
+			return c, nil
+		}
+	}
+
+	// A pre-release sorts before the release itself.
+	switch {
+	case am[4] == bm[4]:
+		return 0, nil
+	case am[4] == "":
+		return 1, nil
+	case bm[4] == "":
+		return -1, nil
+	}
+	return comparePreRelease(am[4], bm[4]), nil
+}
+
+// comparePreRelease compares pre-release versions identifier by
+// identifier. Numeric identifiers sort before alphanumeric ones, and a
+// shorter version before a longer one with the same prefix.
+func comparePreRelease(a, b string) int {
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		anum := isNumeric(as[i])
+		bnum := isNumeric(bs[i])
+
+		var c int
+		switch {
+		case anum && bnum:
+			c = compareNumbers(as[i], bs[i])
+		case anum:
+			c = -1
+		case bnum:
+			c = 1
+		default:
+			c = strings.Compare(as[i], bs[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+// compareNumbers compares strings of digits of any length
+func compareNumbers(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
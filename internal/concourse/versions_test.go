@@ -0,0 +1,143 @@
+package concourse
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompareNumeric(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1", "2", -1},
+		{"10", "9", 1},
+		{"42", "42", 0},
+		{"007", "7", 0},
+	}
+	for _, tt := range tests {
+		got, err := CompareNumeric(tt.a, tt.b)
+		if err != nil {
+			t.Errorf("CompareNumeric(%q, %q) failed: %v", tt.a, tt.b, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("CompareNumeric(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+
+	if _, err := CompareNumeric("1", "$LATEST"); err == nil {
+		t.Error("CompareNumeric accepted a value that isn't a number")
+	}
+}
+
+func TestCompareTimestamp(t *testing.T) {
+	compare := CompareTimestamp(time.RFC3339)
+
+	got, err := compare("2024-01-02T10:00:00Z", "2024-01-02T11:00:00+02:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 1 {
+		t.Errorf("10:00 UTC compared to 09:00 UTC = %d, want 1", got)
+	}
+
+	if _, err := compare("2024-01-02", "2024-01-02T11:00:00Z"); err == nil {
+		t.Error("accepted a timestamp in another layout")
+	}
+}
+
+func TestCompareSemver(t *testing.T) {
+	// The precedence example of the semver spec, in ascending order
+	ordered := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"1.2.0",
+		"1.10.0",
+		"2.0.0",
+	}
+	for i := 0; i < len(ordered); i++ {
+		for j := 0; j < len(ordered); j++ {
+			got, err := CompareSemver(ordered[i], ordered[j])
+			if err != nil {
+				t.Fatalf("CompareSemver(%q, %q) failed: %v", ordered[i], ordered[j], err)
+			}
+			want := 0
+			switch {
+			case i < j:
+				want = -1
+			case i > j:
+				want = 1
+			}
+			if got != want {
+				t.Errorf("CompareSemver(%q, %q) = %d, want %d",
+					ordered[i], ordered[j], got, want)
+			}
+		}
+	}
+
+	equal := [][2]string{
+		{"v1.2.3", "1.2.3"},
+		{"1.2.3+build.1", "1.2.3+build.2"},
+		{"18446744073709551616.0.0", "18446744073709551616.0.0"},
+	}
+	for _, pair := range equal {
+		if got, err := CompareSemver(pair[0], pair[1]); err != nil || got != 0 {
+			t.Errorf("CompareSemver(%q, %q) = %d, %v, want 0", pair[0], pair[1], got, err)
+		}
+	}
+
+	for _, invalid := range []string{"", "1.2", "1.2.3.4", "release-1.2.3"} {
+		if _, err := CompareSemver(invalid, "1.0.0"); err == nil {
+			t.Errorf("CompareSemver accepted %q", invalid)
+		}
+	}
+}
+
+func TestSortVersions(t *testing.T) {
+	versions := []ResourceVersion{
+		{"version": "10", "semver": "1.1.0"},
+		{"version": "2"},
+		{"version": "10", "semver": "1.0.0"},
+		{"version": "9", "semver": "0.9.0"},
+	}
+	err := SortVersions(versions,
+		VersionKey{Name: "version", Compare: CompareNumeric},
+		VersionKey{Name: "semver", Compare: CompareSemver, Optional: true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ResourceVersion{
+		{"version": "2"},
+		{"version": "9", "semver": "0.9.0"},
+		{"version": "10", "semver": "1.0.0"},
+		{"version": "10", "semver": "1.1.0"},
+	}
+	if !reflect.DeepEqual(versions, want) {
+		t.Errorf("got %v, want %v", versions, want)
+	}
+}
+
+func TestSortVersionsErrors(t *testing.T) {
+	tests := map[string][]ResourceVersion{
+		"missing key":   {{"version": "1"}, {"other": "2"}},
+		"single":        {{"version": "x"}},
+		"invalid value": {{"version": "1"}, {"version": "x"}},
+	}
+	for name, versions := range tests {
+		err := SortVersions(versions, VersionKey{Name: "version", Compare: CompareNumeric})
+		if err == nil {
+			t.Errorf("%s: sorted %v without an error", name, versions)
+		}
+	}
+}
@@ -4,10 +4,17 @@ import (
 	"fmt"
 	"os"
 
-	"github.com/Sydsvenskan/concourse"
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
 	"github.com/Sydsvenskan/lambda-resource/resource"
 )
 
+// The build of the executable, set by the Makefile with -ldflags
+var (
+	version = "dev"
+	commit  string
+	date    string
+)
+
 func main() {
 	context, err := concourse.NewContext(os.Args, os.Stdin, os.Stdout, os.Stderr)
 	if err != nil {
@@ -20,5 +27,14 @@ func main() {
 		Check: &resource.CheckCommand{},
 		In:    &resource.InCommand{},
 		Out:   &resource.OutCommand{},
+
+		Middleware: resource.Middleware(),
+
+		Build: concourse.Build{
+			Name:    "lambda-resource",
+			Version: version,
+			Commit:  commit,
+			Date:    date,
+		},
 	})
 }
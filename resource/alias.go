@@ -0,0 +1,147 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/pkg/errors"
+)
+
+// previousVersionTag is the prefix of the function tags that record the
+// version that an alias pointed to before it was last updated by the
+// resource, f.ex. "concourse:previous-version:PROD".
+const previousVersionTag = "concourse:previous-version:"
+
+// legacyPreviousVersionPrefix prefixes the previous version in the alias
+// description of aliases that were updated by older releases.
+const legacyPreviousVersionPrefix = "previous version: "
+
+// aliasFunctionArn returns the ARN of the function that the alias belongs
+// to.
+func aliasFunctionArn(alias *lambda.AliasConfiguration) string {
+	return strings.TrimSuffix(
+		aws.StringValue(alias.AliasArn), ":"+aws.StringValue(alias.Name),
+	)
+}
+
+// updateAlias points the alias at a version, and records the version it
+// pointed to before in a tag of the function. The alias description is
+// left alone.
+func updateAlias(
+	ctx context.Context, log *concourse.Logger, api LambdaAPI,
+	functionName, alias, version string,
+) (*lambda.AliasConfiguration, error) {
+	current, err := api.GetAliasWithContext(ctx, &lambda.GetAliasInput{
+		FunctionName: &functionName,
+		Name:         &alias,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get the alias %q", alias)
+	}
+
+	var config *lambda.AliasConfiguration
+	err = conflictRetry.Do(ctx, log, "alias update", func() error {
+		var err error
+		config, err = api.UpdateAliasWithContext(ctx, &lambda.UpdateAliasInput{
+			FunctionName:    &functionName,
+			FunctionVersion: &version,
+			Name:            &alias,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	previous := aws.StringValue(current.FunctionVersion)
+	if previous == version {
+		return config, nil
+	}
+
+	arn := aliasFunctionArn(config)
+	if _, err := api.TagResourceWithContext(ctx, &lambda.TagResourceInput{
+		Resource: &arn,
+		Tags:     aws.StringMap(map[string]string{previousVersionTag + alias: previous}),
+	}); err != nil {
+		log.Warnf("failed to record the previous version %s of %s, it can't be rolled back: %v",
+			previous, alias, err)
+	}
+
+	return config, nil
+}
+
+// rollbackVersion resolves the version an alias should be rolled back to,
+// either from the previous version file or the alias history.
+func rollbackVersion(
+	ctx context.Context, api LambdaAPI,
+	functionName, alias string, previousVersionFile *string,
+) (string, error) {
+	if previousVersionFile != nil {
+		data, err := ioutil.ReadFile(*previousVersionFile)
+		if err != nil {
+			return "", errors.Wrapf(err,
+				"failed to read previous version file %q", *previousVersionFile)
+		}
+		return string(bytes.TrimSpace(data)), nil
+	}
+
+	current, err := api.GetAliasWithContext(ctx, &lambda.GetAliasInput{
+		FunctionName: &functionName,
+		Name:         &alias,
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to get the alias %q", alias)
+	}
+
+	arn := aliasFunctionArn(current)
+	out, err := api.ListTagsWithContext(ctx, &lambda.ListTagsInput{
+		Resource: &arn,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to list function tags")
+	}
+
+	if previous, ok := out.Tags[previousVersionTag+alias]; ok {
+		return aws.StringValue(previous), nil
+	}
+
+	description := aws.StringValue(current.Description)
+	if strings.HasPrefix(description, legacyPreviousVersionPrefix) {
+		return strings.TrimPrefix(description, legacyPreviousVersionPrefix), nil
+	}
+
+	return "", fmt.Errorf("no previous version has been recorded for %q", alias)
+}
+
+// persistAlias writes the alias configuration, including the routing
+// configuration, to "alias.json", and the alias name and ARN to "alias" and
+// "alias-arn".
+func persistAlias(
+	ctx *concourse.CommandContext, api LambdaAPI, functionName, alias string,
+) (*lambda.AliasConfiguration, error) {
+	config, err := api.GetAliasWithContext(ctx, &lambda.GetAliasInput{
+		FunctionName: &functionName,
+		Name:         &alias,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get the alias %q", alias)
+	}
+
+	if err := ctx.JSON("alias.json", config); err != nil {
+		return nil, errors.Wrap(err, "failed to persist alias configuration")
+	}
+	if err := ctx.File("alias", []byte(alias)); err != nil {
+		return nil, errors.Wrap(err, "failed to persist alias")
+	}
+	if err := ctx.File("alias-arn", []byte(aws.StringValue(config.AliasArn))); err != nil {
+		return nil, errors.Wrap(err, "failed to persist alias ARN")
+	}
+
+	return config, nil
+}
@@ -0,0 +1,118 @@
+package resource
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// LambdaAPI is the part of the Lambda API that the resource uses. It's
+// implemented by the client from LambdaClient, and can be replaced by a
+// fake in tests.
+type LambdaAPI interface {
+	NewRequest(operation *request.Operation, params, data interface{}) *request.Request
+
+	InvokeRequest(input *lambda.InvokeInput) (*request.Request, *lambda.InvokeOutput)
+	InvokeWithResponseStreamRequest(input *lambda.InvokeWithResponseStreamInput) (
+		*request.Request, *lambda.InvokeWithResponseStreamOutput)
+
+	GetFunctionWithContext(aws.Context, *lambda.GetFunctionInput, ...request.Option) (
+		*lambda.GetFunctionOutput, error)
+	GetFunctionConfigurationWithContext(aws.Context, *lambda.GetFunctionConfigurationInput, ...request.Option) (
+		*lambda.FunctionConfiguration, error)
+	GetFunctionEventInvokeConfigWithContext(aws.Context, *lambda.GetFunctionEventInvokeConfigInput, ...request.Option) (
+		*lambda.GetFunctionEventInvokeConfigOutput, error)
+	GetFunctionUrlConfigWithContext(aws.Context, *lambda.GetFunctionUrlConfigInput, ...request.Option) (
+		*lambda.GetFunctionUrlConfigOutput, error)
+	GetPolicyWithContext(aws.Context, *lambda.GetPolicyInput, ...request.Option) (
+		*lambda.GetPolicyOutput, error)
+	GetLayerVersionWithContext(aws.Context, *lambda.GetLayerVersionInput, ...request.Option) (
+		*lambda.GetLayerVersionOutput, error)
+	ListTagsWithContext(aws.Context, *lambda.ListTagsInput, ...request.Option) (
+		*lambda.ListTagsOutput, error)
+	ListVersionsByFunctionWithContext(aws.Context, *lambda.ListVersionsByFunctionInput, ...request.Option) (
+		*lambda.ListVersionsByFunctionOutput, error)
+
+	ListVersionsByFunctionPagesWithContext(aws.Context, *lambda.ListVersionsByFunctionInput,
+		func(*lambda.ListVersionsByFunctionOutput, bool) bool, ...request.Option) error
+	ListFunctionsPagesWithContext(aws.Context, *lambda.ListFunctionsInput,
+		func(*lambda.ListFunctionsOutput, bool) bool, ...request.Option) error
+	ListLayerVersionsPagesWithContext(aws.Context, *lambda.ListLayerVersionsInput,
+		func(*lambda.ListLayerVersionsOutput, bool) bool, ...request.Option) error
+	ListEventSourceMappingsPagesWithContext(aws.Context, *lambda.ListEventSourceMappingsInput,
+		func(*lambda.ListEventSourceMappingsOutput, bool) bool, ...request.Option) error
+
+	GetAliasWithContext(aws.Context, *lambda.GetAliasInput, ...request.Option) (
+		*lambda.AliasConfiguration, error)
+	UpdateAliasWithContext(aws.Context, *lambda.UpdateAliasInput, ...request.Option) (
+		*lambda.AliasConfiguration, error)
+	DeleteAliasWithContext(aws.Context, *lambda.DeleteAliasInput, ...request.Option) (
+		*lambda.DeleteAliasOutput, error)
+
+	UpdateFunctionCodeWithContext(aws.Context, *lambda.UpdateFunctionCodeInput, ...request.Option) (
+		*lambda.FunctionConfiguration, error)
+	UpdateFunctionConfigurationWithContext(aws.Context, *lambda.UpdateFunctionConfigurationInput, ...request.Option) (
+		*lambda.FunctionConfiguration, error)
+	PutRuntimeManagementConfigWithContext(aws.Context, *lambda.PutRuntimeManagementConfigInput, ...request.Option) (
+		*lambda.PutRuntimeManagementConfigOutput, error)
+	PublishVersionWithContext(aws.Context, *lambda.PublishVersionInput, ...request.Option) (
+		*lambda.FunctionConfiguration, error)
+	TagResourceWithContext(aws.Context, *lambda.TagResourceInput, ...request.Option) (
+		*lambda.TagResourceOutput, error)
+	DeleteFunctionWithContext(aws.Context, *lambda.DeleteFunctionInput, ...request.Option) (
+		*lambda.DeleteFunctionOutput, error)
+	WaitUntilFunctionUpdatedWithContext(aws.Context, *lambda.GetFunctionConfigurationInput, ...request.WaiterOption) error
+}
+
+var _ LambdaAPI = (*lambda.Lambda)(nil)
+
+// lambdaAPI returns the injected API, or a client from the source config
+func lambdaAPI(api LambdaAPI, source Source) LambdaAPI {
+	if api != nil {
+		return api
+	}
+	return LambdaClient(source)
+}
+
+// StackAPI is the part of the CloudFormation and S3 APIs that is used to
+// deploy functions that are managed by a stack.
+type StackAPI interface {
+	DescribeStackResourcesWithContext(aws.Context, *cloudformation.DescribeStackResourcesInput, ...request.Option) (
+		*cloudformation.DescribeStackResourcesOutput, error)
+	DescribeStacksWithContext(aws.Context, *cloudformation.DescribeStacksInput, ...request.Option) (
+		*cloudformation.DescribeStacksOutput, error)
+	CreateChangeSetWithContext(aws.Context, *cloudformation.CreateChangeSetInput, ...request.Option) (
+		*cloudformation.CreateChangeSetOutput, error)
+	DescribeChangeSetWithContext(aws.Context, *cloudformation.DescribeChangeSetInput, ...request.Option) (
+		*cloudformation.DescribeChangeSetOutput, error)
+	ExecuteChangeSetWithContext(aws.Context, *cloudformation.ExecuteChangeSetInput, ...request.Option) (
+		*cloudformation.ExecuteChangeSetOutput, error)
+	DeleteChangeSetWithContext(aws.Context, *cloudformation.DeleteChangeSetInput, ...request.Option) (
+		*cloudformation.DeleteChangeSetOutput, error)
+	WaitUntilChangeSetCreateCompleteWithContext(aws.Context, *cloudformation.DescribeChangeSetInput, ...request.WaiterOption) error
+	WaitUntilStackUpdateCompleteWithContext(aws.Context, *cloudformation.DescribeStacksInput, ...request.WaiterOption) error
+
+	PutObjectWithContext(aws.Context, *s3.PutObjectInput, ...request.Option) (
+		*s3.PutObjectOutput, error)
+}
+
+// stackClient combines the CloudFormation and S3 clients
+type stackClient struct {
+	*cloudformation.CloudFormation
+	*s3.S3
+}
+
+var _ StackAPI = stackClient{}
+
+// stackAPI returns the injected API, or clients from the source config
+func stackAPI(api StackAPI, source Source) StackAPI {
+	if api != nil {
+		return api
+	}
+	return stackClient{
+		CloudFormation: CloudFormationClient(source),
+		S3:             S3Client(source),
+	}
+}
@@ -0,0 +1,95 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// awsDebugLogLevel logs the requests and responses, the bodies of the
+// responses, and failed and retried requests. The Lambda client only logs
+// the headers of the responses, see debugAWSLambdaClient.
+const awsDebugLogLevel = aws.LogDebugWithHTTPBody |
+	aws.LogDebugWithRequestRetries |
+	aws.LogDebugWithRequestErrors
+
+// credentialHeaders matches the request headers that carry credentials
+var credentialHeaders = regexp.MustCompile(
+	`(?mi)^((?:Authorization|X-Amz-Security-Token):).*$`)
+
+type awsLogKey struct{}
+
+// withAWSLog makes the AWS requests of the context write their debug log
+// to w.
+func withAWSLog(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, awsLogKey{}, w)
+}
+
+// awsLogger writes the debug log of the AWS SDK with the credential
+// headers redacted.
+type awsLogger struct {
+	w io.Writer
+}
+
+// Log writes a message of the SDK
+func (l awsLogger) Log(args ...interface{}) {
+	msg := credentialHeaders.ReplaceAllString(
+		fmt.Sprint(args...), "$1 "+concourse.RedactedValue)
+	if !strings.HasSuffix(msg, "\n") {
+		msg += "\n"
+	}
+	_, _ = io.WriteString(l.w, msg)
+}
+
+// debugAWSConfig enables the debug log of the SDK for the session. The
+// requests log to the writer of their context, requests without one
+// don't log at all, so that nothing is written to stdout.
+func debugAWSConfig(config *aws.Config, handlers *request.Handlers) {
+	config.LogLevel = aws.LogLevel(awsDebugLogLevel)
+	config.Logger = aws.LoggerFunc(func(...interface{}) {})
+
+	handlers.Validate.PushFront(func(r *request.Request) {
+		if w, ok := r.Context().Value(awsLogKey{}).(io.Writer); ok {
+			r.Config.Logger = awsLogger{w: w}
+		}
+	})
+}
+
+// debugAWSClient makes the client log the headers of the requests only,
+// so that uploaded code packages and invocation payloads aren't dumped
+// into the log.
+func debugAWSClient(c *client.Client) {
+	c.Handlers.Send.Swap(client.LogHTTPRequestHandler.Name,
+		client.LogHTTPRequestHeaderHandler)
+}
+
+// debugAWSLambdaClient makes the Lambda client log the headers of the
+// requests and the responses only. The function configurations in the
+// responses contain the environment variables, which are secrets that
+// haven't been registered for redaction, and invocation responses contain
+// the payloads.
+func debugAWSLambdaClient(c *client.Client) {
+	debugAWSClient(c)
+	c.Handlers.Send.Swap(client.LogHTTPResponseHandler.Name,
+		client.LogHTTPResponseHeaderHandler)
+}
+
+// debugAWS sends the debug log of the AWS SDK to the build log if the
+// source asks for it.
+func debugAWS(next concourse.CommandFunc) concourse.CommandFunc {
+	return func(
+		ctx *concourse.CommandContext, cmd concourse.CommandHandler,
+	) (*concourse.CommandResponse, error) {
+		if sc, ok := cmd.(sourceCommand); ok && sc.source().DebugAWS {
+			ctx.Context = withAWSLog(ctx.Context, ctx.Log)
+		}
+		return next(ctx, cmd)
+	}
+}
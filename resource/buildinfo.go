@@ -0,0 +1,111 @@
+package resource
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+	"github.com/pkg/errors"
+)
+
+// BuildInfoFileName is the name of the build info file in the package
+const BuildInfoFileName = "build-info.json"
+
+// BuildInfo is build provenance information that can be embedded in the
+// function package.
+type BuildInfo struct {
+	Ref          string `json:"ref,omitempty"`
+	BuildID      string `json:"build_id,omitempty"`
+	BuildName    string `json:"build_name,omitempty"`
+	JobName      string `json:"job_name,omitempty"`
+	PipelineName string `json:"pipeline_name,omitempty"`
+	TeamName     string `json:"team_name,omitempty"`
+	ExternalURL  string `json:"external_url,omitempty"`
+}
+
+// NewBuildInfo collects build info from the Concourse build metadata and,
+// if given, the ref file.
+func NewBuildInfo(meta concourse.BuildMetadata, refFile *string) (*BuildInfo, error) {
+	info := BuildInfo{
+		BuildID:      meta.ID,
+		BuildName:    meta.Name,
+		JobName:      meta.JobName,
+		PipelineName: meta.PipelineName,
+		TeamName:     meta.TeamName,
+		ExternalURL:  meta.ExternalURL,
+	}
+
+	if refFile != nil {
+		ref, err := ioutil.ReadFile(*refFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read ref file %q", *refFile)
+		}
+		info.Ref = strings.TrimSpace(string(ref))
+	}
+
+	return &info, nil
+}
+
+// addBuildInfo returns a copy of the archive with the build info added to
+// the root. An existing build info file in the archive is replaced.
+func addBuildInfo(archive []byte, info *BuildInfo) ([]byte, error) {
+	infoData, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal build info")
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read code package")
+	}
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+
+	for _, f := range r.File {
+		if f.Name == BuildInfoFileName {
+			continue
+		}
+		if err := copyZipEntry(w, f); err != nil {
+			return nil, err
+		}
+	}
+
+	fw, err := w.Create(BuildInfoFileName)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create build info file")
+	}
+	if _, err := fw.Write(infoData); err != nil {
+		return nil, errors.Wrap(err, "failed to write build info file")
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to finish zip archive")
+	}
+
+	return buf.Bytes(), nil
+}
+
+func copyZipEntry(w *zip.Writer, f *zip.File) error {
+	rc, err := f.Open()
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %q in archive", f.Name)
+	}
+	defer rc.Close()
+
+	header := f.FileHeader
+	fw, err := w.CreateHeader(&header)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create archive file %q", f.Name)
+	}
+
+	if _, err := io.Copy(fw, rc); err != nil {
+		return errors.Wrapf(err, "failed to copy %q", f.Name)
+	}
+
+	return nil
+}
@@ -1,10 +1,19 @@
 package resource
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
 	"sort"
 	"strconv"
+	"strings"
+	"time"
 
-	"github.com/Sydsvenskan/concourse"
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/pkg/errors"
 )
@@ -15,6 +24,8 @@ type CheckCommand struct {
 	Source Source `json:"source"`
 	// Version information passed to the resource
 	Version concourse.ResourceVersion `json:"version"`
+	// API is the Lambda API to use, defaults to a client from the source
+	API LambdaAPI `json:"-"`
 }
 
 func getVersionNumber(v concourse.ResourceVersion) *int {
@@ -30,92 +41,778 @@ func getVersionNumber(v concourse.ResourceVersion) *int {
 	return nil
 }
 
+// Check tracking modes
+const (
+	// TrackVersions tracks published versions, or the version of an alias
+	TrackVersions = "versions"
+	// TrackCodeSha tracks changes of the code sha256 of the function or
+	// alias, including unpublished changes of $LATEST.
+	TrackCodeSha = "code_sha"
+	// TrackLastModified tracks changes of the last modified time of the
+	// function or alias, including configuration only changes.
+	TrackLastModified = "last_modified"
+	// TrackConfig tracks changes of the live configuration of the function
+	// or alias, f.ex. to detect changes made outside of the pipeline.
+	TrackConfig = "config"
+)
+
+// CommandTimeout returns how long the command may run
+func (cmd *CheckCommand) CommandTimeout() (time.Duration, error) {
+	return cmd.Source.commandTimeout()
+}
+
+// LogFormat returns the format of the build log
+func (cmd *CheckCommand) LogFormat() (concourse.Format, error) {
+	return cmd.Source.logFormat()
+}
+
+// source returns the source definition for the middleware
+func (cmd *CheckCommand) source() Source {
+	return cmd.Source
+}
+
+// Validate checks that the source options can be combined
+func (cmd *CheckCommand) Validate() error {
+	if cmd.Source.Alias != nil && cmd.Source.Aliases != nil {
+		return errors.New("only one of alias and aliases can be used")
+	}
+
+	if cmd.Source.IsMultiFunction() {
+		if cmd.Source.FunctionName != "" || cmd.Source.Alias != nil ||
+			cmd.Source.Aliases != nil || cmd.Source.Track != nil {
+			return errors.New("function_names and function_prefix can't be combined with function_name, alias, aliases, or track")
+		}
+	}
+
+	switch initial := aws.StringValue(cmd.Source.InitialVersion); initial {
+	case "", InitialVersionLatest, InitialVersionAll:
+	default:
+		if n, err := strconv.Atoi(initial); err != nil || n < 0 {
+			return fmt.Errorf("unsupported initial_version %q", initial)
+		}
+	}
+	if cmd.Source.MaxVersions != nil && *cmd.Source.MaxVersions < 1 {
+		return errors.New("max_versions must be at least 1")
+	}
+
+	if cmd.Source.LayerName != nil {
+		if cmd.Source.FunctionName != "" || cmd.Source.IsMultiFunction() ||
+			cmd.Source.Alias != nil || cmd.Source.Aliases != nil || cmd.Source.Track != nil {
+			return errors.New("layer_name can't be combined with function, alias, or track options")
+		}
+	}
+
+	return nil
+}
+
 // HandleCommand runs the command
 func (cmd *CheckCommand) HandleCommand(ctx *concourse.CommandContext) (
 	*concourse.CommandResponse, error,
 ) {
-	api := LambdaClient(cmd.Source)
+	api := lambdaAPI(cmd.API, cmd.Source)
 
+	var (
+		newVersions []concourse.ResourceVersion
+		err         error
+	)
+
+	switch track := aws.StringValue(cmd.Source.Track); {
+	case cmd.Source.LayerName != nil:
+		newVersions, err = cmd.checkLayer(ctx, api)
+	case cmd.Source.IsMultiFunction():
+		newVersions, err = cmd.checkFunctions(ctx, ctx.Logger, api)
+	case track != "" && track != TrackVersions && cmd.Source.Aliases != nil:
+		return nil, errors.New("aliases can only be used with the versions track mode")
+	case track == TrackCodeSha, track == TrackLastModified, track == TrackConfig:
+		key, value := liveTrack(track)
+		newVersions, err = cmd.checkLive(ctx, api, key, value)
+	case track != "" && track != TrackVersions:
+		return nil, fmt.Errorf("unsupported track mode %q", track)
+	case cmd.Source.Aliases != nil:
+		newVersions, err = cmd.checkAliases(ctx, api)
+	case cmd.Source.Alias != nil:
+		newVersions, err = cmd.checkAlias(ctx, api)
+	default:
+		newVersions, err = cmd.checkVersions(ctx, ctx.Logger, api)
+	}
+	if err != nil && cmd.Source.SkipMissing && isNotFound(errors.Cause(err)) {
+		ctx.Warnf("the function doesn't exist yet: %s", err.Error())
+		return &concourse.CommandResponse{
+			Versions: []concourse.ResourceVersion{},
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &concourse.CommandResponse{
+		Versions: newVersions,
+	}, nil
+}
+
+// latestVersion is the unpublished version of a function
+const latestVersion = "$LATEST"
+
+// checkVersions returns the published versions that are newer than the
+// incoming version, or the latest version on the first check. With
+// include_latest a $LATEST pseudo-version is added when the code of
+// $LATEST differs from the newest version that was emitted before.
+func (cmd *CheckCommand) checkVersions(
+	ctx context.Context, log *concourse.Logger, api LambdaAPI,
+) ([]concourse.ResourceVersion, error) {
 	var newVersions []concourse.ResourceVersion
-	incomingVersion := getVersionNumber(cmd.Version)
+	incomingVersion := cmd.incomingVersion()
 
-	if cmd.Source.Alias == nil {
-		req := lambda.ListVersionsByFunctionInput{
-			FunctionName: &cmd.Source.FunctionName,
+	// Pseudo-versions of $LATEST record the newest published version at
+	// the time.
+	isLatest := cmd.Version["version"] == latestVersion
+	if isLatest {
+		incomingVersion = getVersionNumber(concourse.ResourceVersion{
+			"version": cmd.Version["after"],
+		})
+	}
+
+	if cmd.Source.FastCheck && incomingVersion != nil && !cmd.Source.IncludeLatest {
+		found, err := cmd.versionExists(ctx, api, *incomingVersion+1)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, nil
 		}
-		for {
-			versions, err := api.ListVersionsByFunction(&req)
+	}
+
+	var descriptionFilter *regexp.Regexp
+	if cmd.Source.VersionDescriptionFilter != nil {
+		re, err := regexp.Compile(*cmd.Source.VersionDescriptionFilter)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid version_description_filter")
+		}
+		descriptionFilter = re
+	}
+
+	var latest *lambda.FunctionConfiguration
+	shas := map[string]string{}
+	descriptions := map[string]string{}
+	newest := ""
+
+	req := lambda.ListVersionsByFunctionInput{
+		FunctionName: &cmd.Source.FunctionName,
+	}
+	for {
+		var versions *lambda.ListVersionsByFunctionOutput
+		err := throttleRetry.Do(ctx, log, "listing versions", func() error {
+			var err error
+			versions, err = api.ListVersionsByFunctionWithContext(ctx, &req)
+			return err
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list versions")
+		}
+
+		for _, v := range versions.Versions {
+			if *v.Version == latestVersion {
+				latest = v
+				continue
+			}
+
+			itemVersion, err := strconv.Atoi(*v.Version)
 			if err != nil {
-				return nil, errors.Wrap(err, "failed to list versions")
+				return nil, errors.Wrap(err, "failed to parse function version")
+			}
+			shas[*v.Version] = aws.StringValue(v.CodeSha256)
+			descriptions[*v.Version] = aws.StringValue(v.Description)
+			if n, _ := strconv.Atoi(newest); newest == "" || itemVersion > n {
+				newest = *v.Version
 			}
 
-			for _, v := range versions.Versions {
-				if *v.Version == "$LATEST" {
-					continue
-				}
+			if descriptionFilter != nil &&
+				!descriptionFilter.MatchString(aws.StringValue(v.Description)) {
+				continue
+			}
 
-				itemVersion, err := strconv.Atoi(*v.Version)
+			if incomingVersion == nil || itemVersion > *incomingVersion {
+				newVersions = append(newVersions, publishedVersion(v))
+			}
+		}
+		if versions.NextMarker == nil {
+			break
+		}
+		req.Marker = versions.NextMarker
+	}
+
+	if err := sortVersions(newVersions); err != nil {
+		return nil, err
+	}
+
+	if cmd.Source.TraceTagFilter != nil && latest != nil {
+		traced, err := cmd.tracedVersions(
+			ctx, api, unqualifiedArn(latest), newVersions, descriptions,
+		)
+		if err != nil {
+			return nil, err
+		}
+		newVersions = traced
+	}
+
+	if cmd.Source.ActiveOnly {
+		active, err := cmd.activeVersions(ctx, api, newVersions)
+		if err != nil {
+			return nil, err
+		}
+		newVersions = active
+	}
+
+	newVersions = cmd.limitVersions(newVersions)
+
+	if cmd.Source.IncludeLatest && latest != nil {
+		// The code that was seen last, either of $LATEST or of the
+		// newest version.
+		seenSha := shas[cmd.Version["version"]]
+		if isLatest {
+			seenSha = cmd.Version["code_sha256"]
+		}
+		if len(newVersions) > 0 {
+			seenSha = shas[newVersions[len(newVersions)-1]["version"]]
+		}
+
+		if sha := aws.StringValue(latest.CodeSha256); sha != seenSha {
+			newVersions = append(newVersions, concourse.ResourceVersion{
+				"version":     latestVersion,
+				"code_sha256": sha,
+				"after":       newest,
+			})
+		}
+	}
+
+	return newVersions, nil
+}
+
+// publishedVersion returns the version that check emits for a published
+// version of the function.
+func publishedVersion(config *lambda.FunctionConfiguration) concourse.ResourceVersion {
+	version := concourse.ResourceVersion{
+		"version":       aws.StringValue(config.Version),
+		"code_sha256":   aws.StringValue(config.CodeSha256),
+		"last_modified": aws.StringValue(config.LastModified),
+	}
+	if semver := descriptionSemver(aws.StringValue(config.Description)); semver != "" {
+		version["semver"] = semver
+	}
+	return version
+}
+
+// versionExists checks if a version of the function has been published.
+// Version numbers are never reused, so there are no new versions when the
+// version after the incoming version doesn't exist, unless it's been
+// deleted.
+func (cmd *CheckCommand) versionExists(
+	ctx context.Context, api LambdaAPI, version int,
+) (bool, error) {
+	_, err := api.GetFunctionConfigurationWithContext(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: &cmd.Source.FunctionName,
+		Qualifier:    aws.String(strconv.Itoa(version)),
+	})
+	switch {
+	case isNotFound(err):
+		return false, nil
+	case err != nil:
+		return false, errors.Wrapf(err, "failed to look up version %d", version)
+	}
+	return true, nil
+}
+
+// tracedVersions filters out versions that weren't published by a put
+// with trace tags that match the trace tag filter. The put records the
+// trace tags in the version description. Versions that were published
+// before that are only known from the trace tags of the function, which
+// name the last version that was published by a put.
+func (cmd *CheckCommand) tracedVersions(
+	ctx context.Context, api LambdaAPI, arn string,
+	versions []concourse.ResourceVersion, descriptions map[string]string,
+) ([]concourse.ResourceVersion, error) {
+	var functionTags map[string]string
+
+	var traced []concourse.ResourceVersion
+	for _, v := range versions {
+		tags, ok := descriptionTraceTags(descriptions[v["version"]])
+		if !ok {
+			if functionTags == nil {
+				out, err := api.ListTagsWithContext(ctx, &lambda.ListTagsInput{
+					Resource: &arn,
+				})
 				if err != nil {
-					return nil, errors.Wrap(err, "failed to parse function version")
+					return nil, errors.Wrap(err, "failed to list function tags")
 				}
+				functionTags = aws.StringValueMap(out.Tags)
+			}
+			if functionTags["concourse:version"] != v["version"] {
+				continue
+			}
+			tags = functionTags
+		}
 
-				if incomingVersion == nil || itemVersion > *incomingVersion {
-					newVersions = append(newVersions, concourse.ResourceVersion{
-						"version": *v.Version,
-					})
-				}
+		if matchesTags(tags, cmd.Source.TraceTagFilter) {
+			traced = append(traced, v)
+		}
+	}
+	return traced, nil
+}
+
+// matchesTags checks that the tags have the values of the filter
+func matchesTags(tags, filter map[string]string) bool {
+	for key, value := range filter {
+		if tags[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// activeVersions filters out versions that aren't active. Versions after a
+// pending version are left out as well so that the pending version is
+// emitted by a later check once it's active.
+func (cmd *CheckCommand) activeVersions(
+	ctx context.Context, api LambdaAPI, versions []concourse.ResourceVersion,
+) ([]concourse.ResourceVersion, error) {
+	state := func(version string) (string, error) {
+		config, err := api.GetFunctionConfigurationWithContext(ctx, &lambda.GetFunctionConfigurationInput{
+			FunctionName: &cmd.Source.FunctionName,
+			Qualifier:    &version,
+		})
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to get the state of version %s", version)
+		}
+		return aws.StringValue(config.State), nil
+	}
+
+	// Only the newest active version is needed on a first check that
+	// returns the latest version.
+	if cmd.latestOnly() {
+		for i := len(versions) - 1; i >= 0; i-- {
+			s, err := state(versions[i]["version"])
+			if err != nil {
+				return nil, err
 			}
-			if versions.NextMarker == nil {
-				break
+			if s == lambda.StateActive {
+				return versions[i : i+1], nil
 			}
-			req.Marker = versions.NextMarker
 		}
+		return nil, nil
+	}
+
+	var active []concourse.ResourceVersion
+	for _, v := range versions {
+		s, err := state(v["version"])
+		if err != nil {
+			return nil, err
+		}
+
+		switch s {
+		case lambda.StateActive:
+			active = append(active, v)
+		case lambda.StatePending:
+			return active, nil
+		}
+	}
+	return active, nil
+}
 
-		sort.Sort(ByVersion(newVersions))
+// What check returns on the first check, a version number returns the
+// versions after that version.
+const (
+	InitialVersionLatest = "latest"
+	InitialVersionAll    = "all"
+)
 
-		if cmd.Version == nil {
-			newVersions = newVersions[len(newVersions)-1:]
+// incomingVersion returns the number of the incoming version, or the
+// number of initial_version on the first check.
+func (cmd *CheckCommand) incomingVersion() *int {
+	if cmd.Version == nil {
+		if n, err := strconv.Atoi(aws.StringValue(cmd.Source.InitialVersion)); err == nil {
+			return &n
 		}
-	} else {
-		config, err := api.GetFunctionConfiguration(&lambda.GetFunctionConfigurationInput{
+	}
+	return getVersionNumber(cmd.Version)
+}
+
+// latestOnly checks if it's the first check and only the latest version
+// should be returned.
+func (cmd *CheckCommand) latestOnly() bool {
+	if cmd.Version != nil {
+		return false
+	}
+	switch aws.StringValue(cmd.Source.InitialVersion) {
+	case "", InitialVersionLatest:
+		return true
+	}
+	return false
+}
+
+// limitVersions limits sorted new versions according to initial_version on
+// the first check, and to the newest max_versions versions.
+func (cmd *CheckCommand) limitVersions(
+	versions []concourse.ResourceVersion,
+) []concourse.ResourceVersion {
+	limit := len(versions)
+	if cmd.latestOnly() {
+		limit = 1
+	}
+	if max := cmd.Source.MaxVersions; max != nil && *max < limit {
+		limit = *max
+	}
+
+	if limit < len(versions) {
+		return versions[len(versions)-limit:]
+	}
+	return versions
+}
+
+// checkAlias returns the version that the alias points to if the alias
+// has been pointed to another version, forwards or backwards, or if the
+// routing of the alias has changed. The revision id of the alias makes
+// the version unique when the alias is pointed back to a version it
+// pointed to before. The configuration of the version is only fetched when
+// there's a new version to emit.
+func (cmd *CheckCommand) checkAlias(ctx context.Context, api LambdaAPI) (
+	[]concourse.ResourceVersion, error,
+) {
+	incomingVersion := getVersionNumber(cmd.Version)
+
+	alias, err := api.GetAliasWithContext(ctx, &lambda.GetAliasInput{
+		FunctionName: &cmd.Source.FunctionName,
+		Name:         cmd.Source.Alias,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get alias")
+	}
+	current := aws.StringValue(alias.FunctionVersion)
+	routing := aliasRouting(alias.RoutingConfig)
+
+	itemVersion, err := strconv.Atoi(current)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse function version")
+	}
+
+	// Versions from before the alias revision was recorded only move
+	// forward.
+	_, hasRevision := cmd.Version["alias_revision"]
+	if incomingVersion != nil && !hasRevision && itemVersion < *incomingVersion {
+		return nil, nil
+	}
+	if incomingVersion != nil && itemVersion == *incomingVersion &&
+		cmd.Version["routing"] == routing {
+		return nil, nil
+	}
+
+	config, err := api.GetFunctionConfigurationWithContext(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: &cmd.Source.FunctionName,
+		Qualifier:    &current,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check configuration")
+	}
+	if cmd.Source.ActiveOnly && aws.StringValue(config.State) != lambda.StateActive {
+		return nil, nil
+	}
+
+	return []concourse.ResourceVersion{aliasVersion(*cmd.Source.Alias, alias, config)}, nil
+}
+
+// aliasVersion returns the version that check emits for an alias, from the
+// alias and the configuration of the version that it points to.
+func aliasVersion(
+	name string, alias *lambda.AliasConfiguration, config *lambda.FunctionConfiguration,
+) concourse.ResourceVersion {
+	version := publishedVersion(config)
+	version["alias"] = name
+	if routing := aliasRouting(alias.RoutingConfig); routing != "" {
+		version["routing"] = routing
+	}
+	if revision := aws.StringValue(alias.RevisionId); revision != "" {
+		version["alias_revision"] = revision
+	}
+	return version
+}
+
+// aliasRouting formats the additional version weights of an alias as
+// "version:weight" pairs, f.ex. "6:0.1". It's empty when the alias
+// doesn't split traffic.
+func aliasRouting(config *lambda.AliasRoutingConfiguration) string {
+	if config == nil || len(config.AdditionalVersionWeights) == 0 {
+		return ""
+	}
+
+	weights := aws.Float64ValueMap(config.AdditionalVersionWeights)
+	versions := make([]string, 0, len(weights))
+	for v := range weights {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+
+	pairs := make([]string, len(versions))
+	for i, v := range versions {
+		pairs[i] = v + ":" + strconv.FormatFloat(weights[v], 'f', -1, 64)
+	}
+	return strings.Join(pairs, ",")
+}
+
+// aliasVersionPrefix prefixes the alias names in the versions of
+// resources that track multiple aliases.
+const aliasVersionPrefix = "alias:"
+
+// checkAliases returns a new version if any of the aliases has been
+// pointed to another version. The version has the versions of all aliases,
+// and the name and version of the alias that moved.
+func (cmd *CheckCommand) checkAliases(ctx context.Context, api LambdaAPI) (
+	[]concourse.ResourceVersion, error,
+) {
+	if len(cmd.Source.Aliases) == 0 {
+		return nil, errors.New("aliases can't be empty")
+	}
+
+	version := concourse.ResourceVersion{}
+	var moved *string
+	for i := range cmd.Source.Aliases {
+		alias := cmd.Source.Aliases[i]
+
+		config, err := api.GetAliasWithContext(ctx, &lambda.GetAliasInput{
 			FunctionName: &cmd.Source.FunctionName,
-			Qualifier:    cmd.Source.Alias,
+			Name:         &alias,
 		})
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to check configuration")
+			return nil, errors.Wrapf(err, "failed to check alias %q", alias)
+		}
+
+		current := aws.StringValue(config.FunctionVersion)
+		version[aliasVersionPrefix+alias] = current
+		if moved == nil && (cmd.Version == nil || cmd.Version[aliasVersionPrefix+alias] != current) {
+			moved = &alias
+		}
+	}
+
+	if moved == nil {
+		return nil, nil
+	}
+
+	version["alias"] = *moved
+	version["version"] = version[aliasVersionPrefix+*moved]
+	return []concourse.ResourceVersion{version}, nil
+}
+
+// checkLayer returns the layer versions that are newer than the incoming
+// version, or the latest layer version on the first check.
+func (cmd *CheckCommand) checkLayer(ctx context.Context, api LambdaAPI) (
+	[]concourse.ResourceVersion, error,
+) {
+	var newVersions []concourse.ResourceVersion
+	incomingVersion := cmd.incomingVersion()
+
+	err := api.ListLayerVersionsPagesWithContext(ctx, &lambda.ListLayerVersionsInput{
+		LayerName: cmd.Source.LayerName,
+	}, func(page *lambda.ListLayerVersionsOutput, _ bool) bool {
+		for _, v := range page.LayerVersions {
+			number := aws.Int64Value(v.Version)
+			if incomingVersion == nil || number > int64(*incomingVersion) {
+				newVersions = append(newVersions, concourse.ResourceVersion{
+					"version":   strconv.FormatInt(number, 10),
+					"layer_arn": aws.StringValue(v.LayerVersionArn),
+				})
+			}
 		}
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list layer versions")
+	}
+
+	if err := sortVersions(newVersions); err != nil {
+		return nil, err
+	}
+	newVersions = cmd.limitVersions(newVersions)
 
-		itemVersion, err := strconv.Atoi(*config.Version)
+	return newVersions, nil
+}
+
+// functionVersionPrefix prefixes the function names in the versions of
+// resources that track multiple functions.
+const functionVersionPrefix = "function:"
+
+// checkFunctions returns a new version if any of the tracked functions has
+// published a new version. The version has the newest published version of
+// every function.
+func (cmd *CheckCommand) checkFunctions(
+	ctx context.Context, log *concourse.Logger, api LambdaAPI,
+) ([]concourse.ResourceVersion, error) {
+	names, err := cmd.functionNames(ctx, api)
+	if err != nil {
+		return nil, err
+	}
+
+	version := concourse.ResourceVersion{}
+	for _, name := range names {
+		newest, err := newestPublishedVersion(ctx, log, api, name)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to parse function version")
+			return nil, err
 		}
+		version[functionVersionPrefix+name] = newest
+	}
 
-		if incomingVersion == nil || itemVersion > *incomingVersion {
-			newVersions = append(newVersions, concourse.ResourceVersion{
-				"version": *config.Version,
-				"alias":   *cmd.Source.Alias,
-			})
+	if reflect.DeepEqual(version, cmd.Version) {
+		return nil, nil
+	}
+	return []concourse.ResourceVersion{version}, nil
+}
+
+// functionNames returns the names of the tracked functions
+func (cmd *CheckCommand) functionNames(
+	ctx context.Context, api LambdaAPI,
+) ([]string, error) {
+	if cmd.Source.FunctionPrefix == nil {
+		if len(cmd.Source.FunctionNames) == 0 {
+			return nil, errors.New("function_names can't be empty")
 		}
+		return cmd.Source.FunctionNames, nil
 	}
 
-	return &concourse.CommandResponse{
-		Versions: newVersions,
-	}, nil
+	prefix := *cmd.Source.FunctionPrefix
+	names := append([]string{}, cmd.Source.FunctionNames...)
+	err := api.ListFunctionsPagesWithContext(ctx, &lambda.ListFunctionsInput{},
+		func(page *lambda.ListFunctionsOutput, _ bool) bool {
+			for _, fn := range page.Functions {
+				name := aws.StringValue(fn.FunctionName)
+				if strings.HasPrefix(name, prefix) {
+					names = append(names, name)
+				}
+			}
+			return true
+		})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list functions")
+	}
+
+	return names, nil
 }
 
-// ByVersion sorts a slice of Versions by version number
-type ByVersion []concourse.ResourceVersion
+// newestPublishedVersion returns the newest published version of the
+// function, or an empty string if no version has been published.
+func newestPublishedVersion(
+	ctx context.Context, log *concourse.Logger, api LambdaAPI, name string,
+) (string, error) {
+	newest := 0
+	err := throttleRetry.Do(ctx, log, "listing versions of "+name, func() error {
+		return api.ListVersionsByFunctionPagesWithContext(ctx, &lambda.ListVersionsByFunctionInput{
+			FunctionName: &name,
+		}, func(page *lambda.ListVersionsByFunctionOutput, _ bool) bool {
+			for _, v := range page.Versions {
+				if n, err := strconv.Atoi(aws.StringValue(v.Version)); err == nil && n > newest {
+					newest = n
+				}
+			}
+			return true
+		})
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to list versions of %q", name)
+	}
 
-func (l ByVersion) Len() int      { return len(l) }
-func (l ByVersion) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
-func (l ByVersion) Less(i, j int) bool {
-	iv, err := strconv.Atoi(l[i]["version"])
+	if newest == 0 {
+		return "", nil
+	}
+	return strconv.Itoa(newest), nil
+}
+
+// liveTrack returns the version key and the value of a track mode that
+// tracks the live configuration, or an empty key for other modes.
+func liveTrack(track string) (string, func(*lambda.FunctionConfiguration) (string, error)) {
+	switch track {
+	case TrackCodeSha:
+		return "code_sha256", func(c *lambda.FunctionConfiguration) (string, error) {
+			return aws.StringValue(c.CodeSha256), nil
+		}
+	case TrackLastModified:
+		return "last_modified", func(c *lambda.FunctionConfiguration) (string, error) {
+			return aws.StringValue(c.LastModified), nil
+		}
+	case TrackConfig:
+		return "config_hash", configHash
+	}
+	return "", nil
+}
+
+// checkLive returns a new version if a value of the live configuration of
+// the function, or the alias, has changed since the incoming version. The
+// value is stored under the key in the version.
+func (cmd *CheckCommand) checkLive(
+	ctx context.Context, api LambdaAPI,
+	key string, value func(*lambda.FunctionConfiguration) (string, error),
+) ([]concourse.ResourceVersion, error) {
+	config, err := api.GetFunctionConfigurationWithContext(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: &cmd.Source.FunctionName,
+		Qualifier:    cmd.Source.Alias,
+	})
 	if err != nil {
-		panic(err.Error())
+		return nil, errors.Wrap(err, "failed to check configuration")
 	}
-	jv, err := strconv.Atoi(l[j]["version"])
+
+	current, err := value(config)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
+	}
+	if cmd.Version != nil && cmd.Version[key] == current {
+		return nil, nil
 	}
-	return iv < jv
+
+	version := concourse.ResourceVersion{
+		key:       current,
+		"version": aws.StringValue(config.Version),
+	}
+	if cmd.Source.Alias != nil {
+		version["alias"] = *cmd.Source.Alias
+	}
+	return []concourse.ResourceVersion{version}, nil
+}
+
+// configHash returns a sha256 hash of the parts of the configuration that
+// are usually managed by the pipeline: environment variables, memory,
+// timeout, layers, runtime, and handler.
+func configHash(c *lambda.FunctionConfiguration) (string, error) {
+	config := struct {
+		Environment map[string]string `json:"environment"`
+		MemorySize  int64             `json:"memory_size"`
+		Timeout     int64             `json:"timeout"`
+		Layers      []string          `json:"layers"`
+		Runtime     string            `json:"runtime"`
+		Handler     string            `json:"handler"`
+	}{
+		MemorySize: aws.Int64Value(c.MemorySize),
+		Timeout:    aws.Int64Value(c.Timeout),
+		Runtime:    aws.StringValue(c.Runtime),
+		Handler:    aws.StringValue(c.Handler),
+	}
+	if c.Environment != nil {
+		config.Environment = aws.StringValueMap(c.Environment.Variables)
+	}
+	for _, layer := range c.Layers {
+		config.Layers = append(config.Layers, aws.StringValue(layer.Arn))
+	}
+
+	// Maps are marshalled with sorted keys, so the hash is stable.
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to hash the configuration")
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
+
+// versionOrder sorts versions by version number, and by semantic version
+// when the version numbers are equal.
+var versionOrder = []concourse.VersionKey{
+	{Name: "version", Compare: concourse.CompareNumeric},
+	{Name: "semver", Compare: concourse.CompareSemver, Optional: true},
+}
+
+// sortVersions sorts the versions by versionOrder
+func sortVersions(versions []concourse.ResourceVersion) error {
+	return concourse.SortVersions(versions, versionOrder...)
 }
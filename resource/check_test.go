@@ -0,0 +1,178 @@
+package resource
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse/resourcetest"
+	"github.com/Sydsvenskan/lambda-resource/resource/lambdatest"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// runCheck runs check against the fake and returns the version numbers
+func runCheck(
+	t *testing.T, fake *lambdatest.Server,
+	source map[string]interface{}, version map[string]string,
+) []string {
+	t.Helper()
+
+	src := map[string]interface{}{
+		"function_name":     "checked",
+		"region_name":       lambdatest.Region,
+		"endpoint":          fake.URL,
+		"access_key_id":     "AKIATESTFAKE",
+		"secret_access_key": "secret",
+	}
+	for k, v := range source {
+		src[k] = v
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"source":  src,
+		"version": version,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := resourcetest.Run(&concourse.Resource{Check: &CheckCommand{}}, "check", payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Cleanup()
+	if res.Err != nil {
+		t.Fatalf("check failed: %v\n%s", res.Err, res.Stderr)
+	}
+
+	var versions []concourse.ResourceVersion
+	if err := json.Unmarshal(res.Stdout, &versions); err != nil {
+		t.Fatalf("invalid check output %q: %v", res.Stdout, err)
+	}
+	numbers := []string{}
+	for _, v := range versions {
+		numbers = append(numbers, v["version"])
+	}
+	return numbers
+}
+
+func TestCheckVersions(t *testing.T) {
+	fake := lambdatest.NewServer()
+	defer fake.Close()
+
+	fake.CreateFunction("checked", "nodejs20.x", "index.handler", []byte("v1"))
+	for i := 0; i < 3; i++ {
+		if _, err := fake.Publish("checked"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		source  map[string]interface{}
+		version map[string]string
+		want    []string
+	}{
+		{
+			name: "first check",
+			want: []string{"3"},
+		},
+		{
+			name:   "first check of all versions",
+			source: map[string]interface{}{"initial_version": "all"},
+			want:   []string{"1", "2", "3"},
+		},
+		{
+			name:   "first check after a version number",
+			source: map[string]interface{}{"initial_version": "1"},
+			want:   []string{"2", "3"},
+		},
+		{
+			name:   "first check after the newest version",
+			source: map[string]interface{}{"initial_version": "3"},
+			want:   []string{},
+		},
+		{
+			name:    "newer versions",
+			version: map[string]string{"version": "1"},
+			want:    []string{"2", "3"},
+		},
+		{
+			name:    "newest versions up to max_versions",
+			source:  map[string]interface{}{"max_versions": 1},
+			version: map[string]string{"version": "1"},
+			want:    []string{"3"},
+		},
+		{
+			name:    "no newer versions",
+			source:  map[string]interface{}{"initial_version": "1"},
+			version: map[string]string{"version": "3"},
+			want:    []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := runCheck(t, fake, tt.source, tt.version)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got versions %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimitVersions(t *testing.T) {
+	versions := []concourse.ResourceVersion{
+		{"version": "1"}, {"version": "2"}, {"version": "3"},
+	}
+
+	tests := []struct {
+		name string
+		cmd  CheckCommand
+		want []string
+	}{
+		{
+			name: "first check",
+			want: []string{"3"},
+		},
+		{
+			name: "first check of all versions",
+			cmd:  CheckCommand{Source: Source{InitialVersion: aws.String(InitialVersionAll)}},
+			want: []string{"1", "2", "3"},
+		},
+		{
+			name: "first check after a version number",
+			cmd:  CheckCommand{Source: Source{InitialVersion: aws.String("0")}},
+			want: []string{"1", "2", "3"},
+		},
+		{
+			name: "later check",
+			cmd:  CheckCommand{Version: concourse.ResourceVersion{"version": "0"}},
+			want: []string{"1", "2", "3"},
+		},
+		{
+			name: "max_versions",
+			cmd: CheckCommand{
+				Source:  Source{MaxVersions: aws.Int(2)},
+				Version: concourse.ResourceVersion{"version": "0"},
+			},
+			want: []string{"2", "3"},
+		},
+		{
+			name: "max_versions above the number of versions",
+			cmd: CheckCommand{
+				Source: Source{InitialVersion: aws.String(InitialVersionAll), MaxVersions: aws.Int(10)},
+			},
+			want: []string{"1", "2", "3"},
+		},
+	}
+	for _, tt := range tests {
+		limited := tt.cmd.limitVersions(versions)
+		got := []string{}
+		for _, v := range limited {
+			got = append(got, v["version"])
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package resource
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/pkg/errors"
+)
+
+// codeDownloadTimeout is how long the download of a code package may take,
+// packages are at most 250 MB unzipped.
+const codeDownloadTimeout = 10 * time.Minute
+
+// downloadCode fetches the code package of the function and returns it
+// together with the function configuration.
+func downloadCode(
+	ctx context.Context, api LambdaAPI,
+	functionName string, qualifier *string,
+) ([]byte, *lambda.FunctionConfiguration, error) {
+	function, err := api.GetFunctionWithContext(ctx, &lambda.GetFunctionInput{
+		FunctionName: &functionName,
+		Qualifier:    qualifier,
+	})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get function")
+	}
+
+	if function.Code == nil {
+		return nil, nil, errors.New("no code package is available for the function")
+	}
+	if function.Code.Location == nil {
+		return nil, nil, fmt.Errorf(
+			"no code package is available for the function (repository type %q)",
+			aws.StringValue(function.Code.RepositoryType))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, *function.Code.Location, nil)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create the code package request")
+	}
+
+	client := http.Client{Timeout: codeDownloadTimeout}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to download the code package")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf(
+			"failed to download the code package: %s", res.Status)
+	}
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read the code package")
+	}
+
+	return data, function.Configuration, nil
+}
+
+// persistCode downloads the code package of the function to "code.zip" in
+// the context output directory, and optionally unpacks it to "code/".
+func persistCode(
+	ctx *concourse.CommandContext, api LambdaAPI,
+	functionName string, qualifier *string, unpack bool,
+) error {
+	data, config, err := downloadCode(ctx, api, functionName, qualifier)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.File("code.zip", data); err != nil {
+		return errors.Wrap(err, "failed to persist code package")
+	}
+
+	ctx.Infof("downloaded the code of version %s (sha256: %s)",
+		aws.StringValue(config.Version), aws.StringValue(config.CodeSha256))
+
+	if unpack {
+		if err := unzip(data, ctx.Path("code")); err != nil {
+			return errors.Wrap(err, "failed to unpack code package")
+		}
+	}
+
+	return nil
+}
+
+// unzip extracts the archive into the directory
+func unzip(archive []byte, dir string) error {
+	r, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return errors.Wrap(err, "failed to read archive")
+	}
+
+	root := filepath.Clean(dir) + string(filepath.Separator)
+
+	for _, f := range r.File {
+		target := filepath.Join(dir, f.Name)
+		if !strings.HasPrefix(target, root) {
+			return fmt.Errorf("the archive path %q is outside of the target", f.Name)
+		}
+
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return errors.Wrapf(err, "failed to create %q", target)
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return errors.Wrapf(err, "failed to create %q", filepath.Dir(target))
+		}
+		if err := unzipFile(f, target); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func unzipFile(f *zip.File, target string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %q in archive", f.Name)
+	}
+	defer rc.Close()
+
+	mode := f.Mode().Perm()
+	if mode == 0 {
+		mode = 0644
+	}
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create %q", target)
+	}
+
+	if _, err := io.Copy(out, rc); err != nil {
+		_ = out.Close()
+		return errors.Wrapf(err, "failed to extract %q", f.Name)
+	}
+
+	return errors.Wrapf(out.Close(), "failed to write %q", target)
+}
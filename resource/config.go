@@ -0,0 +1,419 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// FunctionConfig is the desired configuration of a function. Fields that
+// are left out are not managed by the resource.
+type FunctionConfig struct {
+	// Description of the function
+	Description *string `json:"description"`
+	// Handler is the function entrypoint
+	Handler *string `json:"handler"`
+	// Runtime is the identifier of the function runtime
+	Runtime *string `json:"runtime"`
+	// Role is the ARN of the function execution role
+	Role *string `json:"role"`
+	// MemorySize is the amount of memory, in MB, available to the function
+	MemorySize *int64 `json:"memory_size"`
+	// Timeout is the function timeout in seconds
+	Timeout *int64 `json:"timeout"`
+	// EphemeralStorage is the size of /tmp in MB
+	EphemeralStorage *int64 `json:"ephemeral_storage"`
+	// Environment is the environment variables of the function
+	Environment Variables `json:"environment"`
+	// Layers is a list of layer version ARNs
+	Layers []string `json:"layers"`
+	// Tracing is the X-Ray tracing mode, "Active" or "PassThrough"
+	Tracing *string `json:"tracing"`
+	// SensitiveEnvironment is the names of environment variables with
+	// values that are redacted from the build log
+	SensitiveEnvironment []string `json:"sensitive_environment"`
+}
+
+// Variables is a set of string variables. Numbers and booleans are
+// accepted as values and converted to strings.
+type Variables map[string]string
+
+// UnmarshalJSON unmarshals a JSON object into the variables
+func (v *Variables) UnmarshalJSON(data []byte) error {
+	var raw map[string]interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+	if raw == nil {
+		*v = nil
+		return nil
+	}
+
+	vars := make(Variables, len(raw))
+	for name, value := range raw {
+		switch value := value.(type) {
+		case string:
+			vars[name] = value
+		case json.Number, bool:
+			vars[name] = fmt.Sprint(value)
+		case nil:
+			vars[name] = ""
+		default:
+			return fmt.Errorf("the value of %q must be a string", name)
+		}
+	}
+
+	*v = vars
+	return nil
+}
+
+// LoadFunctionConfig reads the function configuration from a JSON or YAML
+// file.
+func LoadFunctionConfig(name string) (*FunctionConfig, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read config file %q", name)
+	}
+
+	var config FunctionConfig
+	if err := unmarshalYAML(data, &config); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse config file %q", name)
+	}
+
+	return &config, nil
+}
+
+// Interpolate returns a copy of the configuration where ${NAME}
+// placeholders in the environment variables have been replaced.
+func (fc *FunctionConfig) Interpolate(vars map[string]string) *FunctionConfig {
+	c := *fc
+	if fc.Environment != nil {
+		c.Environment = make(Variables, len(fc.Environment))
+		for name, value := range fc.Environment {
+			c.Environment[name] = interpolate(value, vars)
+		}
+	}
+	return &c
+}
+
+// sensitiveValues returns the values of the sensitive environment variables
+func (fc *FunctionConfig) sensitiveValues() []string {
+	values := make([]string, 0, len(fc.SensitiveEnvironment))
+	for _, name := range fc.SensitiveEnvironment {
+		if value, ok := fc.Environment[name]; ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// UsesVariable checks if the configuration has a placeholder for the
+// variable.
+func (fc *FunctionConfig) UsesVariable(name string) bool {
+	for _, value := range fc.Environment {
+		if hasPlaceholder(value, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// unmarshalYAML unmarshals YAML (or JSON) data using the JSON struct tags
+// of the target.
+func unmarshalYAML(data []byte, v interface{}) error {
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+
+	doc, err := jsonCompatible(doc)
+	if err != nil {
+		return err
+	}
+
+	jsonData, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(jsonData, v)
+}
+
+// jsonCompatible converts the map[interface{}]interface{} values produced
+// by the YAML decoder to map[string]interface{}.
+func jsonCompatible(v interface{}) (interface{}, error) {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			key, ok := k.(string)
+			if !ok {
+				key = fmt.Sprint(k)
+			}
+			converted, err := jsonCompatible(item)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = converted
+		}
+		return m, nil
+	case []interface{}:
+		for i, item := range value {
+			converted, err := jsonCompatible(item)
+			if err != nil {
+				return nil, err
+			}
+			value[i] = converted
+		}
+		return value, nil
+	}
+	return v, nil
+}
+
+// Diff compares the desired configuration against the live configuration
+// of the function. Returns an update input with the changed fields and
+// human readable descriptions of the changes.
+func (fc *FunctionConfig) Diff(
+	live *lambda.FunctionConfiguration,
+) (*lambda.UpdateFunctionConfigurationInput, []string) {
+	input := lambda.UpdateFunctionConfigurationInput{
+		FunctionName: live.FunctionName,
+	}
+	var changes []string
+
+	diffString := func(name string, desired, current *string, set func()) {
+		if desired != nil && *desired != aws.StringValue(current) {
+			changes = append(changes, fmt.Sprintf(
+				"%s: %q -> %q", name, aws.StringValue(current), *desired))
+			set()
+		}
+	}
+	diffInt := func(name string, desired, current *int64, set func()) {
+		if desired != nil && *desired != aws.Int64Value(current) {
+			changes = append(changes, fmt.Sprintf(
+				"%s: %d -> %d", name, aws.Int64Value(current), *desired))
+			set()
+		}
+	}
+
+	diffString("description", fc.Description, live.Description, func() {
+		input.Description = fc.Description
+	})
+	diffString("handler", fc.Handler, live.Handler, func() {
+		input.Handler = fc.Handler
+	})
+	diffString("runtime", fc.Runtime, live.Runtime, func() {
+		input.Runtime = fc.Runtime
+	})
+	diffString("role", fc.Role, live.Role, func() {
+		input.Role = fc.Role
+	})
+	diffInt("memory_size", fc.MemorySize, live.MemorySize, func() {
+		input.MemorySize = fc.MemorySize
+	})
+	diffInt("timeout", fc.Timeout, live.Timeout, func() {
+		input.Timeout = fc.Timeout
+	})
+
+	var liveStorage *int64
+	if live.EphemeralStorage != nil {
+		liveStorage = live.EphemeralStorage.Size
+	}
+	diffInt("ephemeral_storage", fc.EphemeralStorage, liveStorage, func() {
+		input.EphemeralStorage = &lambda.EphemeralStorage{
+			Size: fc.EphemeralStorage,
+		}
+	})
+
+	var liveTracing *string
+	if live.TracingConfig != nil {
+		liveTracing = live.TracingConfig.Mode
+	}
+	diffString("tracing", fc.Tracing, liveTracing, func() {
+		input.TracingConfig = &lambda.TracingConfig{Mode: fc.Tracing}
+	})
+
+	if fc.Environment != nil {
+		liveEnv := map[string]string{}
+		if live.Environment != nil {
+			liveEnv = aws.StringValueMap(live.Environment.Variables)
+		}
+		if envChanges := diffEnvironment(fc.Environment, liveEnv); len(envChanges) > 0 {
+			changes = append(changes, envChanges...)
+			input.Environment = &lambda.Environment{
+				Variables: aws.StringMap(fc.Environment),
+			}
+		}
+	}
+
+	if fc.Layers != nil {
+		liveLayers := []string{}
+		for _, l := range live.Layers {
+			liveLayers = append(liveLayers, aws.StringValue(l.Arn))
+		}
+		if !reflect.DeepEqual(fc.Layers, liveLayers) {
+			changes = append(changes, fmt.Sprintf("layers: [%s] -> [%s]",
+				strings.Join(liveLayers, ", "), strings.Join(fc.Layers, ", ")))
+			input.Layers = aws.StringSlice(fc.Layers)
+		}
+	}
+
+	return &input, changes
+}
+
+// diffEnvironment describes the changed environment variables. Values are
+// left out as they can contain secrets.
+func diffEnvironment(desired, live map[string]string) []string {
+	var changes []string
+
+	for name, value := range desired {
+		current, ok := live[name]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("environment: added %s", name))
+		case current != value:
+			changes = append(changes, fmt.Sprintf("environment: changed %s", name))
+		}
+	}
+	for name := range live {
+		if _, ok := desired[name]; !ok {
+			changes = append(changes, fmt.Sprintf("environment: removed %s", name))
+		}
+	}
+
+	sort.Strings(changes)
+	return changes
+}
+
+// DriftError is returned when the live function configuration has drifted
+// from the desired configuration.
+type DriftError struct {
+	Changes []string
+}
+
+// Error returns a description of the drift
+func (de DriftError) Error() string {
+	return fmt.Sprintf(
+		"the function configuration has drifted from the desired state: %s",
+		strings.Join(de.Changes, "; "),
+	)
+}
+
+// syncFunctionConfig applies the desired configuration to the function
+// and waits for the update to complete. Returns the applied changes. If
+// failOnDrift is set a DriftError is returned instead of applying changes.
+func syncFunctionConfig(
+	ctx context.Context, log *concourse.Logger, api LambdaAPI,
+	functionName string, desired *FunctionConfig,
+	failOnDrift bool,
+) ([]string, error) {
+	live, err := api.GetFunctionConfigurationWithContext(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: &functionName,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get function configuration")
+	}
+
+	input, changes := desired.Diff(live)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	if failOnDrift {
+		return changes, DriftError{Changes: changes}
+	}
+
+	input.FunctionName = &functionName
+	err = conflictRetry.Do(ctx, log, "configuration update", func() error {
+		_, err := api.UpdateFunctionConfigurationWithContext(ctx, input)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to update function configuration")
+	}
+
+	if err := api.WaitUntilFunctionUpdatedWithContext(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: &functionName,
+	}); err != nil {
+		return nil, errors.Wrap(err, "failed to wait for the configuration update")
+	}
+
+	return changes, nil
+}
+
+const (
+	// RuntimeManagementAuto updates the runtime automatically
+	RuntimeManagementAuto = "auto"
+	// RuntimeManagementFunctionUpdate updates the runtime when the function
+	// is updated
+	RuntimeManagementFunctionUpdate = "function-update"
+)
+
+// validateRuntimeManagement checks that the mode is "auto",
+// "function-update", or a runtime version ARN, f.ex.
+// "arn:aws:lambda:eu-west-1::runtime:<id>".
+func validateRuntimeManagement(mode string) error {
+	switch mode {
+	case RuntimeManagementAuto, RuntimeManagementFunctionUpdate:
+		return nil
+	}
+	if !strings.HasPrefix(mode, "arn:") {
+		return fmt.Errorf(
+			"unsupported runtime_management mode %q, use %q, %q, or a runtime version ARN",
+			mode, RuntimeManagementAuto, RuntimeManagementFunctionUpdate)
+	}
+
+	parsed, err := arn.Parse(mode)
+	if err != nil {
+		return errors.Wrapf(err, "invalid runtime version ARN %q", mode)
+	}
+	id := strings.TrimPrefix(parsed.Resource, "runtime:")
+	if parsed.Service != "lambda" || parsed.Region == "" ||
+		id == parsed.Resource || id == "" || strings.Contains(id, ":") {
+		return fmt.Errorf("invalid runtime version ARN %q", mode)
+	}
+	return nil
+}
+
+// putRuntimeManagement sets the runtime update mode of the function. The
+// mode is either "auto", "function-update", or a runtime version ARN that
+// the function should be pinned to.
+func putRuntimeManagement(
+	ctx context.Context, api LambdaAPI, functionName, mode string,
+) error {
+	if err := validateRuntimeManagement(mode); err != nil {
+		return err
+	}
+
+	input := lambda.PutRuntimeManagementConfigInput{
+		FunctionName: &functionName,
+	}
+
+	switch mode {
+	case RuntimeManagementAuto:
+		input.UpdateRuntimeOn = aws.String(lambda.UpdateRuntimeOnAuto)
+	case RuntimeManagementFunctionUpdate:
+		input.UpdateRuntimeOn = aws.String(lambda.UpdateRuntimeOnFunctionUpdate)
+	default:
+		input.UpdateRuntimeOn = aws.String(lambda.UpdateRuntimeOnManual)
+		input.RuntimeVersionArn = &mode
+	}
+
+	_, err := api.PutRuntimeManagementConfigWithContext(ctx, &input)
+	return errors.Wrap(err, "failed to set runtime management configuration")
+}
@@ -0,0 +1,33 @@
+package resource
+
+import "testing"
+
+func TestValidateRuntimeManagement(t *testing.T) {
+	valid := []string{
+		"auto",
+		"function-update",
+		"arn:aws:lambda:eu-west-1::runtime:8eeff65f6809a3ce81507fe733fe09b835899b99481ba22fd75b5a7338290ec1",
+		"arn:aws-cn:lambda:cn-north-1::runtime:0a1b2c",
+	}
+	for _, mode := range valid {
+		if err := validateRuntimeManagement(mode); err != nil {
+			t.Errorf("validateRuntimeManagement(%q) failed: %v", mode, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"Auto",
+		"manual",
+		"arn:",
+		"arn:aws:lambda:eu-west-1:123456789012:function:my-function",
+		"arn:aws:sqs:eu-west-1::runtime:0a1b2c",
+		"arn:aws:lambda:::runtime:0a1b2c",
+		"arn:aws:lambda:eu-west-1::runtime:",
+	}
+	for _, mode := range invalid {
+		if err := validateRuntimeManagement(mode); err == nil {
+			t.Errorf("validateRuntimeManagement(%q) succeeded, want an error", mode)
+		}
+	}
+}
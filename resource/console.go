@@ -0,0 +1,48 @@
+package resource
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// consoleHost returns the host of the AWS console for the partition that
+// the region belongs to.
+func consoleHost(region string) string {
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return "console.amazonaws.cn"
+	case strings.HasPrefix(region, "us-gov-"):
+		return "console.amazonaws-us-gov.com"
+	}
+	return region + ".console.aws.amazon.com"
+}
+
+// consoleURL returns a link to a page of the Lambda console, the fragment
+// is the path of the page f.ex. "/functions/my-function".
+func consoleURL(region, fragment string) string {
+	return fmt.Sprintf("https://%s/lambda/home?region=%s#%s",
+		consoleHost(region), url.QueryEscape(region), fragment)
+}
+
+// functionConsoleURL links to a version of a function, or to the function
+// itself if the version is empty.
+func functionConsoleURL(region, functionName, version string) string {
+	fragment := "/functions/" + url.PathEscape(functionName)
+	if version != "" && version != "$LATEST" {
+		fragment += "/versions/" + url.PathEscape(version)
+	}
+	return consoleURL(region, fragment)
+}
+
+// aliasConsoleURL links to an alias of a function
+func aliasConsoleURL(region, functionName, alias string) string {
+	return consoleURL(region, "/functions/"+url.PathEscape(functionName)+
+		"/aliases/"+url.PathEscape(alias))
+}
+
+// layerConsoleURL links to a version of a layer
+func layerConsoleURL(region, layerName, version string) string {
+	return consoleURL(region, "/layers/"+url.PathEscape(layerName)+
+		"/versions/"+url.PathEscape(version))
+}
@@ -0,0 +1,235 @@
+package resource
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/pkg/errors"
+)
+
+// defaultDestinationWait is how long we wait for the destination record of
+// an asynchronous invocation by default.
+const defaultDestinationWait = 2 * time.Minute
+
+// destinationPollBackoff is the delay between receives when the queue
+// only returned the records of other invocations, it's doubled up to
+// maxDestinationPollBackoff.
+const (
+	destinationPollBackoff    = time.Second
+	maxDestinationPollBackoff = 10 * time.Second
+)
+
+// destinationVisibilityTimeout is how long, in seconds, the received
+// records of other invocations are hidden from other receivers.
+const destinationVisibilityTimeout = 10
+
+// DestinationRecord is the invocation record that Lambda sends to the
+// destinations of asynchronous invocations.
+type DestinationRecord struct {
+	RequestContext struct {
+		RequestID              string `json:"requestId"`
+		FunctionArn            string `json:"functionArn"`
+		Condition              string `json:"condition"`
+		ApproximateInvokeCount int    `json:"approximateInvokeCount"`
+	} `json:"requestContext"`
+	ResponsePayload json.RawMessage `json:"responsePayload"`
+}
+
+// Succeeded checks if the record was sent for a successful invocation
+func (r *DestinationRecord) Succeeded() bool {
+	return r.RequestContext.Condition == "Success"
+}
+
+// destinationQueues returns the ARNs of the SQS queues that the function
+// sends asynchronous invocation records to.
+func destinationQueues(
+	ctx context.Context, api LambdaAPI, functionName string, qualifier *string,
+) ([]string, error) {
+	config, err := api.GetFunctionEventInvokeConfigWithContext(ctx, &lambda.GetFunctionEventInvokeConfigInput{
+		FunctionName: &functionName,
+		Qualifier:    qualifier,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the asynchronous invocation configuration")
+	}
+
+	var destinations []string
+	if dc := config.DestinationConfig; dc != nil {
+		if dc.OnSuccess != nil && dc.OnSuccess.Destination != nil {
+			destinations = append(destinations, *dc.OnSuccess.Destination)
+		}
+		if dc.OnFailure != nil && dc.OnFailure.Destination != nil {
+			destinations = append(destinations, *dc.OnFailure.Destination)
+		}
+	}
+
+	var queues []string
+	for _, destination := range destinations {
+		if _, err := parseQueueARN(destination); err == nil && !contains(queues, destination) {
+			queues = append(queues, destination)
+		}
+	}
+	if len(queues) == 0 {
+		return nil, errors.New("the function has no SQS destinations for asynchronous invocations")
+	}
+
+	return queues, nil
+}
+
+// parseQueueARN parses the ARN of an SQS queue in any partition, f.ex.
+// "arn:aws-cn:sqs:cn-north-1:123456789012:queue-name".
+func parseQueueARN(queueARN string) (arn.ARN, error) {
+	parsed, err := arn.Parse(queueARN)
+	if err != nil {
+		return arn.ARN{}, errors.Wrapf(err, "invalid SQS queue ARN %q", queueARN)
+	}
+	if parsed.Service != "sqs" {
+		return arn.ARN{}, fmt.Errorf(
+			"%q isn't an SQS queue, only SQS destinations can be polled, EventBridge, SNS, and Lambda destinations aren't supported",
+			queueARN)
+	}
+	if parsed.Resource == "" || strings.Contains(parsed.Resource, ":") {
+		return arn.ARN{}, fmt.Errorf("invalid SQS queue ARN %q", queueARN)
+	}
+	return parsed, nil
+}
+
+// sqsQueue is a queue that destination records are polled from
+type sqsQueue struct {
+	api *sqs.SQS
+	url string
+}
+
+// newSQSQueue looks up the URL of a queue from its ARN
+func newSQSQueue(ctx context.Context, source Source, queueARN string) (*sqsQueue, error) {
+	parsed, err := parseQueueARN(queueARN)
+	if err != nil {
+		return nil, err
+	}
+
+	api := sqs.New(awsSession(source), aws.NewConfig().WithRegion(parsed.Region))
+	out, err := api.GetQueueUrlWithContext(ctx, &sqs.GetQueueUrlInput{
+		QueueName:              &parsed.Resource,
+		QueueOwnerAWSAccountId: &parsed.AccountID,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get the URL of %q", queueARN)
+	}
+
+	return &sqsQueue{api: api, url: aws.StringValue(out.QueueUrl)}, nil
+}
+
+// receive polls the queue for the record of the request. The matching
+// message is deleted, the records of other invocations, f.ex. of parallel
+// builds, become visible again when their visibility timeout expires. The
+// number of received messages is returned to back off when there only were
+// records of other invocations.
+func (q *sqsQueue) receive(
+	ctx context.Context, requestID string,
+) (*DestinationRecord, []byte, int, error) {
+	out, err := q.api.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            &q.url,
+		MaxNumberOfMessages: aws.Int64(10),
+		WaitTimeSeconds:     aws.Int64(5),
+		VisibilityTimeout:   aws.Int64(destinationVisibilityTimeout),
+	})
+	if err != nil {
+		return nil, nil, 0, errors.Wrap(err, "failed to receive messages")
+	}
+
+	var match *DestinationRecord
+	var body []byte
+	for _, msg := range out.Messages {
+		var record DestinationRecord
+		data := []byte(aws.StringValue(msg.Body))
+		if err := json.Unmarshal(data, &record); err != nil ||
+			match != nil || record.RequestContext.RequestID != requestID {
+			continue
+		}
+
+		match, body = &record, data
+		if _, err := q.api.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+			QueueUrl:      &q.url,
+			ReceiptHandle: msg.ReceiptHandle,
+		}); err != nil {
+			return nil, nil, 0, errors.Wrap(err, "failed to delete the destination record")
+		}
+	}
+
+	return match, body, len(out.Messages), nil
+}
+
+// pollDestination polls a dedicated SQS destination queue of the function
+// until the record of the asynchronous invocation shows up or the wait
+// runs out.
+func pollDestination(
+	ctx context.Context, log *concourse.Logger, api LambdaAPI,
+	source Source, qualifier *string, queueARN string,
+	requestID string, wait time.Duration,
+) (*DestinationRecord, []byte, error) {
+	arns, err := destinationQueues(ctx, api, source.FunctionName, qualifier)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !contains(arns, queueARN) {
+		return nil, nil, fmt.Errorf(
+			"the destination_queue %q isn't a destination of the function, the destinations are: %s",
+			queueARN, strings.Join(arns, ", "))
+	}
+
+	queue, err := newSQSQueue(ctx, source, queueARN)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Infof("waiting for the destination record of %s in %s", requestID, queueARN)
+
+	deadline := time.Now().Add(wait)
+	backoff := destinationPollBackoff
+	for time.Now().Before(deadline) {
+		record, body, received, err := queue.receive(ctx, requestID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if record != nil {
+			return record, body, nil
+		}
+		if received == 0 {
+			// The receive already waited for messages.
+			backoff = destinationPollBackoff
+			continue
+		}
+
+		// Only records of other invocations, they stay hidden until
+		// their visibility timeout expires.
+		if remaining := time.Until(deadline); remaining < backoff {
+			backoff = remaining
+		}
+		if err := sleepContext(ctx, backoff); err != nil {
+			return nil, nil, errors.Wrap(err, "waiting for the destination record was interrupted")
+		}
+		if backoff *= 2; backoff > maxDestinationPollBackoff {
+			backoff = maxDestinationPollBackoff
+		}
+	}
+
+	return nil, nil, fmt.Errorf(
+		"no destination record for request %s showed up within %s", requestID, wait)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
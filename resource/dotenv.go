@@ -0,0 +1,53 @@
+package resource
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// Output formats of the get step
+const (
+	OutputFormatJSON   = "json"
+	OutputFormatDotenv = "dotenv"
+)
+
+// dotenvVariables returns the variables that are written to "lambda.env".
+// The function configuration is optional, all metadata entries are added
+// as well, f.ex. "request_id" becomes "LAMBDA_REQUEST_ID".
+func dotenvVariables(
+	source Source, alias *string, config *lambda.FunctionConfiguration,
+	resp *concourse.CommandResponse,
+) map[string]string {
+	vars := map[string]string{
+		"LAMBDA_FUNCTION_NAME": source.FunctionName,
+	}
+	if alias != nil {
+		vars["LAMBDA_ALIAS"] = *alias
+	}
+	if config != nil {
+		vars["LAMBDA_VERSION"] = aws.StringValue(config.Version)
+		vars["LAMBDA_ARN"] = aws.StringValue(config.FunctionArn)
+		vars["LAMBDA_RUNTIME"] = aws.StringValue(config.Runtime)
+		vars["LAMBDA_CODE_SHA256"] = aws.StringValue(config.CodeSha256)
+	}
+	for _, meta := range resp.Metadata {
+		vars["LAMBDA_"+strings.ToUpper(meta.Name)] = meta.Value
+	}
+	return vars
+}
+
+// formatDotenv formats the variables as shell assignments that can be
+// sourced. Values are single quoted.
+func formatDotenv(vars map[string]string) []byte {
+	var buf bytes.Buffer
+	for _, name := range sortedKeys(vars) {
+		value := strings.Replace(vars[name], "'", `'\''`, -1)
+		fmt.Fprintf(&buf, "%s='%s'\n", name, value)
+	}
+	return buf.Bytes()
+}
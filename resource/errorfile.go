@@ -0,0 +1,72 @@
+package resource
+
+import (
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/pkg/errors"
+)
+
+// ErrorFileName is the name of the file that a failed get or put writes
+// the error to.
+const ErrorFileName = "error.json"
+
+// CommandError describes why a get or put failed, or the function error
+// that a get allowed. The fields of function errors are included.
+type CommandError struct {
+	// Category is the error category, f.ex. "config" or "function_error"
+	Category string `json:"category"`
+	// Message is the error message
+	Message string `json:"message"`
+	// RequestID is the ID of the failed AWS request, if an AWS request
+	// failed.
+	RequestID string `json:"request_id,omitempty"`
+	// Step is the step of the put that failed, f.ex. "uploading the code"
+	Step string `json:"step,omitempty"`
+
+	*FunctionError
+}
+
+// stepCommand is implemented by commands that track which step they are in
+type stepCommand interface {
+	currentStep() string
+}
+
+// newCommandError describes a categorised command error
+func newCommandError(cmd concourse.CommandHandler, err error) *CommandError {
+	ce := &CommandError{
+		Category: concourse.ErrorCategory(err).Category,
+		Message:  err.Error(),
+	}
+
+	switch cause := errors.Cause(err).(type) {
+	case awserr.RequestFailure:
+		ce.RequestID = cause.RequestID()
+	case FunctionError:
+		ce.FunctionError = &cause
+	}
+
+	if sc, ok := cmd.(stepCommand); ok {
+		ce.Step = sc.currentStep()
+	}
+
+	return ce
+}
+
+// errorFile writes the error of a failed get or put to error.json in the
+// directory of the command. It's the only writer of the file for failed
+// commands, gets write it themselves for allowed function errors.
+func errorFile(next concourse.CommandFunc) concourse.CommandFunc {
+	return func(
+		ctx *concourse.CommandContext, cmd concourse.CommandHandler,
+	) (*concourse.CommandResponse, error) {
+		resp, err := next(ctx, cmd)
+		if err == nil || ctx.CommandName() == "check" {
+			return resp, err
+		}
+
+		if werr := ctx.JSON(ErrorFileName, newCommandError(cmd, err)); werr != nil {
+			ctx.Warnf("failed to write %s: %s", ErrorFileName, werr.Error())
+		}
+		return resp, err
+	}
+}
@@ -0,0 +1,78 @@
+package resource
+
+import (
+	"context"
+
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+	"github.com/pkg/errors"
+)
+
+// The exit codes of the error categories, in addition to the ones of the
+// concourse package.
+const (
+	// ExitAuth is the exit code of AWS authentication and authorisation
+	// errors.
+	ExitAuth = 3
+	// ExitThrottled is the exit code of requests that were throttled by
+	// AWS after the retries ran out.
+	ExitThrottled = 4
+	// ExitFunctionError is the exit code of invocations that failed
+	// because the function returned an error.
+	ExitFunctionError = 5
+	// ExitPackaging is the exit code of failures to build or validate the
+	// code package.
+	ExitPackaging = 6
+)
+
+// The error categories of the resource
+const (
+	CategoryAuth          = "aws_auth"
+	CategoryThrottled     = "throttling"
+	CategoryFunctionError = "function_error"
+	CategoryPackaging     = "packaging"
+)
+
+// authErrorCodes are the AWS error codes for missing, invalid, or
+// insufficient credentials.
+var authErrorCodes = map[string]bool{
+	"AccessDeniedException":       true,
+	"AccessDenied":                true,
+	"UnrecognizedClientException": true,
+	"InvalidSignatureException":   true,
+	"SignatureDoesNotMatch":       true,
+	"ExpiredTokenException":       true,
+	"ExpiredToken":                true,
+	"InvalidClientTokenId":        true,
+	"MissingAuthenticationToken":  true,
+	"NoCredentialProviders":       true,
+}
+
+// packagingError categorises an error as a packaging error
+func packagingError(err error) error {
+	return concourse.NewError(CategoryPackaging, ExitPackaging, err)
+}
+
+// classifyError categorises a command error from the cause, errors that
+// already have a category are returned as they are. Errors of commands
+// that were aborted are categorised as such.
+func classifyError(ctx context.Context, err error) error {
+	if err == nil || concourse.ErrorCategory(err).Category != concourse.CategoryUnknown {
+		return err
+	}
+	if ctx.Err() == context.Canceled {
+		return concourse.NewError(concourse.CategoryAborted, concourse.ExitAborted, err)
+	}
+
+	cause := errors.Cause(err)
+	switch {
+	case authErrorCodes[awsErrorCode(cause)]:
+		return concourse.NewError(CategoryAuth, ExitAuth, err)
+	case isThrottled(cause):
+		return concourse.NewError(CategoryThrottled, ExitThrottled, err)
+	}
+	if _, ok := cause.(FunctionError); ok {
+		return concourse.NewError(CategoryFunctionError, ExitFunctionError, err)
+	}
+
+	return err
+}
@@ -0,0 +1,173 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/jmespath/go-jmespath"
+	"github.com/pkg/errors"
+)
+
+// Expectation describes assertions that are evaluated against the result
+// of an invocation. Paths are JMESPath expressions evaluated against the
+// response payload.
+type Expectation struct {
+	// StatusCode is the expected status code of the invocation
+	StatusCode *int64 `json:"status_code"`
+	// Equals maps paths to the values they should have
+	Equals map[string]interface{} `json:"equals"`
+	// Contains maps paths to values that they should contain. Strings
+	// should contain the value as a substring and lists should have an
+	// element that equals the value.
+	Contains map[string]interface{} `json:"contains"`
+	// Matches is a regular expression that the payload should match
+	Matches *string `json:"matches"`
+}
+
+// ExpectationError is returned when the result of an invocation doesn't
+// meet the expectations.
+type ExpectationError struct {
+	Failures []string
+}
+
+// Error returns a description of the failed expectations
+func (ee ExpectationError) Error() string {
+	return fmt.Sprintf(
+		"the invocation result didn't meet the expectations: %s",
+		strings.Join(ee.Failures, "; "),
+	)
+}
+
+// Check evaluates the expectations against the result of an invocation.
+// Returns an ExpectationError if any of the expectations fail.
+func (e *Expectation) Check(result *InvokeResult) error {
+	var failures []string
+
+	if e.StatusCode != nil {
+		if status := aws.Int64Value(result.StatusCode); status != *e.StatusCode {
+			failures = append(failures, fmt.Sprintf(
+				"status code: expected %d, got %d", *e.StatusCode, status))
+		}
+	}
+
+	if e.Matches != nil {
+		re, err := regexp.Compile(*e.Matches)
+		if err != nil {
+			return errors.Wrapf(err, "invalid expect pattern %q", *e.Matches)
+		}
+		if !re.Match(result.Payload) {
+			failures = append(failures, fmt.Sprintf(
+				"payload doesn't match %q", *e.Matches))
+		}
+	}
+
+	if len(e.Equals) > 0 || len(e.Contains) > 0 {
+		var payload interface{}
+		if err := json.Unmarshal(result.Payload, &payload); err != nil {
+			failures = append(failures, "payload isn't valid JSON")
+			return ExpectationError{Failures: failures}
+		}
+
+		pathFailures, err := e.checkPaths(payload)
+		if err != nil {
+			return err
+		}
+		failures = append(failures, pathFailures...)
+	}
+
+	if len(failures) > 0 {
+		return ExpectationError{Failures: failures}
+	}
+	return nil
+}
+
+// checkPaths evaluates the equals and contains expectations against the
+// decoded payload.
+func (e *Expectation) checkPaths(payload interface{}) ([]string, error) {
+	var failures []string
+
+	for _, path := range sortedValueKeys(e.Equals) {
+		actual, expected, err := searchPath(payload, path, e.Equals[path])
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			failures = append(failures, fmt.Sprintf(
+				"%s: expected %s, got %s",
+				path, describeValue(expected), describeValue(actual)))
+		}
+	}
+
+	for _, path := range sortedValueKeys(e.Contains) {
+		actual, expected, err := searchPath(payload, path, e.Contains[path])
+		if err != nil {
+			return nil, err
+		}
+		if !containsValue(actual, expected) {
+			failures = append(failures, fmt.Sprintf(
+				"%s: expected %s to contain %s",
+				path, describeValue(actual), describeValue(expected)))
+		}
+	}
+
+	return failures, nil
+}
+
+// searchPath looks up the path in the payload. The expected value is
+// normalised so that it can be compared to the decoded payload.
+func searchPath(
+	payload interface{}, path string, expected interface{},
+) (interface{}, interface{}, error) {
+	actual, err := jmespath.Search(path, payload)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "invalid expect path %q", path)
+	}
+
+	data, err := json.Marshal(expected)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "invalid expected value for %q", path)
+	}
+	var normalised interface{}
+	if err := json.Unmarshal(data, &normalised); err != nil {
+		return nil, nil, errors.Wrapf(err, "invalid expected value for %q", path)
+	}
+
+	return actual, normalised, nil
+}
+
+func containsValue(actual, expected interface{}) bool {
+	switch actual := actual.(type) {
+	case string:
+		s, ok := expected.(string)
+		return ok && strings.Contains(actual, s)
+	case []interface{}:
+		for _, item := range actual {
+			if reflect.DeepEqual(item, expected) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func describeValue(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+func sortedValueKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,58 @@
+package resource
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse/resourcetest"
+)
+
+// TestFixtures runs the commands with the JSON fixtures in testdata as
+// input and compares the response and the written files with the golden
+// files next to them. Run with RESOURCETEST_UPDATE=1 to update them.
+func TestFixtures(t *testing.T) {
+	handler := &concourse.Resource{
+		Check: &CheckCommand{},
+		In:    &InCommand{},
+		Out:   &OutCommand{},
+	}
+
+	tests := []struct {
+		fixture string
+		command string
+		wantErr bool
+	}{
+		{fixture: "in-skip", command: "in"},
+		{fixture: "in-skip-payload", command: "in", wantErr: true},
+		{fixture: "in-url-version", command: "in", wantErr: true},
+	}
+	for _, tt := range tests {
+		fixture := filepath.Join("testdata", tt.fixture)
+		res, err := resourcetest.RunFixture(handler, tt.command, fixture+".json")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.fixture, err)
+		}
+
+		if tt.wantErr {
+			if res.Err == nil {
+				t.Errorf("%s: expected an error", tt.fixture)
+			}
+			res.Cleanup()
+			continue
+		}
+		if res.Err != nil {
+			t.Errorf("%s: %v", tt.fixture, res.Err)
+		}
+
+		resourcetest.Golden(t, fixture+".stdout.golden", res.Stdout)
+		files, err := res.Files()
+		if err != nil {
+			t.Errorf("%s: %v", tt.fixture, err)
+		}
+		for name, data := range files {
+			resourcetest.Golden(t, fixture+"."+name+".golden", data)
+		}
+		res.Cleanup()
+	}
+}
@@ -2,10 +2,13 @@ package resource
 
 import (
 	"fmt"
+	"io/ioutil"
 	"strconv"
 	"time"
 
-	"github.com/Sydsvenskan/concourse"
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/pkg/errors"
 )
 
@@ -17,61 +20,893 @@ type InCommand struct {
 	Params InParams `json:"params"`
 	// Version is used in the implicit post `put` `get`
 	Version concourse.ResourceVersion
+	// API is the Lambda API to use, defaults to a client from the source
+	API LambdaAPI `json:"-"`
 }
 
 // InParams is the params used when get:ing a resource (invoking a function).
 type InParams struct {
 	// PayloadSpec is the invoke payload
 	PayloadSpec
+	// InvokeOptions controls how the function is invoked
+	InvokeOptions
+	// LoadTest invokes the function repeatedly in parallel
+	LoadTest
+	// Poll invokes the function repeatedly until a condition is met
+	Poll
 	// Alias is the alias (if any) of the function that should be invoked
 	Alias *string `json:"alias"`
+	// Version is a version of the function that should be invoked
+	Version *string `json:"version"`
+	// UseResourceVersion invokes the version that is fetched
+	UseResourceVersion bool `json:"use_resource_version"`
+	// DownloadCode downloads the code package of the version to "code.zip"
+	DownloadCode bool `json:"download_code"`
+	// Unpack extracts the downloaded code package to "code/"
+	Unpack bool `json:"unpack"`
+	// IncludeTags writes the function tags to "tags.json"
+	IncludeTags bool `json:"include_tags"`
+	// IncludeEventSources writes the event source mappings of the function
+	// to "event-sources.json".
+	IncludeEventSources bool `json:"include_event_sources"`
+	// IncludePolicy writes the resource policy to "policy.json"
+	IncludePolicy bool `json:"include_policy"`
+	// IncludeFunctionURL writes the function URL configuration to
+	// "function-url.json".
+	IncludeFunctionURL bool `json:"include_function_url"`
+	// Skip only writes the version file without making any AWS calls
+	Skip bool `json:"skip"`
+	// Expect are assertions that the invocation result must meet
+	Expect *Expectation `json:"expect"`
+	// FetchLogs fetches the CloudWatch Logs events of the invocation to
+	// "logs.txt".
+	FetchLogs bool `json:"fetch_logs"`
+	// LogsWait is how long to wait for the logs to show up, f.ex. "1m"
+	LogsWait *string `json:"logs_wait"`
+	// AllowFunctionErrors is "handled" or "all" to persist function errors
+	// as normal results instead of failing, defaults to "none".
+	AllowFunctionErrors *string `json:"allow_function_errors"`
+	// PollDestination polls the SQS destinations of the function for the
+	// record of an asynchronous invocation.
+	PollDestination bool `json:"poll_destination"`
+	// DestinationQueue is the ARN of the SQS destination queue to poll,
+	// it must not have other consumers.
+	DestinationQueue *string `json:"destination_queue"`
+	// DestinationWait is how long to wait for the destination record,
+	// f.ex. "5m".
+	DestinationWait *string `json:"destination_wait"`
+	// OutputFormat is "dotenv" to also write the version details and
+	// metadata to "lambda.env".
+	OutputFormat *string `json:"output_format"`
+}
+
+// includes checks if any additional details of the function should be
+// fetched.
+func (params *InParams) includes() bool {
+	return params.IncludeTags || params.IncludeEventSources ||
+		params.IncludePolicy || params.IncludeFunctionURL
+}
+
+// Function error tolerance levels
+const (
+	AllowFunctionErrorsNone    = "none"
+	AllowFunctionErrorsHandled = "handled"
+	AllowFunctionErrorsAll     = "all"
+)
+
+// allowsFunctionError checks if the function error of a result should be
+// treated as a normal result.
+func (params *InParams) allowsFunctionError(result *InvokeResult) bool {
+	if result == nil || result.FunctionError == nil {
+		return false
+	}
+	switch aws.StringValue(params.AllowFunctionErrors) {
+	case AllowFunctionErrorsAll:
+		return true
+	case AllowFunctionErrorsHandled:
+		return *result.FunctionError == string(HandledError)
+	}
+	return false
+}
+
+// destinationWait returns how long to wait for the destination record
+func (params *InParams) destinationWait() (time.Duration, error) {
+	return parseDurationParam("destination_wait", params.DestinationWait, defaultDestinationWait)
+}
+
+// logsWait returns how long to wait for the logs of the invocation
+func (params *InParams) logsWait() (time.Duration, error) {
+	return parseDurationParam("logs_wait", params.LogsWait, defaultLogsWait)
+}
+
+// qualifier returns the version or alias that the get refers to
+func (cmd *InCommand) qualifier() *string {
+	if v, ok := cmd.Version["version"]; ok {
+		return &v
+	}
+	if cmd.Params.Alias != nil {
+		return cmd.Params.Alias
+	}
+	return cmd.Source.Alias
+}
+
+// alias returns the alias (if any) from the params or the source
+func (cmd *InCommand) alias() *string {
+	if cmd.Params.Alias != nil {
+		return cmd.Params.Alias
+	}
+	return cmd.Source.Alias
+}
+
+// trackedAlias returns the alias (if any) that the resource tracks, for
+// resources that track multiple aliases it's the alias that moved.
+func (cmd *InCommand) trackedAlias() *string {
+	if alias, ok := cmd.Version["alias"]; ok {
+		return &alias
+	}
+	return cmd.Source.Alias
+}
+
+// invokeQualifier returns the version or alias that should be invoked
+func (cmd *InCommand) invokeQualifier() (*string, error) {
+	switch {
+	case cmd.Params.Version != nil:
+		return cmd.Params.Version, nil
+	case cmd.Params.UseResourceVersion:
+		v, ok := cmd.Version["version"]
+		if !ok {
+			return nil, errors.New("there's no resource version to invoke")
+		}
+		return &v, nil
+	case cmd.Params.Alias != nil:
+		return cmd.Params.Alias, nil
+	}
+	return cmd.Source.Alias, nil
+}
+
+// CommandTimeout returns how long the command may run
+func (cmd *InCommand) CommandTimeout() (time.Duration, error) {
+	return cmd.Source.commandTimeout()
+}
+
+// LogFormat returns the format of the build log
+func (cmd *InCommand) LogFormat() (concourse.Format, error) {
+	return cmd.Source.logFormat()
+}
+
+// source returns the source definition for the middleware
+func (cmd *InCommand) source() Source {
+	return cmd.Source
+}
+
+// Validate checks that the params can be combined
+func (cmd *InCommand) Validate() error {
+	if cmd.Params.Skip && (cmd.Params.HasPayload() || cmd.Params.IsDryRun() ||
+		cmd.Params.DownloadCode || cmd.Params.includes()) {
+		return errors.New("skip can only be used without a payload, a dry run, download_code, and include_* params")
+	}
+	if cmd.Params.Unpack && !cmd.Params.DownloadCode {
+		return errors.New("unpack can only be used together with download_code")
+	}
+	if err := cmd.Params.validatePayload(); err != nil {
+		return err
+	}
+	if err := cmd.Params.InvokeOptions.validate(); err != nil {
+		return err
+	}
+	if cmd.Params.IsFunctionURL() {
+		// Function URLs only exist for $LATEST and aliases
+		if q, err := cmd.invokeQualifier(); err == nil && q != nil {
+			if _, err := strconv.Atoi(*q); err == nil {
+				return fmt.Errorf(
+					"version %s can't be invoked through a function URL, function URLs only exist for $LATEST and aliases", *q)
+			}
+		}
+	}
+	if err := cmd.Params.LoadTest.validate(); err != nil {
+		return err
+	}
+	if cmd.Params.IsLoadTest() {
+		batch := cmd.Params.Payloads != nil || cmd.Params.PayloadDir != nil
+		if !cmd.Params.HasPayload() || batch || !cmd.Params.IsSync() ||
+			cmd.Params.IsFunctionURL() || cmd.Params.IsResponseStream() || cmd.Params.FetchLogs {
+			return errors.New("iterations can only be used together with a single payload and a synchronous invocation through the Invoke API, and without fetch_logs")
+		}
+	}
+	if err := cmd.Params.validatePoll(); err != nil {
+		return err
+	}
+	if cmd.Params.IsPoll() {
+		batch := cmd.Params.Payloads != nil || cmd.Params.PayloadDir != nil
+		if !cmd.Params.HasPayload() || batch || !cmd.Params.IsSync() ||
+			cmd.Params.IsLoadTest() || cmd.Params.IsResponseStream() || cmd.Params.IsBinary() ||
+			cmd.Params.Expect != nil || cmd.Params.FetchLogs {
+			return errors.New("poll_until can only be used together with a single JSON payload and a synchronous invocation, and without iterations, expect, and fetch_logs")
+		}
+	}
+	switch aws.StringValue(cmd.Params.AllowFunctionErrors) {
+	case "", AllowFunctionErrorsNone, AllowFunctionErrorsHandled, AllowFunctionErrorsAll:
+	default:
+		return fmt.Errorf("unsupported allow_function_errors %q", *cmd.Params.AllowFunctionErrors)
+	}
+	switch aws.StringValue(cmd.Params.OutputFormat) {
+	case "", OutputFormatJSON, OutputFormatDotenv:
+	default:
+		return fmt.Errorf("unsupported output_format %q", *cmd.Params.OutputFormat)
+	}
+	if cmd.Params.Expect != nil &&
+		(!cmd.Params.HasPayload() || !(cmd.Params.IsSync() || cmd.Params.PollDestination)) {
+		return errors.New("expect can only be used together with a payload and a synchronous invocation or poll_destination")
+	}
+	if cmd.Params.PollDestination && (!cmd.Params.HasPayload() || !cmd.Params.IsAsync()) {
+		return errors.New("poll_destination can only be used together with a payload and an asynchronous invocation")
+	}
+	if cmd.Params.PollDestination {
+		if cmd.Params.DestinationQueue == nil {
+			return errors.New("poll_destination requires a dedicated destination_queue")
+		}
+		if _, err := parseQueueARN(*cmd.Params.DestinationQueue); err != nil {
+			return err
+		}
+	}
+	if (cmd.Params.DestinationWait != nil || cmd.Params.DestinationQueue != nil) &&
+		!cmd.Params.PollDestination {
+		return errors.New("destination_wait and destination_queue can only be used together with poll_destination")
+	}
+	if cmd.Params.FetchLogs && (!cmd.Params.HasPayload() || !cmd.Params.IsSync()) {
+		return errors.New("fetch_logs can only be used together with a payload and a synchronous invocation")
+	}
+	if cmd.Params.LogsWait != nil && !cmd.Params.FetchLogs {
+		return errors.New("logs_wait can only be used together with fetch_logs")
+	}
+	if _, err := cmd.Params.destinationWait(); err != nil {
+		return err
+	}
+	if _, err := cmd.Params.logsWait(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// timestampVersion checks if the version only has a timestamp, like the
+// versions of delete puts.
+func timestampVersion(version concourse.ResourceVersion) bool {
+	_, ok := version["timestamp"]
+	return ok && len(version) == 1
 }
 
 // HandleCommand runs the in command
 func (cmd *InCommand) HandleCommand(ctx *concourse.CommandContext) (
 	*concourse.CommandResponse, error,
 ) {
-	alias := cmd.Source.Alias
-	if cmd.Params.Alias != nil {
-		alias = cmd.Params.Alias
+	resp := &concourse.CommandResponse{
+		Version: cmd.Version,
 	}
 
-	if cmd.Params.HasPayload() {
-		api := LambdaClient(cmd.Source)
+	// Versions of puts to another region name the region
+	if region, ok := cmd.Version["region"]; ok {
+		cmd.Source.RegionName = region
+	}
 
-		result, err := InvokeFunction(
-			api, cmd.Source, alias,
-			cmd.Params.PayloadSpec,
+	if cmd.Version != nil {
+		if err := ctx.File("version", []byte(cmd.Version["version"])); err != nil {
+			return nil, errors.Wrap(err, "failed to persist version")
+		}
+	}
+
+	if cmd.Params.Skip {
+		ctx.Infof("skipping get, only the version was written")
+		return resp, nil
+	}
+
+	// The function or alias of the version of a delete put is gone
+	if timestampVersion(cmd.Version) {
+		ctx.Infof("skipping get of the version of a deletion")
+		return resp, nil
+	}
+
+	if cmd.Source.LayerName != nil {
+		err := persistLayerVersion(
+			ctx, lambdaAPI(cmd.API, cmd.Source), *cmd.Source.LayerName, cmd.Version["version"],
+		)
+		if err != nil {
+			return resp, err
+		}
+		return resp, resp.AddMetaURL("console", layerConsoleURL(
+			cmd.Source.RegionName, *cmd.Source.LayerName, cmd.Version["version"]))
+	}
+
+	if cmd.Source.IsMultiFunction() {
+		return resp, errors.Wrap(
+			ctx.JSON("functions.json", cmd.Version),
+			"failed to persist function versions",
+		)
+	}
+
+	api := lambdaAPI(cmd.API, cmd.Source)
+
+	var config *lambda.FunctionConfiguration
+	if _, ok := cmd.Version["version"]; ok {
+		c, err := persistConfiguration(
+			ctx, api, cmd.Source.FunctionName, cmd.qualifier(),
 		)
 		if err != nil {
 			return nil, err
 		}
-		if result != nil {
-			fmt.Fprintln(ctx.Log, "successfully invoked function:")
-			if _, err := ctx.Log.Write(result.Payload); err != nil {
-				return nil, errors.Wrap(err, "failed to print payload")
-			}
+		config = c
+		resp.AddMeta("code_sha256", aws.StringValue(config.CodeSha256))
+		resp.AddMeta("last_modified", aws.StringValue(config.LastModified))
+		if err := resp.AddMetaURL("console", functionConsoleURL(
+			cmd.Source.RegionName, cmd.Source.FunctionName, aws.StringValue(config.Version),
+		)); err != nil {
+			return nil, err
+		}
+	}
+
+	if tracked := cmd.trackedAlias(); tracked != nil {
+		alias, err := persistAlias(ctx, api, cmd.Source.FunctionName, *tracked)
+		if err != nil {
+			return nil, err
+		}
+		resp.AddMeta("alias_version", aws.StringValue(alias.FunctionVersion))
+	}
+
+	if cmd.Params.IncludeTags {
+		if err := persistTags(ctx, api, cmd.Source.FunctionName); err != nil {
+			return nil, err
+		}
+	}
+
+	if cmd.Params.IncludeEventSources {
+		if err := persistEventSources(
+			ctx, api, cmd.Source.FunctionName, cmd.alias(),
+		); err != nil {
+			return nil, err
+		}
+	}
 
-			return nil, errors.Wrap(
-				PersistResult(ctx, result),
-				"failed to persist invoke result",
-			)
+	if cmd.Params.IncludeFunctionURL {
+		if err := persistFunctionURL(
+			ctx, api, cmd.Source.FunctionName, cmd.alias(),
+		); err != nil {
+			return nil, err
 		}
+	}
+
+	if cmd.Params.IncludePolicy {
+		if err := persistPolicy(
+			ctx, api, cmd.Source.FunctionName, cmd.qualifier(),
+		); err != nil {
+			return nil, err
+		}
+	}
 
-		return &concourse.CommandResponse{
-			Version: concourse.ResourceVersion{
+	if cmd.Params.DownloadCode {
+		if err := persistCode(
+			ctx, api, cmd.Source.FunctionName, cmd.qualifier(), cmd.Params.Unpack,
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	if cmd.Params.HasPayload() || cmd.Params.IsDryRun() {
+		if err := cmd.invoke(ctx, api, resp); err != nil {
+			return nil, err
+		}
+
+		if resp.Version == nil {
+			resp.Version = concourse.ResourceVersion{
 				"timestamp": strconv.FormatInt(time.Now().Unix(), 10),
-			},
-		}, nil
+			}
+		}
 	}
 
-	if cmd.Version != nil {
-		if err := ctx.File("version", []byte(cmd.Version["version"])); err != nil {
-			return nil, errors.Wrap(err, "failed to persist version")
+	if aws.StringValue(cmd.Params.OutputFormat) == OutputFormatDotenv {
+		vars := dotenvVariables(cmd.Source, cmd.alias(), config, resp)
+		if err := ctx.File("lambda.env", formatDotenv(vars)); err != nil {
+			return nil, errors.Wrap(err, "failed to persist lambda.env")
 		}
 	}
 
-	return &concourse.CommandResponse{
-		Version: cmd.Version,
+	return resp, nil
+}
+
+// invoke invokes the function with the payload from the params and
+// persists the result. Batches of payloads are invoked one by one and the
+// results are written to numbered files.
+func (cmd *InCommand) invoke(
+	ctx *concourse.CommandContext, api LambdaAPI,
+	resp *concourse.CommandResponse,
+) error {
+	qualifier, err := cmd.invokeQualifier()
+	if err != nil {
+		return err
+	}
+
+	payloads, err := cmd.Params.Batch()
+	if err != nil {
+		return err
+	}
+
+	vars := cmd.payloadVariables(ctx.BuildMetadata())
+	for i := range payloads {
+		payloads[i] = payloads[i].WithVariables(vars)
+	}
+
+	if cmd.Params.IsPoll() {
+		return cmd.poll(ctx, api, qualifier, cmd.Params.PayloadSpec.WithVariables(vars), resp)
+	}
+
+	if cmd.Params.IsLoadTest() {
+		return cmd.loadTest(ctx, api, qualifier, cmd.Params.PayloadSpec.WithVariables(vars), resp)
+	}
+
+	if payloads == nil {
+		result, err := cmd.invokePayload(
+			ctx, api, qualifier, cmd.Params.PayloadSpec.WithVariables(vars), 0,
+		)
+		if err != nil || result == nil {
+			return err
+		}
+
+		meta := NewInvocationMetadata(result)
+		resp.AddMeta("request_id", meta.RequestID)
+		resp.AddMeta("status_code", strconv.FormatInt(meta.StatusCode, 10))
+		if meta.ExecutedVersion != "" {
+			resp.AddMeta("executed_version", meta.ExecutedVersion)
+		}
+		if meta.FunctionError {
+			resp.AddMeta("function_error", meta.FunctionErrorType)
+		}
+		if result.Stream != nil {
+			resp.AddMeta("stream_completed", strconv.FormatBool(result.Stream.Completed))
+		}
+		if meta.BilledDuration != nil {
+			resp.AddMeta("billed_duration", fmt.Sprintf("%d ms", *meta.BilledDuration))
+		}
+		return nil
+	}
+
+	failed := 0
+	for i, payload := range payloads {
+		ctx.Infof("invocation %d of %d", i+1, len(payloads))
+		if _, err := cmd.invokePayload(ctx, api, qualifier, payload, i+1); err != nil {
+			ctx.Warnf("invocation %d failed: %s", i+1, err.Error())
+			failed++
+		}
+	}
+
+	resp.AddMeta("invocations", strconv.Itoa(len(payloads)))
+	resp.AddMeta("failed_invocations", strconv.Itoa(failed))
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d invocations failed", failed, len(payloads))
+	}
+	return nil
+}
+
+// loadTest runs a load test and writes the stats to "loadtest.json"
+func (cmd *InCommand) loadTest(
+	ctx *concourse.CommandContext, api LambdaAPI, qualifier *string,
+	payload PayloadSpec, resp *concourse.CommandResponse,
+) error {
+	ctx.Infof("running %d invocations", *cmd.Params.Iterations)
+
+	stats := cmd.Params.LoadTest.run(func() (*InvokeResult, error) {
+		return InvokeFunction(
+			ctx, concourse.NewLogger(ioutil.Discard), api, cmd.Source, qualifier,
+			payload, cmd.Params.InvokeOptions,
+		)
+	})
+
+	if err := ctx.JSON("loadtest.json", stats); err != nil {
+		return errors.Wrap(err, "failed to persist load test stats")
+	}
+
+	resp.AddMeta("success_rate", strconv.FormatFloat(stats.SuccessRate, 'f', 3, 64))
+	if stats.P50Duration != nil {
+		resp.AddMeta("p50_duration", fmt.Sprintf("%.2f ms", *stats.P50Duration))
+		resp.AddMeta("p95_duration", fmt.Sprintf("%.2f ms", *stats.P95Duration))
+	}
+	ctx.Infof("%d of %d invocations succeeded",
+		stats.Succeeded, stats.Iterations)
+
+	return cmd.Params.LoadTest.check(stats)
+}
+
+// poll invokes the function until the result meets the poll condition or
+// the poll timeout runs out. The last result is persisted.
+func (cmd *InCommand) poll(
+	ctx *concourse.CommandContext, api LambdaAPI, qualifier *string,
+	payload PayloadSpec, resp *concourse.CommandResponse,
+) error {
+	interval, err := cmd.Params.Poll.interval()
+	if err != nil {
+		return err
+	}
+	timeout, err := cmd.Params.Poll.timeout()
+	if err != nil {
+		return err
+	}
+
+	invoke := InvokeFunction
+	if cmd.Params.IsFunctionURL() {
+		invoke = InvokeFunctionURL
+	}
+
+	deadline := time.Now().Add(timeout)
+	for attempt := 1; ; attempt++ {
+		result, err := invoke(
+			ctx, ctx.Logger, api, cmd.Source, qualifier,
+			payload, cmd.Params.InvokeOptions,
+		)
+		if err == nil {
+			err = cmd.Params.PollUntil.Check(result)
+		}
+
+		done := err == nil || !time.Now().Add(interval).Before(deadline)
+		if done && result != nil {
+			if err := persistResult(ctx, result, 0); err != nil {
+				return errors.Wrap(err, "failed to persist invoke result")
+			}
+			resp.AddMeta("request_id", result.RequestID)
+		}
+		if err == nil {
+			ctx.Infof("the condition was met after %d invocations", attempt)
+			resp.AddMeta("poll_attempts", strconv.Itoa(attempt))
+			return nil
+		}
+		if done {
+			return errors.Wrapf(err,
+				"the condition wasn't met within %s (%d invocations)", timeout, attempt)
+		}
+
+		ctx.Infof("invocation %d: %s, retrying in %s",
+			attempt, err.Error(), interval)
+		if err := sleepContext(ctx, interval); err != nil {
+			return errors.Wrap(err, "polling was interrupted")
+		}
+	}
+}
+
+// payloadVariables returns the variables that can be used as placeholders
+// in payloads.
+func (cmd *InCommand) payloadVariables(meta concourse.BuildMetadata) map[string]string {
+	vars := buildVariables(meta)
+	vars["FUNCTION_NAME"] = cmd.Source.FunctionName
+	if v, ok := cmd.Version["version"]; ok {
+		vars["VERSION"] = v
+	}
+	if cmd.Params.Version != nil {
+		vars["VERSION"] = *cmd.Params.Version
+	}
+	if alias := cmd.alias(); alias != nil {
+		vars["ALIAS"] = *alias
+	}
+	return vars
+}
+
+// invokePayload invokes the function with a single payload and persists
+// the result. A non-zero index is used to number the result files.
+func (cmd *InCommand) invokePayload(
+	ctx *concourse.CommandContext, api LambdaAPI, qualifier *string,
+	payload PayloadSpec, index int,
+) (*InvokeResult, error) {
+	invokeFunction := InvokeFunction
+	switch {
+	case cmd.Params.IsFunctionURL():
+		invokeFunction = InvokeFunctionURL
+	case cmd.Params.IsResponseStream():
+		invokeFunction = InvokeFunctionStream
+	}
+
+	invoked := time.Now()
+	result, err := invokeFunction(
+		ctx, ctx.Logger, api, cmd.Source, qualifier,
+		payload, cmd.Params.InvokeOptions,
+	)
+	if result != nil {
+		if log, err := TailLog(result); err == nil && len(log) > 0 {
+			ctx.Infof("execution log:")
+			_, _ = ctx.Log.Write(log)
+		}
+
+		// The metadata is persisted for failed invocations as well.
+		if err := ctx.JSON(
+			numberedName("metadata.json", index), NewInvocationMetadata(result),
+		); err != nil {
+			return result, errors.Wrap(err, "failed to persist invocation metadata")
+		}
+	}
+	if err != nil && cmd.Params.allowsFunctionError(result) {
+		ctx.Warnf("the function returned an error that is allowed: %s",
+			err.Error())
+
+		// Errors that fail the get are written by the errorFile middleware
+		if err := ctx.JSON(
+			numberedName(ErrorFileName, index), newCommandError(cmd, classifyError(ctx, err)),
+		); err != nil {
+			return result, errors.Wrap(err, "failed to persist function error")
+		}
+		err = nil
+	}
+	if err != nil {
+		return result, err
+	}
+	if result == nil {
+		return nil, nil
+	}
+
+	if payload.IsBinary() && result.FunctionError == nil {
+		data, err := payload.decodeResponse(result.Payload)
+		if err != nil {
+			return result, err
+		}
+		result.Payload = data
+		result.binary = true
+	}
+
+	switch {
+	case result.FunctionError != nil:
+		ctx.Infof("function error payload:")
+		if _, err := ctx.Log.Write(result.Payload); err != nil {
+			return result, errors.Wrap(err, "failed to print payload")
+		}
+	case cmd.Params.IsAsync():
+		ctx.Infof("successfully queued the invocation, request id: %s",
+			result.RequestID)
+	case cmd.Params.IsDryRun():
+		ctx.Infof("successfully verified that the function can be invoked")
+	case result.binary:
+		ctx.Infof("successfully invoked function, the response is %d bytes",
+			len(result.Payload))
+	default:
+		ctx.Infof("successfully invoked function:")
+		if _, err := ctx.Log.Write(result.Payload); err != nil {
+			return result, errors.Wrap(err, "failed to print payload")
+		}
+	}
+
+	if err := persistResult(ctx, result, index); err != nil {
+		return result, errors.Wrap(err, "failed to persist invoke result")
+	}
+
+	if cmd.Params.FetchLogs {
+		if err := cmd.persistLogs(ctx, api, qualifier, result, invoked, index); err != nil {
+			return result, err
+		}
+	}
+
+	outcome := result
+	if cmd.Params.PollDestination {
+		outcome, err = cmd.persistDestination(ctx, api, qualifier, result, index)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if cmd.Params.Expect != nil {
+		if err := cmd.Params.Expect.Check(outcome); err != nil {
+			return result, err
+		}
+		ctx.Infof("the invocation result met the expectations")
+	}
+
+	return result, nil
+}
+
+// persistDestination waits for the destination record of an asynchronous
+// invocation and writes it to "destination.json". Returns the outcome of
+// the invocation with the response payload from the record.
+func (cmd *InCommand) persistDestination(
+	ctx *concourse.CommandContext, api LambdaAPI, qualifier *string,
+	result *InvokeResult, index int,
+) (*InvokeResult, error) {
+	wait, err := cmd.Params.destinationWait()
+	if err != nil {
+		return nil, err
+	}
+
+	record, data, err := pollDestination(
+		ctx, ctx.Logger, api, cmd.Source, qualifier,
+		*cmd.Params.DestinationQueue, result.RequestID, wait,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ctx.File(numberedName("destination.json", index), data); err != nil {
+		return nil, errors.Wrap(err, "failed to persist destination record")
+	}
+
+	if !record.Succeeded() {
+		return nil, fmt.Errorf(
+			"the asynchronous invocation failed after %d attempts: %s",
+			record.RequestContext.ApproximateInvokeCount, string(record.ResponsePayload))
+	}
+	ctx.Infof("the asynchronous invocation succeeded:")
+	_, _ = ctx.Log.Write(record.ResponsePayload)
+
+	return &InvokeResult{
+		InvokeOutput: &lambda.InvokeOutput{
+			StatusCode: result.StatusCode,
+			Payload:    record.ResponsePayload,
+		},
+		RequestID: result.RequestID,
 	}, nil
 }
+
+// persistLogs fetches the CloudWatch Logs events of the invocation and
+// writes them to "logs.txt".
+func (cmd *InCommand) persistLogs(
+	ctx *concourse.CommandContext, api LambdaAPI, qualifier *string,
+	result *InvokeResult, invoked time.Time, index int,
+) error {
+	wait, err := cmd.Params.logsWait()
+	if err != nil {
+		return err
+	}
+
+	config, err := api.GetFunctionConfigurationWithContext(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: &cmd.Source.FunctionName,
+		Qualifier:    qualifier,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to get function configuration")
+	}
+
+	logs, err := fetchInvocationLogs(
+		ctx, ctx.Logger, LogsClient(cmd.Source), functionLogGroup(config),
+		result.RequestID, invoked, wait,
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch invocation logs")
+	}
+
+	return errors.Wrap(
+		ctx.File(numberedName("logs.txt", index), logs),
+		"failed to persist logs",
+	)
+}
+
+// persistConfiguration writes the function configuration to
+// "function.json", and the ARN, runtime, and code sha256 to separate files.
+// Returns the configuration.
+func persistConfiguration(
+	ctx *concourse.CommandContext, api LambdaAPI,
+	functionName string, qualifier *string,
+) (*lambda.FunctionConfiguration, error) {
+	config, err := api.GetFunctionConfigurationWithContext(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: &functionName,
+		Qualifier:    qualifier,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get function configuration")
+	}
+
+	if err := ctx.JSON("function.json", config); err != nil {
+		return nil, errors.Wrap(err, "failed to persist function configuration")
+	}
+
+	files := map[string]*string{
+		"arn":         config.FunctionArn,
+		"runtime":     config.Runtime,
+		"code-sha256": config.CodeSha256,
+	}
+	for name, value := range files {
+		if err := ctx.File(name, []byte(aws.StringValue(value))); err != nil {
+			return nil, errors.Wrapf(err, "failed to persist %s", name)
+		}
+	}
+
+	return config, nil
+}
+
+// persistEventSources writes the event source mappings of the function to
+// "event-sources.json". Mappings of the alias, if any, are included.
+func persistEventSources(
+	ctx *concourse.CommandContext, api LambdaAPI,
+	functionName string, alias *string,
+) error {
+	names := []string{functionName}
+	if alias != nil {
+		names = append(names, functionName+":"+*alias)
+	}
+
+	mappings := []*lambda.EventSourceMappingConfiguration{}
+	for _, name := range names {
+		err := api.ListEventSourceMappingsPagesWithContext(ctx, &lambda.ListEventSourceMappingsInput{
+			FunctionName: aws.String(name),
+		}, func(page *lambda.ListEventSourceMappingsOutput, _ bool) bool {
+			mappings = append(mappings, page.EventSourceMappings...)
+			return true
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to list event source mappings of %q", name)
+		}
+	}
+
+	return errors.Wrap(
+		ctx.JSON("event-sources.json", mappings),
+		"failed to persist event source mappings",
+	)
+}
+
+// persistPolicy writes the resource policy of the function to
+// "policy.json". An empty policy is written if the function has none.
+func persistPolicy(
+	ctx *concourse.CommandContext, api LambdaAPI,
+	functionName string, qualifier *string,
+) error {
+	policy := "{}"
+
+	out, err := api.GetPolicyWithContext(ctx, &lambda.GetPolicyInput{
+		FunctionName: &functionName,
+		Qualifier:    qualifier,
+	})
+	switch {
+	case isNotFound(err):
+		ctx.Infof("the function has no resource policy")
+	case err != nil:
+		return errors.Wrap(err, "failed to get the resource policy")
+	default:
+		policy = aws.StringValue(out.Policy)
+	}
+
+	return errors.Wrap(
+		ctx.File("policy.json", []byte(policy)),
+		"failed to persist resource policy",
+	)
+}
+
+// persistFunctionURL writes the function URL configuration of the function,
+// or of the alias if any, to "function-url.json" and the URL to
+// "function-url".
+func persistFunctionURL(
+	ctx *concourse.CommandContext, api LambdaAPI,
+	functionName string, alias *string,
+) error {
+	config, err := api.GetFunctionUrlConfigWithContext(ctx, &lambda.GetFunctionUrlConfigInput{
+		FunctionName: &functionName,
+		Qualifier:    alias,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to get the function URL configuration")
+	}
+
+	if err := ctx.JSON("function-url.json", config); err != nil {
+		return errors.Wrap(err, "failed to persist function URL configuration")
+	}
+	return errors.Wrap(
+		ctx.File("function-url", []byte(aws.StringValue(config.FunctionUrl))),
+		"failed to persist function URL",
+	)
+}
+
+// persistLayerVersion writes the layer version to "layer.json" and its
+// ARN to "layer-arn".
+func persistLayerVersion(
+	ctx *concourse.CommandContext, api LambdaAPI, layerName, version string,
+) error {
+	number, err := strconv.ParseInt(version, 10, 64)
+	if err != nil {
+		return errors.Wrapf(err, "invalid layer version %q", version)
+	}
+
+	layer, err := api.GetLayerVersionWithContext(ctx, &lambda.GetLayerVersionInput{
+		LayerName:     &layerName,
+		VersionNumber: &number,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to get layer version")
+	}
+
+	if err := ctx.JSON("layer.json", layer); err != nil {
+		return errors.Wrap(err, "failed to persist layer version")
+	}
+	return errors.Wrap(
+		ctx.File("layer-arn", []byte(aws.StringValue(layer.LayerVersionArn))),
+		"failed to persist layer ARN",
+	)
+}
@@ -0,0 +1,38 @@
+package resource
+
+import (
+	"regexp"
+
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+)
+
+var placeholderPattern = regexp.MustCompile(`\$\{([A-Za-z0-9_]+)\}`)
+
+// buildVariables returns the build metadata placeholder variables, named
+// after the Concourse environment variables.
+func buildVariables(meta concourse.BuildMetadata) map[string]string {
+	return meta.Env()
+}
+
+// interpolate replaces ${NAME} placeholders with the value of the
+// variables. Unknown placeholders are left as they are.
+func interpolate(s string, vars map[string]string) string {
+	return placeholderPattern.ReplaceAllStringFunc(s, func(placeholder string) string {
+		name := placeholderPattern.FindStringSubmatch(placeholder)[1]
+		if value, ok := vars[name]; ok {
+			return value
+		}
+		return placeholder
+	})
+}
+
+// hasPlaceholder checks if the string contains a placeholder for the
+// variable.
+func hasPlaceholder(s string, name string) bool {
+	for _, match := range placeholderPattern.FindAllStringSubmatch(s, -1) {
+		if match[1] == name {
+			return true
+		}
+	}
+	return false
+}
@@ -1,15 +1,29 @@
 package resource
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/Sydsvenskan/concourse"
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/pkg/errors"
 )
 
@@ -30,13 +44,122 @@ type Source struct {
 	KeyID string `json:"access_key_id"`
 	// AccessKey is the AWS access key
 	AccessKey string `json:"secret_access_key"`
+	// SessionToken is the AWS session token of temporary credentials
+	SessionToken string `json:"session_token"`
 	// RegionName is the AWS region that your lambda function is in
 	RegionName string `json:"region_name"`
+	// Endpoint overrides the endpoint of the Lambda API, f.ex. to use a
+	// local fake.
+	Endpoint *string `json:"endpoint"`
 	// FunctionName is the name of your Lambda function
 	FunctionName string `json:"function_name"`
+	// FunctionNames is a list of functions that check tracks together
+	FunctionNames []string `json:"function_names"`
+	// FunctionPrefix makes check track all functions with names that
+	// start with the prefix.
+	FunctionPrefix *string `json:"function_prefix"`
+	// LayerName makes the resource track the versions of a layer instead
+	// of a function.
+	LayerName *string `json:"layer_name"`
 	// Alias can be used with in and check to track changes to a specific alias
 	// of a function.
 	Alias *string `json:"alias"`
+	// Aliases can be used with check to track changes to any of multiple
+	// aliases of a function.
+	Aliases []string `json:"aliases"`
+	// VersionDescriptionFilter is a regular expression that the
+	// description of versions must match to be emitted by check.
+	VersionDescriptionFilter *string `json:"version_description_filter"`
+	// TraceTagFilter makes check only emit versions published by a put
+	// with trace tags that match the filter.
+	TraceTagFilter map[string]string `json:"trace_tag_filter"`
+	// ActiveOnly makes check skip versions that aren't active
+	ActiveOnly bool `json:"active_only"`
+	// SkipMissing makes check return no versions instead of failing when
+	// the function doesn't exist.
+	SkipMissing bool `json:"skip_missing"`
+	// InitialVersion is what check returns on the first check: "latest"
+	// (the default), "all", or a version number to return the versions
+	// after it.
+	InitialVersion *string `json:"initial_version"`
+	// MaxVersions is the maximum number of versions that check returns
+	MaxVersions *int `json:"max_versions"`
+	// FastCheck makes check look up the version after the incoming version
+	// before listing all versions.
+	FastCheck bool `json:"fast_check"`
+	// IncludeLatest makes check emit a pseudo-version when the code of
+	// $LATEST changes.
+	IncludeLatest bool `json:"include_latest"`
+	// Track is what check tracks: "versions" (the default), "code_sha",
+	// "last_modified", or "config".
+	Track *string `json:"track"`
+	// CommandTimeout is how long a check, get, or put may run before it's
+	// cancelled, f.ex. "10m".
+	CommandTimeout *string `json:"command_timeout"`
+	// Debug enables debug logging, including the request parameters
+	Debug bool `json:"debug"`
+	// DebugAWS logs the requests to and responses from AWS, with the
+	// credentials redacted.
+	DebugAWS bool `json:"debug_aws"`
+	// MetricsEndpoint is where the command metrics are sent, either a
+	// StatsD server ("statsd://host:8125") or an OpenTelemetry collector
+	// ("http://host:4318").
+	MetricsEndpoint *string `json:"metrics_endpoint"`
+	// LogFormat is the format of the build log, "text" (the default) or
+	// "json".
+	LogFormat *string `json:"log_format"`
+}
+
+// commandTimeout returns the command timeout, zero means none
+func (s *Source) commandTimeout() (time.Duration, error) {
+	return parseDurationParam("command_timeout", s.CommandTimeout, 0)
+}
+
+// logFormat returns the log format, text by default
+func (s *Source) logFormat() (concourse.Format, error) {
+	return concourse.ParseFormat(aws.StringValue(s.LogFormat))
+}
+
+// debugRequest enables debug logging if the source asks for it, and logs
+// the request with the credentials redacted.
+func debugRequest(ctx *concourse.CommandContext, source Source, request interface{}) {
+	if !source.Debug {
+		return
+	}
+	ctx.SetLevel(concourse.LevelDebug)
+
+	data, err := json.Marshal(request)
+	if err != nil {
+		ctx.Debugf("failed to marshal the request: %s", err.Error())
+		return
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		ctx.Debugf("failed to unmarshal the request: %s", err.Error())
+		return
+	}
+	if s, ok := fields["source"].(map[string]interface{}); ok {
+		for _, key := range []string{"access_key_id", "secret_access_key", "session_token"} {
+			if s[key] != "" {
+				s[key] = concourse.RedactedValue
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(fields); err != nil {
+		ctx.Debugf("failed to marshal the request: %s", err.Error())
+		return
+	}
+	ctx.Debugf("request: %s", buf.Bytes())
+}
+
+// IsMultiFunction checks if the resource tracks multiple functions
+func (s *Source) IsMultiFunction() bool {
+	return s.FunctionNames != nil || s.FunctionPrefix != nil
 }
 
 // PayloadSpec specifies a payload that should be used to invoke the
@@ -47,16 +170,258 @@ type PayloadSpec struct {
 	Payload interface{} `json:"payload"`
 	// PayloadFile is used to load the payload from an input file.
 	PayloadFile *string `json:"payload_file"`
+	// Payloads is a list of payloads, the function is invoked once per
+	// payload.
+	Payloads []interface{} `json:"payloads"`
+	// PayloadDir is a directory of JSON payload files, the function is
+	// invoked once per file.
+	PayloadDir *string `json:"payload_dir"`
+	// PayloadEncoding is "binary" to send the payload file verbatim, or
+	// "base64" to send it as a base64 encoded JSON string. Responses are
+	// persisted verbatim, or base64 decoded.
+	PayloadEncoding *string `json:"payload_encoding"`
+
+	// variables are substituted for ${NAME} placeholders in the payload
+	variables map[string]string
+}
+
+// Payload encodings
+const (
+	PayloadEncodingJSON   = "json"
+	PayloadEncodingBinary = "binary"
+	PayloadEncodingBase64 = "base64"
+)
+
+// IsBinary checks if the payload and response are binary data
+func (spec *PayloadSpec) IsBinary() bool {
+	switch aws.StringValue(spec.PayloadEncoding) {
+	case PayloadEncodingBinary, PayloadEncodingBase64:
+		return true
+	}
+	return false
+}
+
+// validatePayload checks that the payload params can be used together
+func (spec *PayloadSpec) validatePayload() error {
+	switch aws.StringValue(spec.PayloadEncoding) {
+	case "", PayloadEncodingJSON:
+		return nil
+	case PayloadEncodingBinary, PayloadEncodingBase64:
+		if (spec.PayloadFile == nil && spec.PayloadDir == nil) ||
+			spec.Payload != nil || spec.Payloads != nil {
+			return errors.New("a binary payload_encoding can only be used together with payload_file or payload_dir")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported payload_encoding %q", *spec.PayloadEncoding)
+}
+
+// decodeResponse decodes a base64 encoded JSON string response payload
+func (spec *PayloadSpec) decodeResponse(payload []byte) ([]byte, error) {
+	if aws.StringValue(spec.PayloadEncoding) != PayloadEncodingBase64 {
+		return payload, nil
+	}
+
+	var encoded string
+	if err := json.Unmarshal(payload, &encoded); err != nil {
+		return nil, errors.Wrap(err, "the response isn't a JSON string")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	return decoded, errors.Wrap(err, "failed to decode the base64 response")
+}
+
+// WithVariables returns a copy of the spec where ${NAME} placeholders in
+// the payload are replaced with the variables.
+func (spec PayloadSpec) WithVariables(vars map[string]string) PayloadSpec {
+	spec.variables = vars
+	return spec
+}
+
+// InvokeOptions controls how a function is invoked
+type InvokeOptions struct {
+	// InvocationType is "RequestResponse" (the default) for synchronous
+	// invocations, "Event" for asynchronous invocations, or "DryRun" to
+	// verify that the function can be invoked.
+	InvocationType *string `json:"invocation_type"`
+	// ClientContext is client context data that is passed to the function
+	ClientContext interface{} `json:"client_context"`
+	// InvokeTimeout is the maximum time to wait for the invocation, f.ex.
+	// "5m".
+	InvokeTimeout *string `json:"invoke_timeout"`
+	// InvokeAttempts is the maximum number of attempts when the invocation
+	// is throttled or fails because of a transient error.
+	InvokeAttempts *int `json:"invoke_attempts"`
+	// InvokeMode is "response_stream" to invoke the function with a
+	// streamed response.
+	InvokeMode *string `json:"invoke_mode"`
+	// Via is "function_url" to invoke the function through its function
+	// URL instead of the Invoke API.
+	Via *string `json:"via"`
+	// ContentType is the Content-Type header of requests to the function
+	// URL, it defaults to the type of the payload.
+	ContentType *string `json:"content_type"`
+}
+
+// IsFunctionURL checks if the function is invoked through its function
+// URL.
+func (opts *InvokeOptions) IsFunctionURL() bool {
+	return aws.StringValue(opts.Via) == ViaFunctionURL
+}
+
+// IsResponseStream checks if the function is invoked with a streamed
+// response.
+func (opts *InvokeOptions) IsResponseStream() bool {
+	return aws.StringValue(opts.InvokeMode) == InvokeModeResponseStream
+}
+
+// validate checks that the options can be used together
+func (opts *InvokeOptions) validate() error {
+	if opts.InvokeMode != nil && !opts.IsResponseStream() {
+		return fmt.Errorf("unsupported invoke_mode %q", *opts.InvokeMode)
+	}
+	if opts.IsResponseStream() && (!opts.IsSync() || opts.IsFunctionURL()) {
+		return errors.New("invoke_mode response_stream can only be used together with synchronous invocations through the Invoke API")
+	}
+	if opts.Via != nil && !opts.IsFunctionURL() {
+		return fmt.Errorf("unsupported via %q", *opts.Via)
+	}
+	if opts.IsFunctionURL() && (opts.InvocationType != nil || opts.ClientContext != nil) {
+		return errors.New("invocation_type and client_context can't be used together with a function URL")
+	}
+	if opts.ContentType != nil && !opts.IsFunctionURL() {
+		return errors.New("content_type can only be used together with a function URL")
+	}
+	if _, err := opts.timeout(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// defaultInvokeAttempts is the default maximum number of invoke attempts
+const defaultInvokeAttempts = 4
+
+// retryPolicy returns the retry policy for invocations
+func (opts *InvokeOptions) retryPolicy() (retryPolicy, error) {
+	policy := invokeRetry
+	policy.Attempts = defaultInvokeAttempts
+
+	if opts.InvokeAttempts != nil {
+		if *opts.InvokeAttempts < 1 {
+			return policy, errors.New("invoke_attempts must be at least 1")
+		}
+		policy.Attempts = *opts.InvokeAttempts
+	}
+
+	return policy, nil
+}
+
+// timeout returns the invoke timeout, zero means no timeout
+func (opts *InvokeOptions) timeout() (time.Duration, error) {
+	return parseDurationParam("invoke_timeout", opts.InvokeTimeout, 0)
+}
+
+// maxClientContextSize is the maximum size of the encoded client context
+const maxClientContextSize = 3583
+
+// clientContext returns the base64 encoded client context, if any
+func (opts *InvokeOptions) clientContext() (*string, error) {
+	if opts.ClientContext == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(opts.ClientContext)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal client context")
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(data)
+	if len(encoded) > maxClientContextSize {
+		return nil, fmt.Errorf(
+			"the encoded client context is %d bytes, the limit is %d bytes",
+			len(encoded), maxClientContextSize)
+	}
+
+	return &encoded, nil
+}
+
+// IsAsync checks if the function is invoked asynchronously
+func (opts *InvokeOptions) IsAsync() bool {
+	return aws.StringValue(opts.InvocationType) == lambda.InvocationTypeEvent
+}
+
+// IsDryRun checks if the invocation only verifies that the function can
+// be invoked.
+func (opts *InvokeOptions) IsDryRun() bool {
+	return aws.StringValue(opts.InvocationType) == lambda.InvocationTypeDryRun
+}
+
+// IsSync checks if the function is invoked synchronously
+func (opts *InvokeOptions) IsSync() bool {
+	return !opts.IsAsync() && !opts.IsDryRun()
+}
+
+// InvokeResult is the result of a function invocation
+type InvokeResult struct {
+	*lambda.InvokeOutput
+	// RequestID is the ID of the invoke request
+	RequestID string
+	// Headers are the response headers of function URL invocations
+	Headers http.Header `json:",omitempty"`
+	// Stream is the completion status of streamed responses
+	Stream *StreamStatus `json:",omitempty"`
+
+	// binary is set if the payload is binary data
+	binary bool
 }
 
 // LambdaClient creates a lambda client from the source config
 func LambdaClient(s Source) *lambda.Lambda {
-	return lambda.New(session.New(&aws.Config{
+	c := lambda.New(awsSession(s), &aws.Config{Endpoint: s.Endpoint})
+	if s.DebugAWS {
+		debugAWSLambdaClient(c.Client)
+	}
+	return c
+}
+
+// LogsClient creates a CloudWatch Logs client from the source config
+func LogsClient(s Source) *cloudwatchlogs.CloudWatchLogs {
+	c := cloudwatchlogs.New(awsSession(s))
+	if s.DebugAWS {
+		debugAWSClient(c.Client)
+	}
+	return c
+}
+
+// CloudFormationClient creates a CloudFormation client from the source config
+func CloudFormationClient(s Source) *cloudformation.CloudFormation {
+	c := cloudformation.New(awsSession(s))
+	if s.DebugAWS {
+		debugAWSClient(c.Client)
+	}
+	return c
+}
+
+// S3Client creates an S3 client from the source config
+func S3Client(s Source) *s3.S3 {
+	c := s3.New(awsSession(s))
+	if s.DebugAWS {
+		debugAWSClient(c.Client)
+	}
+	return c
+}
+
+func awsSession(s Source) *session.Session {
+	sess := session.New(&aws.Config{
 		Region: &s.RegionName,
 		Credentials: credentials.NewStaticCredentials(
-			s.KeyID, s.AccessKey, "",
+			s.KeyID, s.AccessKey, s.SessionToken,
 		),
-	}))
+	})
+	sess.Handlers.Complete.PushBack(recordRequestMetrics)
+	if s.DebugAWS {
+		debugAWSConfig(sess.Config, &sess.Handlers)
+	}
+	return sess
 }
 
 // FunctionError returned by Lambda when something goes wrong during invocation
@@ -79,10 +444,13 @@ func (fe *FunctionError) LambdaError() *FunctionError {
 	return fe
 }
 
-// InvokeFunction invokes a lambda function
+// InvokeFunction invokes a lambda function, the qualifier is an optional
+// version or alias. Throttled invocations and transient errors are retried.
 func InvokeFunction(
-	api *lambda.Lambda, source Source, alias *string, payload PayloadSpec,
-) (*lambda.InvokeOutput, error) {
+	ctx context.Context, log *concourse.Logger, api LambdaAPI,
+	source Source, qualifier *string,
+	payload PayloadSpec, opts InvokeOptions,
+) (*InvokeResult, error) {
 	name := source.FunctionName
 
 	data, err := payloadData(payload)
@@ -90,22 +458,75 @@ func InvokeFunction(
 		return nil, errors.Wrap(err, "failed to get payload data")
 	}
 
-	if len(data) == 0 {
+	if len(data) == 0 && !opts.IsDryRun() {
 		return nil, nil
 	}
 
-	if alias != nil {
-		name += ":" + *alias
+	if qualifier != nil {
+		name += ":" + *qualifier
+	}
+
+	clientContext, err := opts.clientContext()
+	if err != nil {
+		return nil, err
+	}
+
+	input := lambda.InvokeInput{
+		FunctionName:   &name,
+		Payload:        data,
+		InvocationType: opts.InvocationType,
+		ClientContext:  clientContext,
+	}
+	if opts.IsSync() {
+		input.LogType = aws.String(lambda.LogTypeTail)
+	}
+
+	timeout, err := opts.timeout()
+	if err != nil {
+		return nil, err
 	}
 
-	result, err := api.Invoke(&lambda.InvokeInput{
-		FunctionName: &name,
-		Payload:      data,
+	retry, err := opts.retryPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	invokeCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		invokeCtx, cancel = context.WithTimeout(invokeCtx, timeout)
+		defer cancel()
+	}
+
+	var (
+		req    *request.Request
+		output *lambda.InvokeOutput
+	)
+	err = retry.Do(ctx, log, "invocation", func() error {
+		req, output = api.InvokeRequest(&input)
+		req.SetContext(invokeCtx)
+		// The attempts are counted by the retry policy alone
+		req.Retryer = client.NoOpRetryer{}
+		return req.Send()
 	})
 	if err != nil {
+		if invokeCtx.Err() == context.DeadlineExceeded {
+			requestID := req.RequestID
+			if requestID == "" {
+				requestID = "unknown"
+			}
+			return nil, fmt.Errorf(
+				"the invocation timed out after %s (request id: %s), the function might still be running",
+				timeout, requestID)
+		}
 		return nil, errors.Wrap(err, "failed to invoke function")
 	}
 
+	result := &InvokeResult{
+		InvokeOutput: output,
+		RequestID:    req.RequestID,
+	}
+
 	if result.FunctionError != nil {
 		var functionError FunctionError
 		if err := json.Unmarshal(result.Payload, &functionError); err != nil {
@@ -119,38 +540,240 @@ func InvokeFunction(
 	return result, nil
 }
 
-// PersistResult writes our a "result.json" and "result.payload.json" to the
-// context output directory.
+// PersistResult writes our a "result.json", "result.payload.json" (or
+// "result.payload" for streamed and binary responses), "request-id", and "result.log"
+// to the context output directory.
 func PersistResult(
-	ctx *concourse.CommandContext, result *lambda.InvokeOutput,
+	ctx *concourse.CommandContext, result *InvokeResult,
+) error {
+	return persistResult(ctx, result, 0)
+}
+
+// persistResult writes out the result files, a non-zero index is added to
+// the file names, f.ex. "result.1.json".
+func persistResult(
+	ctx *concourse.CommandContext, result *InvokeResult, index int,
 ) error {
-	if err := ctx.JSON("result.json", result); err != nil {
+	if err := ctx.JSON(numberedName("result.json", index), result); err != nil {
 		return errors.Wrap(err,
 			"failed to persist invocation result")
 	}
-	if err := ctx.File("result.payload.json", result.Payload); err != nil {
+	payloadName := "result.payload.json"
+	if result.Stream != nil || result.binary {
+		// Streamed and binary responses are usually not JSON.
+		payloadName = "result.payload"
+	}
+	if err := ctx.File(numberedName(payloadName, index), result.Payload); err != nil {
 		return errors.Wrap(err, "failed to persist result payload")
 	}
+	if err := ctx.File(numberedName("request-id", index), []byte(result.RequestID)); err != nil {
+		return errors.Wrap(err, "failed to persist request id")
+	}
+	if result.LogResult != nil {
+		log, err := TailLog(result)
+		if err != nil {
+			return err
+		}
+		if err := ctx.File(numberedName("result.log", index), log); err != nil {
+			return errors.Wrap(err, "failed to persist result log")
+		}
+	}
 	return nil
 }
 
+// numberedName adds the index after the first part of a file name,
+// "result.json" becomes "result.1.json". Zero leaves the name unchanged.
+func numberedName(name string, index int) string {
+	if index == 0 {
+		return name
+	}
+	suffix := "." + strconv.Itoa(index)
+	if i := strings.Index(name, "."); i != -1 {
+		return name[:i] + suffix + name[i:]
+	}
+	return name + suffix
+}
+
+// InvocationMetadata summarises the execution of an invocation
+type InvocationMetadata struct {
+	// RequestID is the ID of the invoke request
+	RequestID string `json:"request_id"`
+	// StatusCode is the status code of the invocation
+	StatusCode int64 `json:"status_code"`
+	// ExecutedVersion is the version of the function that was executed
+	ExecutedVersion string `json:"executed_version,omitempty"`
+	// FunctionError is set if the function returned an error
+	FunctionError bool `json:"function_error"`
+	// FunctionErrorType is the type of the function error
+	FunctionErrorType string `json:"function_error_type,omitempty"`
+	// PayloadSize is the size of the response payload in bytes
+	PayloadSize int `json:"payload_size"`
+	// Duration is the execution duration in milliseconds
+	Duration *float64 `json:"duration_ms,omitempty"`
+	// BilledDuration is the billed duration in milliseconds
+	BilledDuration *int64 `json:"billed_duration_ms,omitempty"`
+}
+
+var (
+	durationPattern       = regexp.MustCompile(`\tDuration: ([0-9.]+) ms`)
+	billedDurationPattern = regexp.MustCompile(`Billed Duration: ([0-9]+) ms`)
+)
+
+// NewInvocationMetadata creates the metadata of an invocation. The
+// durations are read from the report line of the execution log, if any.
+func NewInvocationMetadata(result *InvokeResult) InvocationMetadata {
+	meta := InvocationMetadata{
+		RequestID:         result.RequestID,
+		StatusCode:        aws.Int64Value(result.StatusCode),
+		ExecutedVersion:   aws.StringValue(result.ExecutedVersion),
+		FunctionError:     result.FunctionError != nil,
+		FunctionErrorType: aws.StringValue(result.FunctionError),
+		PayloadSize:       len(result.Payload),
+	}
+
+	log, err := TailLog(result)
+	if err != nil {
+		return meta
+	}
+	if m := durationPattern.FindSubmatch(log); m != nil {
+		if d, err := strconv.ParseFloat(string(m[1]), 64); err == nil {
+			meta.Duration = &d
+		}
+	}
+	if m := billedDurationPattern.FindSubmatch(log); m != nil {
+		if d, err := strconv.ParseInt(string(m[1]), 10, 64); err == nil {
+			meta.BilledDuration = &d
+		}
+	}
+
+	return meta
+}
+
+// TailLog decodes the execution log tail of an invocation
+func TailLog(result *InvokeResult) ([]byte, error) {
+	if result.LogResult == nil {
+		return nil, nil
+	}
+	log, err := base64.StdEncoding.DecodeString(*result.LogResult)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode the execution log")
+	}
+	return log, nil
+}
+
 // HasPayload checks if the
 func (spec *PayloadSpec) HasPayload() bool {
-	return spec.Payload != nil || spec.PayloadFile != nil
+	return spec.Payload != nil || spec.PayloadFile != nil ||
+		spec.Payloads != nil || spec.PayloadDir != nil
+}
+
+// Batch returns the individual payloads of a batch, or nil if the spec
+// isn't a batch.
+func (spec *PayloadSpec) Batch() ([]PayloadSpec, error) {
+	if spec.Payloads == nil && spec.PayloadDir == nil {
+		return nil, nil
+	}
+
+	sources := 0
+	for _, set := range []bool{
+		spec.Payload != nil, spec.PayloadFile != nil,
+		spec.Payloads != nil, spec.PayloadDir != nil,
+	} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return nil, errors.New(
+			"only one of payload, payload_file, payloads, and payload_dir can be used")
+	}
+
+	var batch []PayloadSpec
+	for _, payload := range spec.Payloads {
+		batch = append(batch, PayloadSpec{Payload: payload})
+	}
+
+	if spec.PayloadDir != nil {
+		files, err := ioutil.ReadDir(*spec.PayloadDir)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read payload directory")
+		}
+		for _, file := range files {
+			if !file.Mode().IsRegular() {
+				continue
+			}
+			if !spec.IsBinary() && filepath.Ext(file.Name()) != ".json" {
+				continue
+			}
+			name := filepath.Join(*spec.PayloadDir, file.Name())
+			batch = append(batch, PayloadSpec{
+				PayloadFile:     &name,
+				PayloadEncoding: spec.PayloadEncoding,
+			})
+		}
+	}
+
+	if len(batch) == 0 {
+		return nil, errors.New("there are no payloads to invoke the function with")
+	}
+
+	return batch, nil
 }
 
 func payloadData(spec PayloadSpec) ([]byte, error) {
 	if spec.Payload != nil {
-		return json.Marshal(spec.Payload)
+		return json.Marshal(interpolateValue(spec.Payload, spec.variables))
 	}
 
 	if spec.PayloadFile != nil {
 		data, err := ioutil.ReadFile(*spec.PayloadFile)
 		if err != nil {
-			err = errors.Wrap(err, "failed to read payload file")
+			return nil, errors.Wrap(err, "failed to read payload file")
 		}
-		return data, err
+		switch aws.StringValue(spec.PayloadEncoding) {
+		case PayloadEncodingBinary:
+			return data, nil
+		case PayloadEncodingBase64:
+			return json.Marshal(base64.StdEncoding.EncodeToString(data))
+		}
+		if len(spec.variables) == 0 {
+			return data, nil
+		}
+
+		// The values are escaped so that they can be used in JSON strings.
+		escaped := make(map[string]string, len(spec.variables))
+		for name, value := range spec.variables {
+			quoted, _ := json.Marshal(value)
+			escaped[name] = string(quoted[1 : len(quoted)-1])
+		}
+		return []byte(interpolate(string(data), escaped)), nil
 	}
 
 	return nil, nil
 }
+
+// interpolateValue replaces placeholders in all strings of a decoded JSON
+// value.
+func interpolateValue(v interface{}, vars map[string]string) interface{} {
+	if len(vars) == 0 {
+		return v
+	}
+
+	switch value := v.(type) {
+	case string:
+		return interpolate(value, vars)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			m[k] = interpolateValue(item, vars)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(value))
+		for i, item := range value {
+			l[i] = interpolateValue(item, vars)
+		}
+		return l
+	}
+	return v
+}
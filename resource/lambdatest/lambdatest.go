@@ -0,0 +1,576 @@
+// Package lambdatest implements a fake of the parts of the Lambda API that
+// the resource uses, so that the commands can be run against it without
+// contacting AWS. The resource is pointed at the fake with the endpoint
+// source option.
+package lambdatest
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// Region is the region of the ARNs of the fake
+const Region = "eu-west-1"
+
+// accountID is the account of the ARNs of the fake
+const accountID = "123456789012"
+
+// apiPrefix is the path prefix of the function endpoints
+const apiPrefix = "/2015-03-31/functions/"
+
+// tagsPrefix is the path prefix of the tag endpoints
+const tagsPrefix = "/2017-03-31/tags/"
+
+// InvokeFunc handles the invocation of a function version with the
+// payload, and returns the response payload and, for failed invocations,
+// the function error type ("Handled" or "Unhandled").
+type InvokeFunc func(function, version string, payload []byte) ([]byte, string)
+
+// Server is a fake Lambda API. Functions are created with CreateFunction,
+// and the state can be inspected after the commands have run.
+type Server struct {
+	// URL is the endpoint of the fake
+	URL string
+	// Invoke handles invocations, the payload is echoed by default
+	Invoke InvokeFunc
+
+	srv *httptest.Server
+
+	mu        sync.Mutex
+	functions map[string]*function
+	calls     []string
+}
+
+// function is the state of a fake function
+type function struct {
+	latest   lambda.FunctionConfiguration
+	versions []lambda.FunctionConfiguration
+	aliases  map[string]*lambda.AliasConfiguration
+	tags     map[string]string
+}
+
+// NewServer starts a fake Lambda API, it should be stopped with Close
+func NewServer() *Server {
+	s := &Server{
+		functions: map[string]*function{},
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close stops the server
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// CreateFunction creates a function with the code, without publishing a
+// version.
+func (s *Server) CreateFunction(name, runtime, handler string, code []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fn := &function{
+		latest: lambda.FunctionConfiguration{
+			FunctionName:     aws.String(name),
+			FunctionArn:      aws.String(functionARN(name, "")),
+			Runtime:          aws.String(runtime),
+			Handler:          aws.String(handler),
+			Timeout:          aws.Int64(3),
+			MemorySize:       aws.Int64(128),
+			Version:          aws.String("$LATEST"),
+			State:            aws.String(lambda.StateActive),
+			LastUpdateStatus: aws.String(lambda.LastUpdateStatusSuccessful),
+		},
+		aliases: map[string]*lambda.AliasConfiguration{},
+		tags:    map[string]string{},
+	}
+	fn.setCode(code)
+	s.functions[name] = fn
+}
+
+// Publish publishes the current code of the function as a new version,
+// and returns the version number.
+func (s *Server) Publish(name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fn, ok := s.functions[name]
+	if !ok {
+		return "", fmt.Errorf("there's no function %q", name)
+	}
+	return aws.StringValue(fn.publish().Version), nil
+}
+
+// SetAlias points an alias at a version, the alias is created if needed
+func (s *Server) SetAlias(name, alias, version string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fn, ok := s.functions[name]
+	if !ok {
+		return fmt.Errorf("there's no function %q", name)
+	}
+	fn.setAlias(alias, version, "")
+	return nil
+}
+
+// Alias returns the version that an alias points to
+func (s *Server) Alias(name, alias string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fn, ok := s.functions[name]
+	if !ok {
+		return "", false
+	}
+	a, ok := fn.aliases[alias]
+	if !ok {
+		return "", false
+	}
+	return aws.StringValue(a.FunctionVersion), true
+}
+
+// AliasDescription returns the description of an alias
+func (s *Server) AliasDescription(name, alias string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fn, ok := s.functions[name]
+	if !ok {
+		return "", false
+	}
+	a, ok := fn.aliases[alias]
+	if !ok {
+		return "", false
+	}
+	return aws.StringValue(a.Description), true
+}
+
+// Tags returns the tags of a function
+func (s *Server) Tags(name string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fn, ok := s.functions[name]
+	if !ok {
+		return nil
+	}
+	tags := make(map[string]string, len(fn.tags))
+	for k, v := range fn.tags {
+		tags[k] = v
+	}
+	return tags
+}
+
+// Versions returns the published versions of a function
+func (s *Server) Versions(name string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fn, ok := s.functions[name]
+	if !ok {
+		return nil
+	}
+	versions := make([]string, len(fn.versions))
+	for i, v := range fn.versions {
+		versions[i] = aws.StringValue(v.Version)
+	}
+	return versions
+}
+
+// Calls returns the names of the operations that have been called, in
+// the order that they were called.
+func (s *Server) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.calls...)
+}
+
+func (fn *function) setCode(code []byte) {
+	sum := sha256.Sum256(code)
+	fn.latest.CodeSha256 = aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+	fn.latest.CodeSize = aws.Int64(int64(len(code)))
+	fn.latest.LastModified = aws.String(
+		time.Now().UTC().Format("2006-01-02T15:04:05.000+0000"))
+}
+
+func (fn *function) publish() *lambda.FunctionConfiguration {
+	version := strconv.Itoa(len(fn.versions) + 1)
+
+	config := fn.latest
+	config.Version = aws.String(version)
+	config.FunctionArn = aws.String(
+		functionARN(aws.StringValue(fn.latest.FunctionName), version))
+	fn.versions = append(fn.versions, config)
+
+	return &fn.versions[len(fn.versions)-1]
+}
+
+func (fn *function) setAlias(alias, version, description string) *lambda.AliasConfiguration {
+	name := aws.StringValue(fn.latest.FunctionName)
+	config := &lambda.AliasConfiguration{
+		AliasArn:        aws.String(functionARN(name, alias)),
+		Name:            aws.String(alias),
+		FunctionVersion: aws.String(version),
+		Description:     aws.String(description),
+		RevisionId:      aws.String(strconv.FormatInt(time.Now().UnixNano(), 10)),
+	}
+	fn.aliases[alias] = config
+	return config
+}
+
+// configuration returns the configuration of a version or alias, or
+// $LATEST if the qualifier is empty.
+func (fn *function) configuration(qualifier string) (*lambda.FunctionConfiguration, bool) {
+	if a, ok := fn.aliases[qualifier]; ok {
+		qualifier = aws.StringValue(a.FunctionVersion)
+	}
+	if qualifier == "" || qualifier == "$LATEST" {
+		return &fn.latest, true
+	}
+	for i := range fn.versions {
+		if aws.StringValue(fn.versions[i].Version) == qualifier {
+			return &fn.versions[i], true
+		}
+	}
+	return nil, false
+}
+
+func functionARN(name, qualifier string) string {
+	arn := fmt.Sprintf("arn:aws:lambda:%s:%s:function:%s", Region, accountID, name)
+	if qualifier != "" {
+		arn += ":" + qualifier
+	}
+	return arn
+}
+
+// serveHTTP routes the requests to the operations by method and path
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, tagsPrefix) {
+		s.serveTags(w, r)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, apiPrefix) {
+		writeError(w, http.StatusNotFound, "UnknownOperationException",
+			"unsupported path "+r.URL.Path)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, apiPrefix), "/")
+	name, err := url.PathUnescape(parts[0])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidParameterValueException", err.Error())
+		return
+	}
+
+	// The function name can include the qualifier, f.ex. "my-function:PROD"
+	qualifier := r.URL.Query().Get("Qualifier")
+	if i := strings.LastIndex(name, ":"); i >= 0 && !strings.HasPrefix(name, "arn:") {
+		name, qualifier = name[:i], name[i+1:]
+	}
+
+	route := r.Method + " " + strings.Join(parts[1:], "/")
+	if len(parts) == 3 && parts[1] == "aliases" {
+		route = r.Method + " aliases/{alias}"
+	}
+
+	var op func(http.ResponseWriter, *http.Request, *function, string, []string)
+	var opName string
+	switch route {
+	case "GET versions":
+		opName, op = "ListVersionsByFunction", s.listVersions
+	case "POST versions":
+		opName, op = "PublishVersion", s.publishVersion
+	case "GET configuration":
+		opName, op = "GetFunctionConfiguration", s.getConfiguration
+	case "PUT code":
+		opName, op = "UpdateFunctionCode", s.updateCode
+	case "GET aliases/{alias}":
+		opName, op = "GetAlias", s.getAlias
+	case "PUT aliases/{alias}":
+		opName, op = "UpdateAlias", s.updateAlias
+	case "POST invocations":
+		opName, op = "Invoke", s.invoke
+	default:
+		writeError(w, http.StatusNotFound, "UnknownOperationException",
+			fmt.Sprintf("unsupported operation %s %s", r.Method, r.URL.Path))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls = append(s.calls, opName)
+
+	fn, ok := s.functions[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "ResourceNotFoundException",
+			"Function not found: "+functionARN(name, ""))
+		return
+	}
+	op(w, r, fn, qualifier, parts[1:])
+}
+
+// serveTags handles the tag operations, which are addressed by the
+// unqualified ARN of the function.
+func (s *Server) serveTags(w http.ResponseWriter, r *http.Request) {
+	arn, err := url.PathUnescape(strings.TrimPrefix(r.URL.Path, tagsPrefix))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidParameterValueException", err.Error())
+		return
+	}
+	parts := strings.Split(arn, ":")
+	if len(parts) != 7 || parts[5] != "function" {
+		writeError(w, http.StatusBadRequest, "InvalidParameterValueException",
+			"tags are only supported on unqualified function ARNs: "+arn)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fn, ok := s.functions[parts[6]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "ResourceNotFoundException",
+			"Function not found: "+arn)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.calls = append(s.calls, "ListTags")
+		writeJSON(w, lambda.ListTagsOutput{Tags: aws.StringMap(fn.tags)})
+	case http.MethodPost:
+		s.calls = append(s.calls, "TagResource")
+		var input struct {
+			Tags map[string]string
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			writeError(w, http.StatusBadRequest, "InvalidParameterValueException", err.Error())
+			return
+		}
+		for k, v := range input.Tags {
+			fn.tags[k] = v
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotFound, "UnknownOperationException",
+			fmt.Sprintf("unsupported operation %s %s", r.Method, r.URL.Path))
+	}
+}
+
+func (s *Server) listVersions(
+	w http.ResponseWriter, r *http.Request, fn *function, _ string, _ []string,
+) {
+	versions := []*lambda.FunctionConfiguration{&fn.latest}
+	for i := range fn.versions {
+		versions = append(versions, &fn.versions[i])
+	}
+	writeJSON(w, lambda.ListVersionsByFunctionOutput{Versions: versions})
+}
+
+func (s *Server) publishVersion(
+	w http.ResponseWriter, r *http.Request, fn *function, _ string, _ []string,
+) {
+	var input struct {
+		CodeSha256  *string
+		Description *string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidParameterValueException", err.Error())
+		return
+	}
+	if input.CodeSha256 != nil && *input.CodeSha256 != aws.StringValue(fn.latest.CodeSha256) {
+		writeError(w, http.StatusPreconditionFailed, "PreconditionFailedException",
+			"CodeSHA256 does not match the code of $LATEST")
+		return
+	}
+
+	config := fn.publish()
+	if input.Description != nil {
+		config.Description = input.Description
+	}
+	writeJSON(w, config)
+}
+
+func (s *Server) getConfiguration(
+	w http.ResponseWriter, r *http.Request, fn *function, qualifier string, _ []string,
+) {
+	config, ok := fn.configuration(qualifier)
+	if !ok {
+		writeError(w, http.StatusNotFound, "ResourceNotFoundException",
+			"Function not found: "+functionARN(aws.StringValue(fn.latest.FunctionName), qualifier))
+		return
+	}
+	writeJSON(w, config)
+}
+
+func (s *Server) updateCode(
+	w http.ResponseWriter, r *http.Request, fn *function, _ string, _ []string,
+) {
+	var input struct {
+		ZipFile []byte
+		Publish bool
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidParameterValueException", err.Error())
+		return
+	}
+	if len(input.ZipFile) == 0 {
+		writeError(w, http.StatusBadRequest, "InvalidParameterValueException",
+			"the fake only supports zip file uploads")
+		return
+	}
+
+	fn.setCode(input.ZipFile)
+	if input.Publish {
+		writeJSON(w, fn.publish())
+		return
+	}
+	writeJSON(w, &fn.latest)
+}
+
+func (s *Server) getAlias(
+	w http.ResponseWriter, r *http.Request, fn *function, _ string, parts []string,
+) {
+	alias, ok := fn.aliases[parts[1]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "ResourceNotFoundException",
+			"Alias not found: "+parts[1])
+		return
+	}
+	writeJSON(w, alias)
+}
+
+func (s *Server) updateAlias(
+	w http.ResponseWriter, r *http.Request, fn *function, _ string, parts []string,
+) {
+	current, ok := fn.aliases[parts[1]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "ResourceNotFoundException",
+			"Alias not found: "+parts[1])
+		return
+	}
+
+	var input struct {
+		FunctionVersion *string
+		Description     *string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidParameterValueException", err.Error())
+		return
+	}
+
+	version := aws.StringValue(current.FunctionVersion)
+	if input.FunctionVersion != nil {
+		version = *input.FunctionVersion
+		if _, ok := fn.configuration(version); !ok {
+			writeError(w, http.StatusNotFound, "ResourceNotFoundException",
+				"Function version not found: "+version)
+			return
+		}
+	}
+	description := aws.StringValue(current.Description)
+	if input.Description != nil {
+		description = *input.Description
+	}
+
+	writeJSON(w, fn.setAlias(parts[1], version, description))
+}
+
+func (s *Server) invoke(
+	w http.ResponseWriter, r *http.Request, fn *function, qualifier string, _ []string,
+) {
+	config, ok := fn.configuration(qualifier)
+	if !ok {
+		writeError(w, http.StatusNotFound, "ResourceNotFoundException",
+			"Function not found: "+functionARN(aws.StringValue(fn.latest.FunctionName), qualifier))
+		return
+	}
+
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidRequestContentException", err.Error())
+		return
+	}
+
+	switch r.Header.Get("X-Amz-Invocation-Type") {
+	case lambda.InvocationTypeEvent:
+		w.WriteHeader(http.StatusAccepted)
+		return
+	case lambda.InvocationTypeDryRun:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	response, functionError := payload, ""
+	if s.Invoke != nil {
+		response, functionError = s.Invoke(
+			aws.StringValue(config.FunctionName), aws.StringValue(config.Version), payload)
+	}
+
+	w.Header().Set("X-Amz-Executed-Version", aws.StringValue(config.Version))
+	if functionError != "" {
+		w.Header().Set("X-Amz-Function-Error", functionError)
+	}
+	if r.Header.Get("X-Amz-Log-Type") == lambda.LogTypeTail {
+		log := fmt.Sprintf("START Version: %s\nEND\n", aws.StringValue(config.Version))
+		w.Header().Set("X-Amz-Log-Result", base64.StdEncoding.EncodeToString([]byte(log)))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(response)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "ServiceException", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Amzn-RequestId", requestID())
+	_, _ = w.Write(data)
+}
+
+// writeError writes an error the way the Lambda API does, with the type
+// in a header and the message in the body.
+func writeError(w http.ResponseWriter, status int, errorType, message string) {
+	data, _ := json.Marshal(map[string]string{
+		"Type":    "User",
+		"message": message,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Amzn-ErrorType", errorType)
+	w.Header().Set("X-Amzn-RequestId", requestID())
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
+
+var (
+	requestMu      sync.Mutex
+	requestCounter int
+)
+
+// requestID returns a unique request ID
+func requestID() string {
+	requestMu.Lock()
+	defer requestMu.Unlock()
+
+	requestCounter++
+	return fmt.Sprintf("00000000-0000-0000-0000-%012d", requestCounter)
+}
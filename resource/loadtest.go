@@ -0,0 +1,180 @@
+package resource
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// LoadTest configures a load test where the function is invoked
+// repeatedly in parallel.
+type LoadTest struct {
+	// Iterations is the total number of invocations
+	Iterations *int `json:"iterations"`
+	// Concurrency is the number of parallel invocations, defaults to 1
+	Concurrency *int `json:"concurrency"`
+	// MinSuccessRate is the lowest acceptable share of successful
+	// invocations, between 0 and 1.
+	MinSuccessRate *float64 `json:"min_success_rate"`
+	// MaxP95Duration is the highest acceptable 95th percentile duration,
+	// f.ex. "500ms".
+	MaxP95Duration *string `json:"max_p95_duration"`
+}
+
+// IsLoadTest checks if a load test should be run
+func (lt *LoadTest) IsLoadTest() bool {
+	return lt.Iterations != nil || lt.Concurrency != nil
+}
+
+// validate checks the load test parameters
+func (lt *LoadTest) validate() error {
+	if !lt.IsLoadTest() {
+		if lt.MinSuccessRate != nil || lt.MaxP95Duration != nil {
+			return errors.New("min_success_rate and max_p95_duration can only be used together with iterations")
+		}
+		return nil
+	}
+	if lt.Iterations == nil || *lt.Iterations < 1 {
+		return errors.New("iterations must be at least 1")
+	}
+	if lt.Concurrency != nil && *lt.Concurrency < 1 {
+		return errors.New("concurrency must be at least 1")
+	}
+	if lt.MinSuccessRate != nil && (*lt.MinSuccessRate < 0 || *lt.MinSuccessRate > 1) {
+		return errors.New("min_success_rate must be between 0 and 1")
+	}
+	if _, err := lt.maxP95Duration(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// maxP95Duration returns the p95 duration threshold, zero means none
+func (lt *LoadTest) maxP95Duration() (time.Duration, error) {
+	return parseDurationParam("max_p95_duration", lt.MaxP95Duration, 0)
+}
+
+// LoadTestStats are the aggregated results of a load test. Durations are
+// the execution durations reported by Lambda in milliseconds.
+type LoadTestStats struct {
+	Iterations  int      `json:"iterations"`
+	Concurrency int      `json:"concurrency"`
+	Succeeded   int      `json:"succeeded"`
+	Failed      int      `json:"failed"`
+	SuccessRate float64  `json:"success_rate"`
+	P50Duration *float64 `json:"p50_duration_ms,omitempty"`
+	P95Duration *float64 `json:"p95_duration_ms,omitempty"`
+	MaxDuration *float64 `json:"max_duration_ms,omitempty"`
+	Errors      []string `json:"errors,omitempty"`
+}
+
+// maxLoadTestErrors is the maximum number of distinct errors that are
+// recorded in the stats.
+const maxLoadTestErrors = 10
+
+// run invokes the function the configured number of times, with at
+// most the configured number of invocations in flight.
+func (lt *LoadTest) run(invoke func() (*InvokeResult, error)) *LoadTestStats {
+	stats := LoadTestStats{
+		Iterations:  *lt.Iterations,
+		Concurrency: 1,
+	}
+	if lt.Concurrency != nil {
+		stats.Concurrency = *lt.Concurrency
+	}
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		durations []float64
+		seen      = map[string]bool{}
+	)
+
+	work := make(chan struct{})
+	for i := 0; i < stats.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range work {
+				result, err := invoke()
+
+				mu.Lock()
+				if result != nil {
+					if d := NewInvocationMetadata(result).Duration; d != nil {
+						durations = append(durations, *d)
+					}
+				}
+				if err != nil {
+					stats.Failed++
+					if msg := err.Error(); !seen[msg] && len(stats.Errors) < maxLoadTestErrors {
+						seen[msg] = true
+						stats.Errors = append(stats.Errors, msg)
+					}
+				} else {
+					stats.Succeeded++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < stats.Iterations; i++ {
+		work <- struct{}{}
+	}
+	close(work)
+	wg.Wait()
+
+	stats.SuccessRate = float64(stats.Succeeded) / float64(stats.Iterations)
+	if len(durations) > 0 {
+		sort.Float64s(durations)
+		stats.P50Duration = percentile(durations, 50)
+		stats.P95Duration = percentile(durations, 95)
+		stats.MaxDuration = &durations[len(durations)-1]
+	}
+
+	return &stats
+}
+
+// check compares the stats against the thresholds
+func (lt *LoadTest) check(stats *LoadTestStats) error {
+	var failures []string
+
+	if lt.MinSuccessRate != nil && stats.SuccessRate < *lt.MinSuccessRate {
+		failures = append(failures, fmt.Sprintf(
+			"success rate %.3f is below %.3f", stats.SuccessRate, *lt.MinSuccessRate))
+	}
+
+	maxP95, err := lt.maxP95Duration()
+	if err != nil {
+		return err
+	}
+	if maxP95 > 0 {
+		limit := float64(maxP95) / float64(time.Millisecond)
+		switch {
+		case stats.P95Duration == nil:
+			failures = append(failures, "no durations were reported")
+		case *stats.P95Duration > limit:
+			failures = append(failures, fmt.Sprintf(
+				"p95 duration %.2fms is above %.2fms", *stats.P95Duration, limit))
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("the load test failed: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// percentile returns the nearest-rank percentile of sorted values
+func percentile(sorted []float64, p float64) *float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	v := sorted[rank-1]
+	return &v
+}
@@ -0,0 +1,93 @@
+package resource
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestPercentile(t *testing.T) {
+	tests := []struct {
+		sorted []float64
+		p      float64
+		want   float64
+	}{
+		{[]float64{7}, 95, 7},
+		{[]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 50, 5},
+		{[]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 95, 10},
+		{[]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 90, 9},
+		{[]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 0, 1},
+		{[]float64{15, 20, 35, 40, 50}, 30, 20},
+	}
+	for _, tt := range tests {
+		if got := percentile(tt.sorted, tt.p); *got != tt.want {
+			t.Errorf("percentile(%v, %v) = %v, want %v", tt.sorted, tt.p, *got, tt.want)
+		}
+	}
+}
+
+func TestLoadTestCheck(t *testing.T) {
+	p95 := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name    string
+		lt      LoadTest
+		stats   LoadTestStats
+		failure string
+	}{
+		{
+			name:  "no thresholds",
+			stats: LoadTestStats{SuccessRate: 0},
+		},
+		{
+			name:  "within thresholds",
+			lt:    LoadTest{MinSuccessRate: aws.Float64(0.9), MaxP95Duration: aws.String("500ms")},
+			stats: LoadTestStats{SuccessRate: 0.9, P95Duration: p95(500)},
+		},
+		{
+			name:    "low success rate",
+			lt:      LoadTest{MinSuccessRate: aws.Float64(0.99)},
+			stats:   LoadTestStats{SuccessRate: 0.95},
+			failure: "success rate 0.950 is below 0.990",
+		},
+		{
+			name:    "slow p95",
+			lt:      LoadTest{MaxP95Duration: aws.String("1s")},
+			stats:   LoadTestStats{SuccessRate: 1, P95Duration: p95(1000.5)},
+			failure: "p95 duration 1000.50ms is above 1000.00ms",
+		},
+		{
+			name:    "no durations",
+			lt:      LoadTest{MaxP95Duration: aws.String("1s")},
+			stats:   LoadTestStats{SuccessRate: 0},
+			failure: "no durations were reported",
+		},
+		{
+			name:    "both",
+			lt:      LoadTest{MinSuccessRate: aws.Float64(1), MaxP95Duration: aws.String("100ms")},
+			stats:   LoadTestStats{SuccessRate: 0.5, P95Duration: p95(200)},
+			failure: "success rate 0.500 is below 1.000; p95 duration 200.00ms is above 100.00ms",
+		},
+	}
+	for _, tt := range tests {
+		err := tt.lt.check(&tt.stats)
+		switch {
+		case tt.failure == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.failure != "" && err == nil:
+			t.Errorf("%s: passed, want %q", tt.name, tt.failure)
+		case tt.failure != "" && !strings.HasSuffix(err.Error(), tt.failure):
+			t.Errorf("%s: got %q, want %q", tt.name, err.Error(), tt.failure)
+		}
+	}
+}
+
+func TestLoadTestValidateDuration(t *testing.T) {
+	for _, d := range []string{"0s", "-1s", "fast"} {
+		lt := LoadTest{Iterations: aws.Int(10), MaxP95Duration: aws.String(d)}
+		if err := lt.validate(); err == nil {
+			t.Errorf("max_p95_duration %q was accepted", d)
+		}
+	}
+}
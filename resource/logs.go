@@ -0,0 +1,171 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/pkg/errors"
+)
+
+// defaultLogsWait is how long we wait for the logs of an invocation to
+// show up in CloudWatch Logs by default.
+const defaultLogsWait = 30 * time.Second
+
+// logsPollInterval is the delay between attempts to fetch the logs
+const logsPollInterval = 2 * time.Second
+
+// functionLogGroup returns the log group that the function logs to
+func functionLogGroup(config *lambda.FunctionConfiguration) string {
+	if config.LoggingConfig != nil && config.LoggingConfig.LogGroup != nil {
+		return *config.LoggingConfig.LogGroup
+	}
+	return "/aws/lambda/" + aws.StringValue(config.FunctionName)
+}
+
+// invocationLogs is the log events of a single invocation
+type invocationLogs struct {
+	stream   string
+	start    int64
+	messages []string
+	complete bool
+}
+
+// fetchInvocationLogs fetches the log events of an invocation from
+// CloudWatch Logs. The events are delivered with a delay, so we poll until
+// the report line of the invocation shows up or the wait runs out. The
+// logs collected so far are returned if the wait runs out.
+func fetchInvocationLogs(
+	ctx context.Context, log *concourse.Logger, api *cloudwatchlogs.CloudWatchLogs,
+	logGroup, requestID string,
+	invoked time.Time, wait time.Duration,
+) ([]byte, error) {
+	deadline := time.Now().Add(wait)
+	logs := invocationLogs{}
+
+	for {
+		if logs.stream == "" {
+			if err := logs.findStream(ctx, api, logGroup, requestID, invoked); err != nil {
+				return nil, err
+			}
+		}
+		if logs.stream != "" {
+			if err := logs.collect(ctx, api, logGroup, requestID); err != nil {
+				return nil, err
+			}
+		}
+
+		if logs.complete {
+			break
+		}
+		if time.Now().Add(logsPollInterval).After(deadline) {
+			log.Warnf("the logs of the invocation weren't complete after waiting %s", wait)
+			break
+		}
+		if err := sleepContext(ctx, logsPollInterval); err != nil {
+			return nil, errors.Wrap(err, "waiting for the logs was interrupted")
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, message := range logs.messages {
+		buf.WriteString(message)
+		if !strings.HasSuffix(message, "\n") {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// findStream finds the log stream and the first event of the invocation
+func (l *invocationLogs) findStream(
+	ctx context.Context, api *cloudwatchlogs.CloudWatchLogs,
+	logGroup, requestID string,
+	invoked time.Time,
+) error {
+	input := cloudwatchlogs.FilterLogEventsInput{
+		LogGroupName:  &logGroup,
+		FilterPattern: aws.String(fmt.Sprintf("%q", requestID)),
+		// Allow for some clock skew between us and CloudWatch.
+		StartTime: aws.Int64(toMillis(invoked.Add(-time.Minute))),
+	}
+
+	var first *cloudwatchlogs.FilteredLogEvent
+	err := api.FilterLogEventsPagesWithContext(ctx, &input,
+		func(page *cloudwatchlogs.FilterLogEventsOutput, _ bool) bool {
+			for _, event := range page.Events {
+				if first == nil || aws.Int64Value(event.Timestamp) < aws.Int64Value(first.Timestamp) {
+					first = event
+				}
+			}
+			return true
+		})
+	if err != nil {
+		return errors.Wrapf(err, "failed to search log group %q", logGroup)
+	}
+
+	if first != nil {
+		l.stream = aws.StringValue(first.LogStreamName)
+		l.start = aws.Int64Value(first.Timestamp)
+	}
+	return nil
+}
+
+// collect reads the events of the invocation from the log stream
+func (l *invocationLogs) collect(
+	ctx context.Context, api *cloudwatchlogs.CloudWatchLogs,
+	logGroup, requestID string,
+) error {
+	input := cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  &logGroup,
+		LogStreamName: &l.stream,
+		StartTime:     &l.start,
+		StartFromHead: aws.Bool(true),
+	}
+
+	var messages []string
+	started, complete := false, false
+	err := api.GetLogEventsPagesWithContext(ctx, &input,
+		func(page *cloudwatchlogs.GetLogEventsOutput, _ bool) bool {
+			for _, event := range page.Events {
+				message := aws.StringValue(event.Message)
+				if !started {
+					if !strings.Contains(message, requestID) {
+						continue
+					}
+					started = true
+				}
+				messages = append(messages, message)
+				if isReportLine(message, requestID) {
+					complete = true
+					return false
+				}
+			}
+			return len(page.Events) > 0
+		})
+	if err != nil {
+		return errors.Wrapf(err, "failed to read log stream %q", l.stream)
+	}
+
+	l.messages = messages
+	l.complete = complete
+	return nil
+}
+
+// isReportLine checks if the message is the report that Lambda logs at the
+// end of an invocation, in either the text or the JSON log format.
+func isReportLine(message, requestID string) bool {
+	return strings.Contains(message, requestID) &&
+		(strings.HasPrefix(message, "REPORT ") ||
+			strings.Contains(message, `"platform.report"`))
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,385 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/pkg/errors"
+)
+
+// MetricsEndpointEnv is the environment variable with the metrics endpoint
+// that is used when the source doesn't set metrics_endpoint.
+const MetricsEndpointEnv = "LAMBDA_RESOURCE_METRICS_ENDPOINT"
+
+// metricsPrefix prefixes the names of all metrics
+const metricsPrefix = "lambda_resource."
+
+// metricsTimeout limits how long sending the metrics may take
+const metricsTimeout = 5 * time.Second
+
+const (
+	metricCounter = "c"
+	metricGauge   = "g"
+	metricTiming  = "ms"
+)
+
+// metricPoint is a single measurement
+type metricPoint struct {
+	Name  string
+	Kind  string
+	Value float64
+	Tags  map[string]string
+	Time  time.Time
+}
+
+// metrics collects measurements during a command and sends them to the
+// endpoint when the command is done. A nil *metrics discards everything.
+type metrics struct {
+	endpoint *url.URL
+	tags     map[string]string
+
+	mu     sync.Mutex
+	points []metricPoint
+}
+
+type metricsKey struct{}
+
+// newMetrics creates a collector for the command, or returns nil if no
+// metrics endpoint has been configured.
+func newMetrics(
+	source Source, command string, meta concourse.BuildMetadata,
+) (*metrics, error) {
+	endpoint := os.Getenv(MetricsEndpointEnv)
+	if source.MetricsEndpoint != nil {
+		endpoint = *source.MetricsEndpoint
+	}
+	if endpoint == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid metrics endpoint %q", endpoint)
+	}
+	switch u.Scheme {
+	case "statsd", "http", "https":
+	default:
+		return nil, fmt.Errorf(
+			"unsupported metrics endpoint %q, use statsd://, http://, or https://", endpoint)
+	}
+
+	tags := map[string]string{"command": command}
+	if source.FunctionName != "" {
+		tags["function"] = source.FunctionName
+	}
+	if source.LayerName != nil {
+		tags["layer"] = *source.LayerName
+	}
+	if meta.TeamName != "" {
+		tags["team"] = meta.TeamName
+	}
+	if meta.PipelineName != "" {
+		tags["pipeline"] = meta.PipelineName
+	}
+
+	return &metrics{endpoint: u, tags: tags}, nil
+}
+
+// withMetrics returns a context that carries the collector
+func withMetrics(ctx context.Context, m *metrics) context.Context {
+	if m == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, metricsKey{}, m)
+}
+
+// metricsFromContext returns the collector of the context, or nil
+func metricsFromContext(ctx context.Context) *metrics {
+	m, _ := ctx.Value(metricsKey{}).(*metrics)
+	return m
+}
+
+func (m *metrics) add(name, kind string, value float64, tags map[string]string) {
+	if m == nil {
+		return
+	}
+
+	all := make(map[string]string, len(m.tags)+len(tags))
+	for k, v := range m.tags {
+		all[k] = v
+	}
+	for k, v := range tags {
+		all[k] = v
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.points = append(m.points, metricPoint{
+		Name:  metricsPrefix + name,
+		Kind:  kind,
+		Value: value,
+		Tags:  all,
+		Time:  time.Now(),
+	})
+}
+
+// count adds to a counter
+func (m *metrics) count(name string, value int64, tags map[string]string) {
+	m.add(name, metricCounter, float64(value), tags)
+}
+
+// gauge records the current value of something
+func (m *metrics) gauge(name string, value float64, tags map[string]string) {
+	m.add(name, metricGauge, value, tags)
+}
+
+// timing records a duration in milliseconds
+func (m *metrics) timing(name string, d time.Duration, tags map[string]string) {
+	m.add(name, metricTiming, float64(d)/float64(time.Millisecond), tags)
+}
+
+// flush sends the collected measurements, failures are logged as warnings
+// since metrics shouldn't fail the command.
+func (m *metrics) flush(log *concourse.Logger) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	points := m.points
+	m.points = nil
+	m.mu.Unlock()
+
+	if len(points) == 0 {
+		return
+	}
+
+	var err error
+	if m.endpoint.Scheme == "statsd" {
+		err = sendStatsd(m.endpoint.Host, points)
+	} else {
+		err = sendOTLP(m.endpoint, points)
+	}
+	if err != nil {
+		log.Warnf("failed to send metrics: %s", err.Error())
+	}
+}
+
+// recordRequestMetrics is a request handler that records the latency of
+// AWS API requests in the metrics of the request context.
+func recordRequestMetrics(r *request.Request) {
+	m := metricsFromContext(r.Context())
+	if m == nil {
+		return
+	}
+
+	status := "0"
+	if r.HTTPResponse != nil {
+		status = strconv.Itoa(r.HTTPResponse.StatusCode)
+	}
+	m.timing("aws.request.duration", time.Since(r.Time), map[string]string{
+		"service":   r.ClientInfo.ServiceName,
+		"operation": r.Operation.Name,
+		"status":    status,
+	})
+}
+
+// statsdPacketSize is the largest UDP packet we send, to stay below
+// common MTUs.
+const statsdPacketSize = 1432
+
+// sendStatsd sends the points to a StatsD server over UDP, with tags in
+// the DogStatsD format.
+func sendStatsd(addr string, points []metricPoint) error {
+	conn, err := net.DialTimeout("udp", addr, metricsTimeout)
+	if err != nil {
+		return errors.Wrapf(err, "failed to connect to %q", addr)
+	}
+	defer conn.Close()
+
+	var packet bytes.Buffer
+	send := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		_, err := conn.Write(packet.Bytes())
+		packet.Reset()
+		return errors.Wrapf(err, "failed to send metrics to %q", addr)
+	}
+
+	for _, p := range points {
+		line := statsdLine(p)
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdPacketSize {
+			if err := send(); err != nil {
+				return err
+			}
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+
+	return send()
+}
+
+func statsdLine(p metricPoint) string {
+	line := fmt.Sprintf("%s:%s|%s", p.Name,
+		strconv.FormatFloat(p.Value, 'f', -1, 64), p.Kind)
+
+	if len(p.Tags) == 0 {
+		return line
+	}
+
+	tags := make([]string, 0, len(p.Tags))
+	for k, v := range p.Tags {
+		tags = append(tags, k+":"+v)
+	}
+	sort.Strings(tags)
+	return line + "|#" + strings.Join(tags, ",")
+}
+
+// OTLP/HTTP JSON encoding of the metrics, see
+// https://opentelemetry.io/docs/specs/otlp/#otlphttp
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpMetric struct {
+	Name  string     `json:"name"`
+	Unit  string     `json:"unit,omitempty"`
+	Gauge *otlpGauge `json:"gauge,omitempty"`
+	Sum   *otlpSum   `json:"sum,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic"`
+}
+
+type otlpDataPoint struct {
+	Attributes   []otlpAttribute `json:"attributes"`
+	TimeUnixNano string          `json:"timeUnixNano"`
+	AsDouble     float64         `json:"asDouble"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// otlpDeltaTemporality is AGGREGATION_TEMPORALITY_DELTA
+const otlpDeltaTemporality = 1
+
+func otlpAttributes(tags map[string]string) []otlpAttribute {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	attributes := make([]otlpAttribute, len(keys))
+	for i, k := range keys {
+		attributes[i] = otlpAttribute{Key: k, Value: otlpValue{StringValue: tags[k]}}
+	}
+	return attributes
+}
+
+// sendOTLP posts the points to an OpenTelemetry collector with OTLP/HTTP.
+// The path defaults to /v1/metrics.
+func sendOTLP(endpoint *url.URL, points []metricPoint) error {
+	scope := otlpScopeMetrics{Scope: otlpScope{Name: "lambda-resource"}}
+	for _, p := range points {
+		dp := otlpDataPoint{
+			Attributes:   otlpAttributes(p.Tags),
+			TimeUnixNano: strconv.FormatInt(p.Time.UnixNano(), 10),
+			AsDouble:     p.Value,
+		}
+
+		metric := otlpMetric{Name: p.Name}
+		switch p.Kind {
+		case metricCounter:
+			metric.Sum = &otlpSum{
+				DataPoints:             []otlpDataPoint{dp},
+				AggregationTemporality: otlpDeltaTemporality,
+				IsMonotonic:            true,
+			}
+		case metricTiming:
+			metric.Unit = "ms"
+			metric.Gauge = &otlpGauge{DataPoints: []otlpDataPoint{dp}}
+		default:
+			metric.Gauge = &otlpGauge{DataPoints: []otlpDataPoint{dp}}
+		}
+		scope.Metrics = append(scope.Metrics, metric)
+	}
+
+	body, err := json.Marshal(otlpRequest{
+		ResourceMetrics: []otlpResourceMetrics{{
+			Resource: otlpResource{Attributes: otlpAttributes(map[string]string{
+				"service.name": "lambda-resource",
+			})},
+			ScopeMetrics: []otlpScopeMetrics{scope},
+		}},
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal metrics")
+	}
+
+	target := *endpoint
+	if target.Path == "" || target.Path == "/" {
+		target.Path = "/v1/metrics"
+	}
+
+	client := http.Client{Timeout: metricsTimeout}
+	res, err := client.Post(target.String(), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrapf(err, "failed to send metrics to %q", target.String())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("the metrics endpoint responded with %s", res.Status)
+	}
+	return nil
+}
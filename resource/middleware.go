@@ -0,0 +1,91 @@
+package resource
+
+import (
+	"time"
+
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+)
+
+// sourceCommand is implemented by the commands of the resource
+type sourceCommand interface {
+	source() Source
+}
+
+// Middleware returns the middleware that the commands of the resource run
+// in. It redacts the AWS credentials from the log, enables debug logging
+// and the AWS debug log, writes the errors of get and put to a file,
+// records metrics, and categorises errors.
+func Middleware() []concourse.Middleware {
+	return []concourse.Middleware{
+		redactCredentials,
+		debugLogging,
+		debugAWS,
+		errorFile,
+		commandMetrics,
+		concourse.Timing(),
+		concourse.Recover(),
+	}
+}
+
+// redactCredentials redacts the AWS credentials of the source from the log
+func redactCredentials(next concourse.CommandFunc) concourse.CommandFunc {
+	return func(
+		ctx *concourse.CommandContext, cmd concourse.CommandHandler,
+	) (*concourse.CommandResponse, error) {
+		if sc, ok := cmd.(sourceCommand); ok {
+			source := sc.source()
+			ctx.Redact(source.AccessKey, source.SessionToken)
+		}
+		return next(ctx, cmd)
+	}
+}
+
+// debugLogging enables debug logging and logs the request if the source
+// asks for it.
+func debugLogging(next concourse.CommandFunc) concourse.CommandFunc {
+	return func(
+		ctx *concourse.CommandContext, cmd concourse.CommandHandler,
+	) (*concourse.CommandResponse, error) {
+		if sc, ok := cmd.(sourceCommand); ok {
+			debugRequest(ctx, sc.source(), cmd)
+		}
+		return next(ctx, cmd)
+	}
+}
+
+// commandMetrics categorises the error of the command, and records the
+// duration and the error category of the command.
+func commandMetrics(next concourse.CommandFunc) concourse.CommandFunc {
+	return func(
+		ctx *concourse.CommandContext, cmd concourse.CommandHandler,
+	) (*concourse.CommandResponse, error) {
+		var source Source
+		if sc, ok := cmd.(sourceCommand); ok {
+			source = sc.source()
+		}
+
+		m, err := newMetrics(source, ctx.CommandName(), ctx.BuildMetadata())
+		if err != nil {
+			return nil, concourse.ConfigError(err)
+		}
+		ctx.Context = withMetrics(ctx.Context, m)
+		defer m.flush(ctx.Logger)
+
+		start := time.Now()
+		resp, err := next(ctx, cmd)
+		err = classifyError(ctx, err)
+
+		result := "success"
+		if err != nil {
+			result = "failure"
+			m.count("command.errors", 1, map[string]string{
+				"category": concourse.ErrorCategory(err).Category,
+			})
+		}
+		m.timing("command.duration", time.Since(start), map[string]string{
+			"result": result,
+		})
+
+		return resp, err
+	}
+}
@@ -3,6 +3,9 @@ package resource
 import (
 	"archive/zip"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -10,8 +13,10 @@ import (
 	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
-	"github.com/Sydsvenskan/concourse"
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/pkg/errors"
@@ -23,17 +28,42 @@ type OutCommand struct {
 	Source Source `json:"source"`
 	// Params passed to the resource
 	Params PutParams `json:"params"`
+	// API is the Lambda API to use, defaults to a client from the source
+	API LambdaAPI `json:"-"`
+	// Stacks is the CloudFormation and S3 API to use for stack deploys,
+	// defaults to clients from the source.
+	Stacks StackAPI `json:"-"`
+
+	progress *putProgress
 }
 
 // PutParams is the params used when put:ing a resource.
 type PutParams struct {
+	// RegionName overrides the region of the source definition
+	RegionName *string `json:"region_name"`
 	// ZipFile is a path to a zip archive containing the function code.
 	ZipFile *string `json:"zip_file"`
+	// ZipFileFile is a file to read the zip file path from. It isn't the
+	// generic "<name>_file" param, relative paths in it are relative to
+	// the file.
+	ZipFileFile *string `json:"zip_file_file"`
 	// CodeDirectory is a path to a directory containing the function
 	// implementation
 	CodeDirectory *string `json:"code_dir"`
+	// CodeDirectoryFile is a file to read the code directory path from,
+	// like ZipFileFile.
+	CodeDirectoryFile *string `json:"code_dir_file"`
 	// CodeFile is a path to the file implementing the function
 	CodeFile *string `json:"code_file"`
+	// SAMTemplate is an AWS SAM template with the function to deploy, the
+	// code is read from the CodeUri of the function.
+	SAMTemplate *string `json:"sam_template"`
+	// SAMFunction is the logical ID of the function in the SAM template,
+	// required if the template has more than one function.
+	SAMFunction *string `json:"sam_function"`
+	// SAMConfig manages the function configuration from the properties of
+	// the function in the SAM template.
+	SAMConfig bool `json:"sam_config"`
 	// Alias is used to "tag" a function with f.ex. a "PROD" or "TEST" alias.
 	Alias *string `json:"alias"`
 	// Version can be used together with "Alias" to tag a specific version
@@ -41,6 +71,193 @@ type PutParams struct {
 	Version *string `json:"version"`
 	// VersionFile is a file to read the version number from
 	VersionFile *string `json:"version_file"`
+	// PromoteFrom is an alias whose current version should be tagged with
+	// "Alias".
+	PromoteFrom *string `json:"promote_from"`
+	// Rollback points the alias back at the version it pointed to before it
+	// was last updated.
+	Rollback bool `json:"rollback"`
+	// PreviousVersionFile is a file to read the version to roll back to
+	// from.
+	PreviousVersionFile *string `json:"previous_version_file"`
+	// BeforePackage is a command that is run in the code directory before
+	// it's zipped.
+	BeforePackage *PackageCommand `json:"before_package"`
+	// PipRequirements is a requirements.txt file listing python dependencies
+	// that should be installed into the package.
+	PipRequirements *string `json:"pip_requirements"`
+	// PipPlatform is the platform tag (f.ex. "manylinux2014_x86_64") to
+	// install binary python dependencies for.
+	PipPlatform *string `json:"pip_platform"`
+	// NpmInstall installs node dependencies into the package, the only
+	// supported mode is "production".
+	NpmInstall *string `json:"npm_install"`
+	// ValidateHandler verifies that the handler of the function exists in
+	// the package before it's uploaded.
+	ValidateHandler bool `json:"validate_handler"`
+	// Handler overrides the handler that the package is validated against
+	Handler *string `json:"handler"`
+	// ChecksumFile is a file containing the sha256 digest of the zip file
+	ChecksumFile *string `json:"checksum_file"`
+	// BuildInfo adds a "build-info.json" file with build provenance
+	// information to the root of the package.
+	BuildInfo bool `json:"build_info"`
+	// BuildInfoRefFile is a file, f.ex. ".git/ref", with the source
+	// revision that is included in the build info.
+	BuildInfoRefFile *string `json:"build_info_ref_file"`
+	// TraceTags tags the function with the published version and the build
+	// that published it.
+	TraceTags bool `json:"trace_tags"`
+	// RefFile is a file, f.ex. ".git/ref", with the source revision that is
+	// included in the trace tags and, unless a separate file is given, the
+	// build info.
+	RefFile *string `json:"ref_file"`
+	// ConfigFile is a JSON or YAML file describing the desired function
+	// configuration.
+	ConfigFile *string `json:"config_file"`
+	// FailOnDrift fails the put instead of applying the configuration if
+	// the live configuration differs from the config file.
+	FailOnDrift bool `json:"fail_on_drift"`
+	// FailOnNoChanges fails the put if neither the code, the configuration,
+	// nor the alias would change.
+	FailOnNoChanges bool `json:"fail_on_no_changes"`
+	// FailOnDeprecatedRuntime fails the put if the function uses a
+	// deprecated runtime.
+	FailOnDeprecatedRuntime bool `json:"fail_on_deprecated_runtime"`
+	// RuntimeManagement sets when the runtime of the function is updated,
+	// either "auto", "function-update", or a runtime version ARN to pin.
+	RuntimeManagement *string `json:"runtime_management"`
+	// RecursiveLoop is the recursive loop detection setting of the
+	// function, "Allow" or "Terminate".
+	RecursiveLoop *string `json:"recursive_loop"`
+	// StackKeyParameter deploys the code through the CloudFormation stack
+	// that manages the function, by updating the stack parameter with this
+	// name to the S3 key of the package.
+	StackKeyParameter *string `json:"stack_key_parameter"`
+	// StackName is the stack that manages the function, it's looked up
+	// from the function if it's left out.
+	StackName *string `json:"stack_name"`
+	// StackBucket is the S3 bucket that packages are uploaded to
+	StackBucket *string `json:"stack_bucket"`
+	// StackKeyPrefix is prepended to the S3 keys of the packages
+	StackKeyPrefix *string `json:"stack_key_prefix"`
+	// Delete deletes the function
+	Delete bool `json:"delete"`
+	// DeleteAlias is the name of an alias to delete
+	DeleteAlias *string `json:"delete_alias"`
+}
+
+// CommandTimeout returns how long the command may run
+func (cmd *OutCommand) CommandTimeout() (time.Duration, error) {
+	return cmd.Source.commandTimeout()
+}
+
+// LogFormat returns the format of the build log
+func (cmd *OutCommand) LogFormat() (concourse.Format, error) {
+	return cmd.Source.logFormat()
+}
+
+// source returns the source definition for the middleware
+func (cmd *OutCommand) source() Source {
+	return cmd.Source
+}
+
+// currentStep returns the step that the put is in, or an empty string if
+// it hasn't started to change the function.
+func (cmd *OutCommand) currentStep() string {
+	if cmd.progress == nil {
+		return ""
+	}
+	return cmd.progress.currentStep()
+}
+
+// Validate checks that the source can be put to, and that the params can
+// be combined.
+func (cmd *OutCommand) Validate() error {
+	if cmd.Source.IsMultiFunction() || cmd.Source.LayerName != nil {
+		return errors.New("put isn't supported for resources that track multiple functions or a layer")
+	}
+	if cmd.Source.FunctionName == "" {
+		return errors.New("function_name is required")
+	}
+
+	p := cmd.Params
+	hasVersion := p.Version != nil || p.VersionFile != nil
+	hasCode := hasCodePayload(p) || p.ZipFileFile != nil || p.CodeDirectoryFile != nil ||
+		p.SAMTemplate != nil
+	hasConfig := p.ConfigFile != nil || p.SAMConfig
+
+	if p.SAMTemplate != nil {
+		if p.ZipFile != nil || p.ZipFileFile != nil || p.CodeDirectory != nil ||
+			p.CodeDirectoryFile != nil || p.CodeFile != nil {
+			return errors.New("sam_template can't be combined with other function code")
+		}
+		if p.SAMConfig && p.ConfigFile != nil {
+			return errors.New("sam_config can't be combined with config_file")
+		}
+	} else if p.SAMFunction != nil || p.SAMConfig {
+		return errors.New("sam_function and sam_config can only be used together with sam_template")
+	}
+
+	if p.Delete || p.DeleteAlias != nil {
+		if hasVersion || hasCode || p.Alias != nil || hasConfig {
+			return errors.New(
+				"delete and delete_alias can't be combined with other actions")
+		}
+	}
+	if p.PromoteFrom != nil {
+		if p.Alias == nil {
+			return errors.New("promote_from can only be used together with alias")
+		}
+		if hasVersion || hasCode {
+			return errors.New(
+				"promote_from can't be combined with a version or function code")
+		}
+	}
+	if p.Rollback {
+		if p.Alias == nil && cmd.Source.Alias == nil {
+			return errors.New("rollback requires an alias")
+		}
+		if hasVersion || hasCode || p.PromoteFrom != nil {
+			return errors.New(
+				"rollback can't be combined with a version, promotion or function code")
+		}
+	}
+	if p.Version != nil {
+		if err := validateVersion(*p.Version); err != nil {
+			return err
+		}
+	}
+	if p.StackKeyParameter != nil {
+		if p.StackBucket == nil {
+			return errors.New("stack_key_parameter requires stack_bucket")
+		}
+		if !hasCode {
+			return errors.New("stack_key_parameter can only be used together with function code")
+		}
+		if hasConfig {
+			return errors.New(
+				"stack_key_parameter can't be combined with config_file or sam_config, the stack manages the configuration")
+		}
+	} else if p.StackName != nil || p.StackBucket != nil || p.StackKeyPrefix != nil {
+		return errors.New(
+			"stack_name, stack_bucket and stack_key_prefix can only be used together with stack_key_parameter")
+	}
+	if p.FailOnDrift && !hasConfig {
+		return errors.New("fail_on_drift can only be used together with config_file or sam_config")
+	}
+	if p.RuntimeManagement != nil {
+		if err := validateRuntimeManagement(*p.RuntimeManagement); err != nil {
+			return err
+		}
+	}
+	if p.RecursiveLoop != nil {
+		if err := validateRecursiveLoop(*p.RecursiveLoop); err != nil {
+			return err
+		}
+	}
+
+	return validateCodeParams(p)
 }
 
 // HandleCommand runs the in command
@@ -59,94 +276,922 @@ func (cmd *OutCommand) HandleCommand(ctx *concourse.CommandContext) (
 		version = &loadedVersion
 	}
 
-	// Version number sanity check
+	if cmd.Params.RegionName != nil {
+		cmd.Source.RegionName = *cmd.Params.RegionName
+	}
+
+	api := lambdaAPI(cmd.API, cmd.Source)
+
+	if cmd.Params.Delete || cmd.Params.DeleteAlias != nil {
+		return cmd.delete(ctx, api)
+	}
+
+	if cmd.Params.PromoteFrom != nil {
+		source, err := api.GetAliasWithContext(ctx, &lambda.GetAliasInput{
+			FunctionName: &cmd.Source.FunctionName,
+			Name:         cmd.Params.PromoteFrom,
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err,
+				"failed to get the alias %q to promote from", *cmd.Params.PromoteFrom)
+		}
+
+		ctx.Infof("promoting version %s from %s to %s",
+			*source.FunctionVersion, *cmd.Params.PromoteFrom, *cmd.Params.Alias)
+
+		version = source.FunctionVersion
+	}
+
+	if cmd.Params.Rollback {
+		if cmd.Params.Alias == nil {
+			cmd.Params.Alias = cmd.Source.Alias
+		}
+
+		previous, err := rollbackVersion(
+			ctx, api, cmd.Source.FunctionName, *cmd.Params.Alias,
+			cmd.Params.PreviousVersionFile,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to resolve the version to roll back to")
+		}
+
+		ctx.Infof("rolling back %s to version %s",
+			*cmd.Params.Alias, previous)
+
+		version = &previous
+	}
+
+	// Version number sanity check, the version file is only read here
 	if version != nil {
-		if len(*version) == 0 {
-			return nil, errors.New("empty version string")
+		if err := validateVersion(*version); err != nil {
+			return nil, err
 		}
-		if _, err := strconv.Atoi(*version); err != nil {
-			return nil, errors.Wrapf(err, "%q is not a valid version integer", *version)
+	}
+
+	if cmd.Params.ZipFileFile != nil {
+		zipFile, err := readPathFile(*cmd.Params.ZipFileFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to resolve zip_file_file")
+		}
+		cmd.Params.ZipFile = &zipFile
+	}
+
+	if cmd.Params.CodeDirectoryFile != nil {
+		codeDir, err := readPathFile(*cmd.Params.CodeDirectoryFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to resolve code_dir_file")
 		}
+		cmd.Params.CodeDirectory = &codeDir
+	}
+
+	var samFunction *SAMFunction
+	if cmd.Params.SAMTemplate != nil {
+		fn, err := cmd.resolveSAMFunction(ctx)
+		if err != nil {
+			return nil, err
+		}
+		samFunction = fn
 	}
 
-	api := LambdaClient(cmd.Source)
 	resp := &concourse.CommandResponse{}
 
+	// The configuration of the deployed version
+	var deployed *lambda.FunctionConfiguration
+
+	var desired *FunctionConfig
+	if cmd.Params.ConfigFile != nil {
+		loaded, err := LoadFunctionConfig(*cmd.Params.ConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		desired = loaded
+	}
+	if cmd.Params.SAMConfig {
+		desired = samFunction.Config
+	}
+
+	vars := buildVariables(ctx.BuildMetadata())
+	if version != nil {
+		vars["VERSION"] = *version
+	}
+	if desired != nil {
+		ctx.Redact(desired.sensitiveValues()...)
+		ctx.Redact(desired.Interpolate(vars).sensitiveValues()...)
+	}
+
+	// A configuration that references the version that is about to be
+	// published has to be applied between the code upload and publishing.
+	deferConfig := desired != nil && hasCodePayload(cmd.Params) &&
+		desired.UsesVariable("VERSION")
+
+	progress := newPutProgress(cmd.steps(desired != nil, deferConfig))
+	cmd.progress = progress
+	defer func() {
+		if ctx.Err() == context.Canceled {
+			progress.logAborted(ctx.Logger)
+		}
+	}()
+
+	var configChanges []string
+	if desired != nil && !deferConfig {
+		progress.start(stepConfig)
+		changes, err := cmd.applyConfig(ctx, api, resp, desired.Interpolate(vars))
+		if err != nil {
+			return nil, err
+		}
+		configChanges = changes
+	}
+
+	if cmd.Params.RuntimeManagement != nil {
+		progress.start(stepRuntimeManagement)
+		if err := putRuntimeManagement(
+			ctx, api, cmd.Source.FunctionName, *cmd.Params.RuntimeManagement,
+		); err != nil {
+			return nil, err
+		}
+
+		ctx.Infof("successfully set runtime management to %s",
+			*cmd.Params.RuntimeManagement)
+	}
+
+	if cmd.Params.RecursiveLoop != nil {
+		progress.start(stepRecursiveLoop)
+		if err := putRecursiveLoop(
+			ctx, api, cmd.Source.FunctionName, *cmd.Params.RecursiveLoop,
+		); err != nil {
+			return nil, err
+		}
+
+		ctx.Infof("successfully set recursive loop detection to %s",
+			*cmd.Params.RecursiveLoop)
+	}
+
 	if hasCodePayload(cmd.Params) {
-		data, err := codePayload(cmd.Params)
+		progress.start(stepPackage)
+		var runtime string
+		if cmd.Params.PipRequirements != nil {
+			r, err := packageRuntime(ctx, api, cmd.Source.FunctionName, desired)
+			if err != nil {
+				return nil, err
+			}
+			runtime = r
+		}
+
+		data, err := codePayload(ctx, cmd.Params, runtime)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to get code payload data")
+			return nil, packagingError(errors.Wrap(err, "failed to get code payload data"))
 		}
 
-		config, err := api.UpdateFunctionCode(&lambda.UpdateFunctionCodeInput{
-			FunctionName: &cmd.Source.FunctionName,
-			ZipFile:      data,
-			Publish:      aws.Bool(true),
-		})
+		var digest []byte
+		if cmd.Params.ChecksumFile != nil {
+			digest, err = verifyChecksumFile(*cmd.Params.ChecksumFile, data)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if cmd.Params.BuildInfo {
+			refFile := cmd.Params.BuildInfoRefFile
+			if refFile == nil {
+				refFile = cmd.Params.RefFile
+			}
+
+			info, err := NewBuildInfo(ctx.BuildMetadata(), refFile)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to collect build info")
+			}
+
+			data, err = addBuildInfo(data, info)
+			if err != nil {
+				return nil, packagingError(errors.Wrap(err, "failed to add build info to package"))
+			}
+
+			if digest != nil {
+				sum := sha256.Sum256(data)
+				digest = sum[:]
+			}
+		}
+
+		direct := cmd.Params.StackKeyParameter == nil
+		if err := validatePackageSize(data, direct); err != nil {
+			return nil, packagingError(err)
+		}
+		metricsFromContext(ctx).gauge("package.size", float64(len(data)), nil)
+
+		if cmd.Params.ValidateHandler {
+			if err := validatePackageHandler(
+				ctx, api, cmd.Source, cmd.Params, data,
+			); err != nil {
+				return nil, err
+			}
+		}
+
+		if cmd.Params.FailOnNoChanges && len(configChanges) == 0 && !deferConfig {
+			if err := failOnNoChanges(
+				ctx, api, cmd.Source.FunctionName, cmd.Params.Alias, nil, data,
+			); err != nil {
+				return nil, err
+			}
+		}
+
+		fileCount, err := countFiles(data)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to update function code")
+			return nil, err
+		}
+
+		progress.start(stepUpload)
+		uploadStart := time.Now()
+
+		var config *lambda.FunctionConfiguration
+		var uploadDuration time.Duration
+		if cmd.Params.StackKeyParameter != nil {
+			config, uploadDuration, err = cmd.deployStack(ctx, api, progress, data)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			err = conflictRetry.Do(ctx, ctx.Logger, "code update", func() error {
+				var err error
+				config, err = api.UpdateFunctionCodeWithContext(ctx, &lambda.UpdateFunctionCodeInput{
+					FunctionName: &cmd.Source.FunctionName,
+					ZipFile:      data,
+					Publish:      aws.Bool(!deferConfig && !cmd.Params.TraceTags),
+				}, withUploadProgress(ctx.Logger, uploadProgressInterval))
+				return err
+			})
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to update function code")
+			}
+
+			uploadDuration = time.Since(uploadStart)
+
+			if cmd.Params.TraceTags && !deferConfig {
+				progress.start(stepPublish)
+				config, err = cmd.publishTraced(ctx, api, config.CodeSha256)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if digest != nil {
+			uploaded := base64.StdEncoding.EncodeToString(digest)
+			if uploaded != *config.CodeSha256 {
+				return nil, fmt.Errorf(
+					"the uploaded code has the sha256 %s, expected %s",
+					*config.CodeSha256, uploaded,
+				)
+			}
 		}
 
-		fmt.Fprintf(ctx.Log,
-			"successfully updated function to version %s (sha256: %s)\n",
+		if deferConfig {
+			progress.start(stepPublish)
+			config, err = cmd.publishWithConfig(ctx, api, resp, desired, vars)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		ctx.Infof("successfully updated function to version %s (sha256: %s)",
 			*config.Version, *config.CodeSha256)
 
 		// Store the version so that it can be used by the alias "tagging"
 		version = config.Version
 
-		resp.Version = concourse.ResourceVersion{
-			"version": *config.Version,
+		if err := ctx.File("version", []byte(*version)); err != nil {
+			return nil, errors.Wrap(err,
+				"failed to persist function configuration")
 		}
 
-		if err := ctx.File("version", []byte(*version)); err != nil {
+		if err := ctx.JSON("function.json", config); err != nil {
 			return nil, errors.Wrap(err,
 				"failed to persist function configuration")
 		}
 
 		// Add some nice-to-have metadata
+		deployed = config
+
 		resp.AddMeta("arn", *config.FunctionArn)
 		resp.AddMeta("runtime", *config.Runtime)
 		resp.AddMeta("timeout", strconv.FormatInt(*config.Timeout, 10))
 		resp.AddMeta("memory", strconv.FormatInt(*config.MemorySize, 10))
+		resp.AddMeta("code_size", formatSize(uint64(len(data))))
+		resp.AddMeta("files", strconv.Itoa(fileCount))
+		resp.AddMeta("upload_duration", uploadDuration.Round(time.Millisecond).String())
+		resp.AddMeta("code_sha256", *config.CodeSha256)
+		resp.AddMeta("last_modified", aws.StringValue(config.LastModified))
+		if err := resp.AddMetaURL("console", functionConsoleURL(
+			cmd.Source.RegionName, cmd.Source.FunctionName, *config.Version,
+		)); err != nil {
+			return resp, err
+		}
+
+		if cmd.Params.TraceTags {
+			progress.start(stepTraceTags)
+			tags, err := traceTags(ctx.BuildMetadata(), *config.Version, cmd.Params.RefFile)
+			if err != nil {
+				return resp, err
+			}
+			if err := tagFunction(ctx, api, config, tags); err != nil {
+				return resp, err
+			}
+			for _, key := range sortedKeys(tags) {
+				resp.AddMeta(key, tags[key])
+			}
+		}
+	}
+
+	if cmd.Params.FailOnNoChanges && len(configChanges) == 0 &&
+		!hasCodePayload(cmd.Params) {
+		if err := failOnNoChanges(
+			ctx, api, cmd.Source.FunctionName, cmd.Params.Alias, version, nil,
+		); err != nil {
+			return nil, err
+		}
 	}
 
 	// Tag the version with an alias
+	var aliasConfig *lambda.AliasConfiguration
 	if cmd.Params.Alias != nil && version != nil {
-
-		aliasConfig, err := api.UpdateAlias(&lambda.UpdateAliasInput{
-			FunctionName:    &cmd.Source.FunctionName,
-			FunctionVersion: version,
-			Name:            cmd.Params.Alias,
-		})
+		progress.start(stepAlias)
+		var err error
+		aliasConfig, err = updateAlias(
+			ctx, ctx.Logger, api, cmd.Source.FunctionName, *cmd.Params.Alias, *version,
+		)
 		if err != nil {
 			return resp, errors.Wrapf(err, "failed to set alias %q for the version %q",
 				*cmd.Params.Alias, *version)
 		}
 
-		fmt.Fprintf(ctx.Log,
-			"successfully set the alias %s to version %s\n",
+		ctx.Infof("successfully set the alias %s to version %s",
 			*aliasConfig.Name, *aliasConfig.FunctionVersion)
+		if err := resp.AddMetaURL("alias_console", aliasConsoleURL(
+			cmd.Source.RegionName, cmd.Source.FunctionName, *aliasConfig.Name,
+		)); err != nil {
+			return resp, err
+		}
 
-		if resp.Version == nil {
-			resp.Version = concourse.ResourceVersion{
-				"alias":   *cmd.Params.Alias,
-				"version": *version,
+		if deployed == nil {
+			config, err := api.GetFunctionConfigurationWithContext(ctx, &lambda.GetFunctionConfigurationInput{
+				FunctionName: &cmd.Source.FunctionName,
+				Qualifier:    version,
+			})
+			if err != nil {
+				return resp, errors.Wrap(err, "failed to get function configuration")
 			}
-		} else {
-			resp.Version["alias"] = *cmd.Params.Alias
+			if err := ctx.JSON("function.json", config); err != nil {
+				return resp, errors.Wrap(err,
+					"failed to persist function configuration")
+			}
+			deployed = config
+		}
+	}
+
+	if deployed != nil {
+		putVersion, err := cmd.putVersion(ctx, api, deployed, aliasConfig)
+		if err != nil {
+			return resp, err
+		}
+		resp.Version = putVersion
+		if cmd.Params.RegionName != nil {
+			// The get after the put reads the function from the same region
+			resp.Version["region"] = *cmd.Params.RegionName
+		}
+	}
+
+	if deployed != nil && deployed.Runtime != nil {
+		if err := checkRuntime(
+			ctx.Logger, *deployed.Runtime, time.Now(),
+			cmd.Params.FailOnDeprecatedRuntime,
+		); err != nil {
+			return resp, err
+		}
+	}
+
+	return resp, nil
+}
+
+// putVersion returns the version of the put in the format that check
+// emits it, so that Concourse doesn't record the same version twice.
+func (cmd *OutCommand) putVersion(
+	ctx context.Context, api LambdaAPI, config *lambda.FunctionConfiguration,
+	moved *lambda.AliasConfiguration,
+) (concourse.ResourceVersion, error) {
+	if key, value := liveTrack(aws.StringValue(cmd.Source.Track)); key != "" {
+		check := &CheckCommand{Source: cmd.Source}
+		versions, err := check.checkLive(ctx, api, key, value)
+		if err != nil {
+			return nil, err
+		}
+		return versions[0], nil
+	}
+
+	if tracked := cmd.Source.Alias; tracked != nil {
+		// The revision and routing of the alias are part of the version
+		alias := moved
+		if alias == nil || aws.StringValue(cmd.Params.Alias) != *tracked {
+			current, err := api.GetAliasWithContext(ctx, &lambda.GetAliasInput{
+				FunctionName: &cmd.Source.FunctionName,
+				Name:         tracked,
+			})
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to get the alias %q", *tracked)
+			}
+			alias = current
+		}
+
+		if aws.StringValue(alias.FunctionVersion) != aws.StringValue(config.Version) {
+			current, err := api.GetFunctionConfigurationWithContext(ctx, &lambda.GetFunctionConfigurationInput{
+				FunctionName: &cmd.Source.FunctionName,
+				Qualifier:    alias.FunctionVersion,
+			})
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to get function configuration")
+			}
+			config = current
 		}
+
+		return aliasVersion(*tracked, alias, config), nil
+	}
+
+	version := publishedVersion(config)
+	if cmd.Source.Aliases != nil && cmd.Params.Alias != nil {
+		// The get of resources that track multiple aliases reads the
+		// alias that moved from the version.
+		version["alias"] = *cmd.Params.Alias
+	}
+	return version, nil
+}
+
+// steps returns the steps of the put that change the function
+func (cmd *OutCommand) steps(config, deferConfig bool) []string {
+	var steps []string
+	if config && !deferConfig {
+		steps = append(steps, stepConfig)
+	}
+	if cmd.Params.RuntimeManagement != nil {
+		steps = append(steps, stepRuntimeManagement)
+	}
+	if cmd.Params.RecursiveLoop != nil {
+		steps = append(steps, stepRecursiveLoop)
+	}
+	if hasCodePayload(cmd.Params) {
+		steps = append(steps, stepPackage, stepUpload)
+		if cmd.Params.StackKeyParameter != nil {
+			steps = append(steps, stepStack, stepPublish)
+		} else if deferConfig || cmd.Params.TraceTags {
+			steps = append(steps, stepPublish)
+		}
+		if cmd.Params.TraceTags {
+			steps = append(steps, stepTraceTags)
+		}
+	}
+	if cmd.Params.Alias != nil {
+		steps = append(steps, stepAlias)
+	}
+	return steps
+}
+
+// applyConfig applies the desired configuration to the function and
+// reports the changes.
+func (cmd *OutCommand) applyConfig(
+	ctx *concourse.CommandContext, api LambdaAPI,
+	resp *concourse.CommandResponse, desired *FunctionConfig,
+) ([]string, error) {
+	changes, err := syncFunctionConfig(
+		ctx, ctx.Logger, api, cmd.Source.FunctionName, desired, cmd.Params.FailOnDrift,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(changes) == 0 {
+		ctx.Infof("function configuration is up to date")
+		return nil, nil
+	}
+
+	resp.AddMetaLines("config_drift", changes...)
+
+	ctx.Infof("successfully updated function configuration:")
+	for _, change := range changes {
+		ctx.Infof("  %s", change)
+	}
+
+	return changes, nil
+}
+
+// publishWithConfig applies a configuration that references the ${VERSION}
+// that is about to be published and then publishes the uploaded code. The
+// version number is predicted from the already published versions, the put
+// fails if the published version got another number, f.ex. because the
+// latest version was deleted or another publish got in between.
+func (cmd *OutCommand) publishWithConfig(
+	ctx *concourse.CommandContext, api LambdaAPI,
+	resp *concourse.CommandResponse, desired *FunctionConfig,
+	vars map[string]string,
+) (*lambda.FunctionConfiguration, error) {
+	name := cmd.Source.FunctionName
+
+	if err := api.WaitUntilFunctionUpdatedWithContext(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: &name,
+	}); err != nil {
+		return nil, errors.Wrap(err, "failed to wait for the code update")
+	}
+
+	next, err := predictNextVersion(ctx, api, name)
+	if err != nil {
+		return nil, err
+	}
+	vars["VERSION"] = next
+
+	if _, err := cmd.applyConfig(ctx, api, resp, desired.Interpolate(vars)); err != nil {
+		return nil, err
+	}
+
+	description, err := cmd.versionDescription(ctx, api)
+	if err != nil {
+		return nil, err
+	}
+
+	var config *lambda.FunctionConfiguration
+	err = conflictRetry.Do(ctx, ctx.Logger, "publish", func() error {
+		var err error
+		config, err = api.PublishVersionWithContext(ctx, &lambda.PublishVersionInput{
+			FunctionName: &name,
+			Description:  description,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to publish version")
+	}
+
+	if *config.Version != next {
+		return nil, fmt.Errorf(
+			"${VERSION} was set to %s, but version %s was published with that configuration",
+			next, *config.Version)
+	}
+
+	return config, nil
+}
+
+// publishTraced publishes the uploaded code with the trace tags of the put
+// recorded in the version description.
+func (cmd *OutCommand) publishTraced(
+	ctx *concourse.CommandContext, api LambdaAPI, codeSha256 *string,
+) (*lambda.FunctionConfiguration, error) {
+	name := cmd.Source.FunctionName
+
+	if err := api.WaitUntilFunctionUpdatedWithContext(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: &name,
+	}); err != nil {
+		return nil, errors.Wrap(err, "failed to wait for the code update")
+	}
+
+	description, err := cmd.versionDescription(ctx, api)
+	if err != nil {
+		return nil, err
+	}
+
+	var config *lambda.FunctionConfiguration
+	err = conflictRetry.Do(ctx, ctx.Logger, "publish", func() error {
+		var err error
+		config, err = api.PublishVersionWithContext(ctx, &lambda.PublishVersionInput{
+			FunctionName: &name,
+			CodeSha256:   codeSha256,
+			Description:  description,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to publish version")
+	}
+	return config, nil
+}
+
+// versionDescription returns the description to publish a version with
+// when trace_tags is set: the description of the function followed by the
+// trace tags, so that check can filter every traced version. Returns nil
+// otherwise, which keeps the description of the function.
+func (cmd *OutCommand) versionDescription(
+	ctx *concourse.CommandContext, api LambdaAPI,
+) (*string, error) {
+	if !cmd.Params.TraceTags {
+		return nil, nil
+	}
+
+	tags, err := traceTags(ctx.BuildMetadata(), "", cmd.Params.RefFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := api.GetFunctionConfigurationWithContext(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: &cmd.Source.FunctionName,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the function description")
+	}
+
+	description, err := traceDescription(ctx.Logger, aws.StringValue(config.Description), tags)
+	if err != nil {
+		return nil, err
+	}
+	return &description, nil
+}
+
+// deployStack uploads the package to S3, points the stack that manages the
+// function at it, and publishes the updated code. Returns the published
+// version and how long the upload to S3 took.
+func (cmd *OutCommand) deployStack(
+	ctx *concourse.CommandContext, api LambdaAPI, progress *putProgress, data []byte,
+) (*lambda.FunctionConfiguration, time.Duration, error) {
+	name := cmd.Source.FunctionName
+	stacks := stackAPI(cmd.Stacks, cmd.Source)
+
+	deploy := &stackDeploy{
+		api:          stacks,
+		log:          ctx.Logger,
+		Bucket:       *cmd.Params.StackBucket,
+		KeyParameter: *cmd.Params.StackKeyParameter,
+		KeyPrefix:    aws.StringValue(cmd.Params.StackKeyPrefix),
+	}
+	if cmd.Params.StackName != nil {
+		deploy.StackName = *cmd.Params.StackName
+	} else {
+		stackName, err := findStack(ctx, stacks, name)
+		if err != nil {
+			return nil, 0, err
+		}
+		deploy.StackName = stackName
+	}
+
+	uploadStart := time.Now()
+	key, err := deploy.upload(ctx, name, data)
+	if err != nil {
+		return nil, 0, err
+	}
+	uploadDuration := time.Since(uploadStart)
+
+	progress.start(stepStack)
+	if _, err := deploy.update(ctx, key); err != nil {
+		return nil, 0, err
+	}
+
+	progress.start(stepPublish)
+	if err := api.WaitUntilFunctionUpdatedWithContext(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: &name,
+	}); err != nil {
+		return nil, 0, errors.Wrap(err, "failed to wait for the stack to update the function")
+	}
+
+	description, err := cmd.versionDescription(ctx, api)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Publishing fails if the stack didn't deploy the package
+	sum := sha256.Sum256(data)
+	var config *lambda.FunctionConfiguration
+	err = conflictRetry.Do(ctx, ctx.Logger, "publish", func() error {
+		var err error
+		config, err = api.PublishVersionWithContext(ctx, &lambda.PublishVersionInput{
+			FunctionName: &name,
+			CodeSha256:   aws.String(base64.StdEncoding.EncodeToString(sum[:])),
+			Description:  description,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to publish version")
+	}
+
+	return config, uploadDuration, nil
+}
+
+// predictNextVersion returns the version number that the next published
+// version of the function will most likely get.
+func predictNextVersion(
+	ctx context.Context, api LambdaAPI, functionName string,
+) (string, error) {
+	latest := 0
+	err := api.ListVersionsByFunctionPagesWithContext(ctx, &lambda.ListVersionsByFunctionInput{
+		FunctionName: &functionName,
+	}, func(page *lambda.ListVersionsByFunctionOutput, last bool) bool {
+		for _, v := range page.Versions {
+			if n, err := strconv.Atoi(aws.StringValue(v.Version)); err == nil && n > latest {
+				latest = n
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to list versions")
+	}
+	return strconv.Itoa(latest + 1), nil
+}
+
+// delete deletes the function or an alias. Functions and aliases that
+// don't exist are ignored.
+func (cmd *OutCommand) delete(
+	ctx *concourse.CommandContext, api LambdaAPI,
+) (*concourse.CommandResponse, error) {
+	resp := &concourse.CommandResponse{
+		Version: concourse.ResourceVersion{
+			"timestamp": strconv.FormatInt(time.Now().Unix(), 10),
+		},
+	}
+
+	if cmd.Params.DeleteAlias != nil {
+		_, err := api.DeleteAliasWithContext(ctx, &lambda.DeleteAliasInput{
+			FunctionName: &cmd.Source.FunctionName,
+			Name:         cmd.Params.DeleteAlias,
+		})
+		switch {
+		case isNotFound(err):
+			ctx.Warnf("the alias %s doesn't exist", *cmd.Params.DeleteAlias)
+		case err != nil:
+			return nil, errors.Wrapf(err,
+				"failed to delete the alias %q", *cmd.Params.DeleteAlias)
+		default:
+			ctx.Infof("successfully deleted the alias %s",
+				*cmd.Params.DeleteAlias)
+		}
+		resp.AddMeta("deleted_alias", *cmd.Params.DeleteAlias)
+	}
+
+	if cmd.Params.Delete {
+		_, err := api.DeleteFunctionWithContext(ctx, &lambda.DeleteFunctionInput{
+			FunctionName: &cmd.Source.FunctionName,
+		})
+		switch {
+		case isNotFound(err):
+			ctx.Warnf("the function %s doesn't exist", cmd.Source.FunctionName)
+		case err != nil:
+			return nil, errors.Wrap(err, "failed to delete the function")
+		default:
+			ctx.Infof("successfully deleted the function %s",
+				cmd.Source.FunctionName)
+		}
+		resp.AddMeta("deleted_function", cmd.Source.FunctionName)
 	}
 
 	return resp, nil
 }
 
+// failOnNoChanges returns an error if uploading the code and tagging the
+// alias with the version wouldn't change anything.
+func failOnNoChanges(
+	ctx context.Context, api LambdaAPI,
+	functionName string, alias, version *string, data []byte,
+) error {
+	if data != nil {
+		sum := sha256.Sum256(data)
+		codeSha := base64.StdEncoding.EncodeToString(sum[:])
+
+		// The alias (or $LATEST if we're not tagging an alias) already
+		// runs the code if the sha256 matches.
+		live, err := api.GetFunctionConfigurationWithContext(ctx, &lambda.GetFunctionConfigurationInput{
+			FunctionName: &functionName,
+			Qualifier:    alias,
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to get function configuration")
+		}
+		if aws.StringValue(live.CodeSha256) != codeSha {
+			return nil
+		}
+		return fmt.Errorf("no changes: the code (sha256: %s) is already deployed", codeSha)
+	}
+
+	if alias != nil && version != nil {
+		current, err := api.GetAliasWithContext(ctx, &lambda.GetAliasInput{
+			FunctionName: &functionName,
+			Name:         alias,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to get the alias %q", *alias)
+		}
+		if aws.StringValue(current.FunctionVersion) != *version {
+			return nil
+		}
+		return fmt.Errorf("no changes: %s already points to version %s", *alias, *version)
+	}
+
+	return errors.New("no changes: there's no code, configuration or alias to update")
+}
+
+// readPathFile reads a path from a file. Relative paths are resolved
+// relative to the directory of the file.
+func readPathFile(name string) (string, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read path file %q", name)
+	}
+
+	p := string(bytes.TrimSpace(data))
+	if len(p) == 0 {
+		return "", fmt.Errorf("the path file %q is empty", name)
+	}
+
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(filepath.Dir(name), p)
+	}
+	return p, nil
+}
+
+// resolveSAMFunction loads the function from the SAM template and uses its
+// CodeUri as the code directory or zip file.
+func (cmd *OutCommand) resolveSAMFunction(ctx *concourse.CommandContext) (*SAMFunction, error) {
+	fn, err := LoadSAMFunction(*cmd.Params.SAMTemplate, cmd.Params.SAMFunction)
+	if err != nil {
+		return nil, concourse.ConfigError(err)
+	}
+
+	codeURI := fn.CodeURI
+	if fn.IsDirectory() {
+		cmd.Params.CodeDirectory = &codeURI
+	} else {
+		cmd.Params.ZipFile = &codeURI
+		if err := validateCodeParams(cmd.Params); err != nil {
+			return nil, concourse.ConfigError(errors.Wrapf(err,
+				"the CodeUri of the SAM function %s is a zip file", fn.LogicalID))
+		}
+	}
+
+	ctx.Infof("deploying the SAM function %s from %s", fn.LogicalID, codeURI)
+	if cmd.Params.SAMConfig && len(fn.Unmanaged) > 0 {
+		ctx.Warnf("the properties %s of the SAM function %s use intrinsic functions and aren't managed",
+			strings.Join(fn.Unmanaged, ", "), fn.LogicalID)
+	}
+
+	return fn, nil
+}
+
 func hasCodePayload(p PutParams) bool {
 	return p.ZipFile != nil ||
 		p.CodeDirectory != nil ||
 		p.CodeFile != nil
 }
 
-func codePayload(p PutParams) ([]byte, error) {
+// validateVersion checks that a version is a version number
+func validateVersion(version string) error {
+	if len(version) == 0 {
+		return errors.New("empty version string")
+	}
+	if _, err := strconv.Atoi(version); err != nil {
+		return errors.Wrapf(err, "%q is not a valid version integer", version)
+	}
+	return nil
+}
+
+// validateCodeParams checks that the packaging params are used together
+// with the kind of code payload they apply to.
+func validateCodeParams(p PutParams) error {
+	zipFile := p.ZipFile != nil || p.ZipFileFile != nil
+	codeDir := p.CodeDirectory != nil || p.CodeDirectoryFile != nil ||
+		(p.SAMTemplate != nil && p.ZipFile == nil)
+
+	if p.BeforePackage != nil && !codeDir {
+		return errors.New("before_package can only be used together with code_dir")
+	}
+	if p.ChecksumFile != nil && !zipFile {
+		return errors.New("checksum_file can only be used together with zip_file")
+	}
+	if p.PipRequirements != nil && !codeDir {
+		return errors.New("pip_requirements can only be used together with code_dir")
+	}
+	if p.NpmInstall != nil {
+		if !codeDir {
+			return errors.New("npm_install can only be used together with code_dir")
+		}
+		if *p.NpmInstall != NpmInstallProduction {
+			return fmt.Errorf("unsupported npm_install mode %q", *p.NpmInstall)
+		}
+	}
+	return nil
+}
+
+// packageRuntime returns the runtime that the code is packaged for, the
+// one in the desired configuration or else the one of the function.
+func packageRuntime(
+	ctx context.Context, api LambdaAPI, functionName string, desired *FunctionConfig,
+) (string, error) {
+	if desired != nil && desired.Runtime != nil {
+		return *desired.Runtime, nil
+	}
+
+	config, err := api.GetFunctionConfigurationWithContext(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: &functionName,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get the runtime of the function")
+	}
+	return aws.StringValue(config.Runtime), nil
+}
+
+func codePayload(
+	ctx *concourse.CommandContext, p PutParams, runtime string,
+) ([]byte, error) {
 	if p.ZipFile != nil {
 		data, err := ioutil.ReadFile(*p.ZipFile)
 		if err != nil {
@@ -170,6 +1215,36 @@ func codePayload(p PutParams) ([]byte, error) {
 			)
 		}
 
+		if needsStaging(p) {
+			stagingDir, err := stageDirectory(dirPath)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to stage code directory")
+			}
+			defer os.RemoveAll(stagingDir)
+
+			dirPath = stagingDir
+		}
+
+		if p.NpmInstall != nil {
+			if err := npmInstallProduction(ctx, dirPath); err != nil {
+				return nil, errors.Wrap(err, "failed to install node dependencies")
+			}
+		}
+
+		if p.PipRequirements != nil {
+			if err := pipInstall(
+				ctx, dirPath, *p.PipRequirements, p.PipPlatform, runtime,
+			); err != nil {
+				return nil, errors.Wrap(err, "failed to install python dependencies")
+			}
+		}
+
+		if p.BeforePackage != nil {
+			if err := runPackageCommand(ctx, dirPath, *p.BeforePackage); err != nil {
+				return nil, errors.Wrap(err, "before_package command failed")
+			}
+		}
+
 		var buf bytes.Buffer
 		w := zip.NewWriter(&buf)
 		if err := zipRecurse(w, dirPath, "", rootInfo); err != nil {
@@ -195,6 +1270,15 @@ func codePayload(p PutParams) ([]byte, error) {
 	return nil, nil
 }
 
+// countFiles counts the files in a zip archive
+func countFiles(archive []byte) (int, error) {
+	r, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read code package")
+	}
+	return len(r.File), nil
+}
+
 func zipRecurse(
 	w *zip.Writer, dirPath string, archivePath string, directory os.FileInfo,
 ) error {
@@ -0,0 +1,194 @@
+package resource
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+	"github.com/pkg/errors"
+)
+
+// NpmInstallProduction installs production dependencies only
+const NpmInstallProduction = "production"
+
+var (
+	// pipCommand is the pip executable used to install python dependencies
+	pipCommand = "pip3"
+	// npmCommand is the npm executable used to install node dependencies
+	npmCommand = "npm"
+)
+
+// PackageCommand is a command that is run as a step when packaging the
+// function code.
+type PackageCommand struct {
+	// Command is the executable to run
+	Command string `json:"command"`
+	// Args are the arguments passed to the command
+	Args []string `json:"args"`
+}
+
+// String returns the command line that the command represents
+func (pc PackageCommand) String() string {
+	return strings.Join(append([]string{pc.Command}, pc.Args...), " ")
+}
+
+func runPackageCommand(
+	ctx *concourse.CommandContext, dir string, pc PackageCommand,
+) error {
+	if pc.Command == "" {
+		return errors.New("no command specified")
+	}
+
+	ctx.Infof("running %q in %s", pc.String(), dir)
+
+	cmd := exec.CommandContext(ctx, pc.Command, pc.Args...)
+	cmd.Dir = dir
+	cmd.Env = os.Environ()
+	cmd.Stdout = ctx.Log
+	cmd.Stderr = ctx.Log
+
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "failed to run %q", pc.String())
+	}
+	return nil
+}
+
+// needsStaging checks if the packaging steps modify the code directory, in
+// which case the directory should be copied before packaging.
+func needsStaging(p PutParams) bool {
+	return p.PipRequirements != nil || p.NpmInstall != nil
+}
+
+// npmInstallProduction makes sure that only production dependencies are
+// present in the directory. Already installed dependencies are pruned,
+// otherwise a production install is made.
+func npmInstallProduction(ctx *concourse.CommandContext, dir string) error {
+	install := PackageCommand{
+		Command: npmCommand,
+		Args:    []string{"install", "--omit=dev"},
+	}
+
+	if fileExists(filepath.Join(dir, "node_modules")) {
+		install.Args = []string{"prune", "--omit=dev"}
+	} else if fileExists(filepath.Join(dir, "package-lock.json")) {
+		install.Args = []string{"ci", "--omit=dev"}
+	}
+
+	return runPackageCommand(ctx, dir, install)
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// pipPythonVersion returns the Python version of a runtime, f.ex. "3.12"
+// for "python3.12", and false if it isn't a Python runtime.
+func pipPythonVersion(runtime string) (string, bool) {
+	version := strings.TrimPrefix(runtime, "python")
+	if version == runtime || version == "" {
+		return "", false
+	}
+	return version, true
+}
+
+// pipInstall installs the requirements into the directory. Wheels are
+// selected for the Python version of the runtime, which is why only binary
+// packages can be installed for Python runtimes.
+func pipInstall(
+	ctx *concourse.CommandContext, dir string, requirements string,
+	platform *string, runtime string,
+) error {
+	reqPath, err := filepath.Abs(requirements)
+	if err != nil {
+		return errors.Wrapf(
+			err, "failed to resolve absolute path for %q", requirements,
+		)
+	}
+
+	install := PackageCommand{
+		Command: pipCommand,
+		Args:    []string{"install", "-r", reqPath, "--target", dir},
+	}
+	version, python := pipPythonVersion(runtime)
+	if platform != nil {
+		install.Args = append(install.Args, "--platform", *platform)
+	}
+	if python {
+		install.Args = append(install.Args,
+			"--python-version", version, "--implementation", "cp",
+		)
+	}
+	if platform != nil || python {
+		install.Args = append(install.Args, "--only-binary=:all:")
+	}
+
+	return runPackageCommand(ctx, dir, install)
+}
+
+// stageDirectory copies a directory to a new temporary directory and
+// returns its path. The caller is responsible for removing it.
+func stageDirectory(dir string) (string, error) {
+	stagingDir, err := ioutil.TempDir("", "lambda-package")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create staging directory")
+	}
+
+	if err := copyDirectory(dir, stagingDir); err != nil {
+		_ = os.RemoveAll(stagingDir)
+		return "", err
+	}
+
+	return stagingDir, nil
+}
+
+func copyDirectory(src, dst string) error {
+	return filepath.Walk(src, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, name)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(name)
+			if err != nil {
+				return errors.Wrapf(err, "failed to read link %q", name)
+			}
+			return os.Symlink(link, target)
+		default:
+			return copyFile(name, target, info.Mode().Perm())
+		}
+	})
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %q", src)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create %q", dst)
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return errors.Wrapf(err, "failed to copy %q", src)
+	}
+
+	return errors.Wrapf(out.Close(), "failed to write %q", dst)
+}
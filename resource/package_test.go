@@ -0,0 +1,20 @@
+package resource
+
+import "testing"
+
+func TestPipPythonVersion(t *testing.T) {
+	tests := map[string]string{
+		"python3.12":   "3.12",
+		"python3.9":    "3.9",
+		"nodejs20.x":   "",
+		"provided.al2": "",
+		"python":       "",
+		"":             "",
+	}
+	for runtime, want := range tests {
+		got, ok := pipPythonVersion(runtime)
+		if got != want || ok != (want != "") {
+			t.Errorf("pipPythonVersion(%q) = %q, %v, want %q", runtime, got, ok, want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package resource
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Default poll settings
+const (
+	defaultPollInterval = 10 * time.Second
+	defaultPollTimeout  = 5 * time.Minute
+)
+
+// Poll configures repeated invocations until the result meets a condition
+type Poll struct {
+	// PollUntil is the condition that the invocation result should meet
+	PollUntil *Expectation `json:"poll_until"`
+	// PollInterval is the delay between invocations, f.ex. "10s"
+	PollInterval *string `json:"poll_interval"`
+	// PollTimeout is how long to keep polling, f.ex. "5m"
+	PollTimeout *string `json:"poll_timeout"`
+}
+
+// IsPoll checks if the function should be polled
+func (p *Poll) IsPoll() bool {
+	return p.PollUntil != nil
+}
+
+// validatePoll checks the poll parameters
+func (p *Poll) validatePoll() error {
+	if !p.IsPoll() {
+		if p.PollInterval != nil || p.PollTimeout != nil {
+			return errors.New("poll_interval and poll_timeout can only be used together with poll_until")
+		}
+		return nil
+	}
+	if _, err := p.interval(); err != nil {
+		return err
+	}
+	if _, err := p.timeout(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *Poll) interval() (time.Duration, error) {
+	return parseDurationParam("poll_interval", p.PollInterval, defaultPollInterval)
+}
+
+func (p *Poll) timeout() (time.Duration, error) {
+	return parseDurationParam("poll_timeout", p.PollTimeout, defaultPollTimeout)
+}
+
+// parseDurationParam parses an optional duration param, which must be
+// positive when it's set.
+func parseDurationParam(
+	name string, value *string, defaultValue time.Duration,
+) (time.Duration, error) {
+	if value == nil {
+		return defaultValue, nil
+	}
+
+	d, err := time.ParseDuration(*value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid %s %q", name, *value)
+	}
+	if d <= 0 {
+		return 0, errors.Errorf("invalid %s %q, it must be positive", name, *value)
+	}
+	return d, nil
+}
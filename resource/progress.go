@@ -0,0 +1,67 @@
+package resource
+
+import (
+	"strings"
+
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+)
+
+// The steps of a put that change the function
+const (
+	stepConfig            = "updating the configuration"
+	stepRuntimeManagement = "setting runtime management"
+	stepRecursiveLoop     = "setting recursive loop detection"
+	stepPackage           = "packaging the code"
+	stepUpload            = "uploading the code"
+	stepStack             = "updating the stack"
+	stepPublish           = "publishing the version"
+	stepTraceTags         = "tagging the function"
+	stepAlias             = "updating the alias"
+)
+
+// putProgress tracks the steps of a put, so that an aborted put can tell
+// which changes were made before it was interrupted.
+type putProgress struct {
+	steps   []string
+	current int
+}
+
+func newPutProgress(steps []string) *putProgress {
+	return &putProgress{steps: steps, current: -1}
+}
+
+// start marks the step as in progress, and the steps before it as done
+func (p *putProgress) start(step string) {
+	for i, s := range p.steps {
+		if s == step {
+			p.current = i
+			return
+		}
+	}
+}
+
+// currentStep returns the step in progress, or an empty string if no step
+// has been started.
+func (p *putProgress) currentStep() string {
+	if p.current < 0 {
+		return ""
+	}
+	return p.steps[p.current]
+}
+
+// logAborted logs the step that was interrupted, and which of the other
+// steps were done and which weren't started.
+func (p *putProgress) logAborted(log *concourse.Logger) {
+	if p.current < 0 {
+		log.Warnf("the put was aborted before the function was changed")
+		return
+	}
+
+	log.Warnf("the put was aborted while %s", p.steps[p.current])
+	if done := p.steps[:p.current]; len(done) > 0 {
+		log.Warnf("done before the abort: %s", strings.Join(done, ", "))
+	}
+	if rest := p.steps[p.current+1:]; len(rest) > 0 {
+		log.Warnf("not done: %s", strings.Join(rest, ", "))
+	}
+}
@@ -0,0 +1,66 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/pkg/errors"
+)
+
+// The recursive loop detection API was added to Lambda after the vendored
+// SDK went into maintenance mode, so we define the operation ourselves.
+
+const (
+	// RecursiveLoopAllow allows the function to invoke itself recursively
+	RecursiveLoopAllow = "Allow"
+	// RecursiveLoopTerminate stops recursive loops that are detected
+	RecursiveLoopTerminate = "Terminate"
+)
+
+type putFunctionRecursionConfigInput struct {
+	_ struct{} `type:"structure"`
+
+	FunctionName *string `location:"uri" locationName:"FunctionName" min:"1" type:"string" required:"true"`
+
+	RecursiveLoop *string `type:"string" required:"true"`
+}
+
+type putFunctionRecursionConfigOutput struct {
+	_ struct{} `type:"structure"`
+
+	RecursiveLoop *string `type:"string"`
+}
+
+// validateRecursiveLoop checks that the mode is "Allow" or "Terminate"
+func validateRecursiveLoop(mode string) error {
+	if mode != RecursiveLoopAllow && mode != RecursiveLoopTerminate {
+		return fmt.Errorf("unsupported recursive_loop mode %q, use %q or %q",
+			mode, RecursiveLoopAllow, RecursiveLoopTerminate)
+	}
+	return nil
+}
+
+// putRecursiveLoop sets the recursive loop detection configuration of the
+// function.
+func putRecursiveLoop(
+	ctx context.Context, api LambdaAPI, functionName, mode string,
+) error {
+	if err := validateRecursiveLoop(mode); err != nil {
+		return err
+	}
+
+	op := &request.Operation{
+		Name:       "PutFunctionRecursionConfig",
+		HTTPMethod: "PUT",
+		HTTPPath:   "/2024-08-31/functions/{FunctionName}/recursion-config",
+	}
+	input := &putFunctionRecursionConfigInput{
+		FunctionName:  &functionName,
+		RecursiveLoop: &mode,
+	}
+
+	req := api.NewRequest(op, input, &putFunctionRecursionConfigOutput{})
+	req.SetContext(ctx)
+	return errors.Wrap(req.Send(), "failed to set recursive loop detection")
+}
@@ -0,0 +1,114 @@
+package resource
+
+import (
+	"context"
+	"time"
+
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// retryPolicy describes how failed operations are retried
+type retryPolicy struct {
+	// Attempts is the maximum number of attempts
+	Attempts int
+	// Backoff is the delay before the first retry, it's doubled for every
+	// following retry.
+	Backoff time.Duration
+	// Retryable checks if an error should be retried
+	Retryable func(err error) bool
+}
+
+// conflictRetry retries updates that fail because another update of the
+// function is in progress.
+var conflictRetry = retryPolicy{
+	Attempts:  6,
+	Backoff:   2 * time.Second,
+	Retryable: isConflict,
+}
+
+// invokeRetry retries invocations that are throttled or fail because of
+// transient errors. The number of attempts is set per invocation.
+var invokeRetry = retryPolicy{
+	Backoff:   time.Second,
+	Retryable: isTransient,
+}
+
+// throttleRetry retries control-plane calls that are throttled, f.ex. when
+// many resources check at the same time.
+var throttleRetry = retryPolicy{
+	Attempts:  5,
+	Backoff:   2 * time.Second,
+	Retryable: isThrottled,
+}
+
+// Do runs fn until it succeeds, fails with an error that can't be
+// retried, the attempts run out, or the context is cancelled.
+func (p retryPolicy) Do(
+	ctx context.Context, log *concourse.Logger, operation string, fn func() error,
+) error {
+	delay := p.Backoff
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.Attempts || !p.Retryable(err) {
+			return err
+		}
+
+		log.Warnf("%s failed (attempt %d of %d), retrying in %s: %s",
+			operation, attempt, p.Attempts, delay, err.Error())
+
+		if sleepContext(ctx, delay) != nil {
+			return err
+		}
+		delay *= 2
+	}
+}
+
+// sleepContext pauses for the duration, or returns the context error if
+// the context is cancelled before that.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func awsErrorCode(err error) string {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code()
+	}
+	return ""
+}
+
+func isConflict(err error) bool {
+	return awsErrorCode(err) == lambda.ErrCodeResourceConflictException
+}
+
+func isNotFound(err error) bool {
+	return awsErrorCode(err) == lambda.ErrCodeResourceNotFoundException
+}
+
+func isThrottled(err error) bool {
+	return awsErrorCode(err) == lambda.ErrCodeTooManyRequestsException
+}
+
+// isTransient checks if an error is caused by throttling, a server side
+// error, or a function that isn't ready yet.
+func isTransient(err error) bool {
+	switch awsErrorCode(err) {
+	case lambda.ErrCodeTooManyRequestsException,
+		lambda.ErrCodeResourceNotReadyException:
+		return true
+	}
+	if rerr, ok := err.(awserr.RequestFailure); ok {
+		return rerr.StatusCode() >= 500
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package resource
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+)
+
+// runtimeWarningPeriod is how long before the deprecation date that
+// warnings start being logged.
+const runtimeWarningPeriod = 90 * 24 * time.Hour
+
+// runtimeDeprecations lists the dates when AWS deprecates Lambda runtimes,
+// from https://docs.aws.amazon.com/lambda/latest/dg/lambda-runtimes.html.
+// AWS moves the dates from time to time, so check the table against the
+// documentation when it's updated. Last updated on 2026-10-16.
+var runtimeDeprecations = map[string]string{
+	"nodejs":          "2016-10-31",
+	"nodejs4.3":       "2020-03-05",
+	"nodejs4.3-edge":  "2019-04-30",
+	"nodejs6.10":      "2019-08-12",
+	"nodejs8.10":      "2020-03-06",
+	"nodejs10.x":      "2021-07-30",
+	"nodejs12.x":      "2023-03-31",
+	"nodejs14.x":      "2023-12-04",
+	"nodejs16.x":      "2024-06-12",
+	"nodejs18.x":      "2025-09-01",
+	"nodejs20.x":      "2026-04-30",
+	"nodejs22.x":      "2027-04-30",
+	"python2.7":       "2021-07-15",
+	"python3.6":       "2022-07-18",
+	"python3.7":       "2023-12-04",
+	"python3.8":       "2024-10-14",
+	"python3.9":       "2025-12-15",
+	"python3.10":      "2026-06-30",
+	"python3.11":      "2026-06-30",
+	"python3.12":      "2028-10-31",
+	"python3.13":      "2029-06-30",
+	"ruby2.5":         "2021-07-30",
+	"ruby2.7":         "2023-12-07",
+	"ruby3.2":         "2026-03-31",
+	"ruby3.3":         "2027-03-31",
+	"ruby3.4":         "2028-03-31",
+	"java8":           "2024-01-08",
+	"java8.al2":       "2026-06-30",
+	"java11":          "2026-06-30",
+	"java17":          "2026-06-30",
+	"java21":          "2029-06-30",
+	"go1.x":           "2024-01-08",
+	"provided":        "2024-01-08",
+	"provided.al2":    "2026-06-30",
+	"provided.al2023": "2029-06-30",
+	"dotnetcore1.0":   "2019-07-30",
+	"dotnetcore2.0":   "2019-05-30",
+	"dotnetcore2.1":   "2022-01-05",
+	"dotnetcore3.1":   "2023-04-03",
+	"dotnet5.0":       "2022-05-10",
+	"dotnet6":         "2024-12-20",
+	"dotnet7":         "2024-05-14",
+	"dotnet8":         "2026-11-10",
+}
+
+// RuntimeDeprecationError is returned when a function uses a deprecated
+// runtime.
+type RuntimeDeprecationError struct {
+	Runtime    string
+	Deprecated time.Time
+}
+
+// Error returns a description of the error
+func (rde RuntimeDeprecationError) Error() string {
+	return fmt.Sprintf("the runtime %s was deprecated on %s",
+		rde.Runtime, rde.Deprecated.Format("2006-01-02"))
+}
+
+// runtimeDeprecation returns the deprecation date of the runtime
+func runtimeDeprecation(runtime string) (time.Time, bool) {
+	date, ok := runtimeDeprecations[runtime]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// checkRuntime logs a warning if the runtime is deprecated, or will be
+// deprecated soon. If fail is set an error is returned for deprecated
+// runtimes.
+func checkRuntime(log *concourse.Logger, runtime string, now time.Time, fail bool) error {
+	deprecated, ok := runtimeDeprecation(runtime)
+	if !ok {
+		return nil
+	}
+
+	switch {
+	case !now.Before(deprecated):
+		err := RuntimeDeprecationError{Runtime: runtime, Deprecated: deprecated}
+		if fail {
+			return err
+		}
+		log.Warnf("%s, please upgrade the function", err.Error())
+	case deprecated.Sub(now) < runtimeWarningPeriod:
+		log.Warnf("the runtime %s will be deprecated on %s, please upgrade the function",
+			runtime, deprecated.Format("2006-01-02"))
+	}
+
+	return nil
+}
@@ -0,0 +1,22 @@
+package resource
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRuntimeDeprecations(t *testing.T) {
+	for runtime, date := range runtimeDeprecations {
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			t.Errorf("invalid deprecation date %q of %s: %v", date, runtime, err)
+		}
+	}
+
+	deprecated, ok := runtimeDeprecation("provided.al2")
+	if !ok || deprecated.Format("2006-01-02") != "2026-06-30" {
+		t.Errorf("runtimeDeprecation(provided.al2) = %v, %v", deprecated, ok)
+	}
+	if _, ok := runtimeDeprecation("nodejs99.x"); ok {
+		t.Error("found a deprecation date for an unknown runtime")
+	}
+}
@@ -0,0 +1,373 @@
+package resource
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// samFunctionType is the resource type of SAM functions
+const samFunctionType = "AWS::Serverless::Function"
+
+// SAMFunction is a function resource of an AWS SAM template, with the
+// properties from the Globals section applied.
+type SAMFunction struct {
+	// LogicalID is the logical ID of the resource in the template
+	LogicalID string
+	// CodeURI is the path of the code directory or zip file, relative to
+	// the working directory.
+	CodeURI string
+	// Config is the configuration from the properties of the function
+	Config *FunctionConfig
+	// Unmanaged is the properties that use intrinsic functions, like
+	// !Ref, and are left out of the configuration.
+	Unmanaged []string
+}
+
+// IsDirectory checks if the code URI is a directory
+func (f *SAMFunction) IsDirectory() bool {
+	info, err := os.Stat(f.CodeURI)
+	return err == nil && info.IsDir()
+}
+
+// samTemplate is the part of a SAM template that is used
+type samTemplate struct {
+	Globals struct {
+		Function map[string]interface{} `json:"Function"`
+	} `json:"Globals"`
+	Resources map[string]struct {
+		Type       string                 `json:"Type"`
+		Properties map[string]interface{} `json:"Properties"`
+	} `json:"Resources"`
+}
+
+// LoadSAMFunction reads a function from a SAM template. The logical ID
+// can be left out if the template has a single function.
+func LoadSAMFunction(template string, logicalID *string) (*SAMFunction, error) {
+	data, err := ioutil.ReadFile(template)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read SAM template %q", template)
+	}
+
+	var tmpl samTemplate
+	if err := unmarshalYAML(data, &tmpl); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse SAM template %q", template)
+	}
+
+	id, err := tmpl.functionID(logicalID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid SAM template %q", template)
+	}
+
+	intrinsics, err := intrinsicPaths(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse SAM template %q", template)
+	}
+	resourcePath := "Resources." + id + ".Properties"
+	properties := tmpl.Resources[id].Properties
+
+	// A property is set by an intrinsic function if it's one in the
+	// function, or if it's one in the globals that isn't replaced.
+	unmanaged := map[string]bool{}
+	for name := range properties {
+		if hasIntrinsic(intrinsics, resourcePath+"."+name) {
+			unmanaged[name] = true
+		}
+	}
+	for name, global := range tmpl.Globals.Function {
+		local, overridden := properties[name]
+		if overridden && !isCollection(local) && !isCollection(global) {
+			continue
+		}
+		if hasIntrinsic(intrinsics, "Globals.Function."+name) {
+			unmanaged[name] = true
+		}
+	}
+
+	props := mergeSAMProperties(tmpl.Globals.Function, properties)
+
+	if packageType, ok := props["PackageType"].(string); ok && packageType == "Image" {
+		return nil, errors.Errorf("the SAM function %s is an image function, which isn't supported", id)
+	}
+	if _, ok := props["InlineCode"]; ok {
+		return nil, errors.Errorf("the SAM function %s has inline code, which isn't supported", id)
+	}
+
+	codeURI, ok := props["CodeUri"].(string)
+	switch {
+	case !ok || unmanaged["CodeUri"]:
+		return nil, errors.Errorf(
+			"the SAM function %s must have a CodeUri with a local path", id)
+	case strings.HasPrefix(codeURI, "s3://"):
+		return nil, errors.Errorf(
+			"the CodeUri of the SAM function %s is on S3, only local paths are supported", id)
+	}
+	if !filepath.IsAbs(codeURI) {
+		codeURI = filepath.Join(filepath.Dir(template), codeURI)
+	}
+
+	fn := &SAMFunction{
+		LogicalID: id,
+		CodeURI:   codeURI,
+		Config:    &FunctionConfig{},
+	}
+	names := make([]string, 0, len(props))
+	for name := range props {
+		if samConfigProperty(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if unmanaged[name] || !fn.Config.setSAMProperty(name, props[name]) {
+			fn.Unmanaged = append(fn.Unmanaged, name)
+		}
+	}
+
+	return fn, nil
+}
+
+// functionID returns the logical ID of the function to deploy
+func (t *samTemplate) functionID(logicalID *string) (string, error) {
+	var functions []string
+	for id, resource := range t.Resources {
+		if resource.Type == samFunctionType {
+			functions = append(functions, id)
+		}
+	}
+	sort.Strings(functions)
+
+	if logicalID != nil {
+		resource, ok := t.Resources[*logicalID]
+		if !ok || resource.Type != samFunctionType {
+			return "", fmt.Errorf("there's no %s %q, the functions are: %s",
+				samFunctionType, *logicalID, strings.Join(functions, ", "))
+		}
+		return *logicalID, nil
+	}
+
+	switch len(functions) {
+	case 0:
+		return "", fmt.Errorf("there's no %s", samFunctionType)
+	case 1:
+		return functions[0], nil
+	}
+	return "", fmt.Errorf("sam_function is required to pick one of the functions: %s",
+		strings.Join(functions, ", "))
+}
+
+// mergeSAMProperties applies the global properties to the properties of a
+// function the way SAM does: maps are merged, lists are concatenated, and
+// other values of the function replace the global ones.
+func mergeSAMProperties(global, local map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(global)+len(local))
+	for name, value := range global {
+		merged[name] = value
+	}
+	for name, value := range local {
+		merged[name] = mergeSAMValue(merged[name], value)
+	}
+	return merged
+}
+
+func mergeSAMValue(global, local interface{}) interface{} {
+	switch l := local.(type) {
+	case map[string]interface{}:
+		if g, ok := global.(map[string]interface{}); ok {
+			return mergeSAMProperties(g, l)
+		}
+	case []interface{}:
+		if g, ok := global.([]interface{}); ok {
+			return append(append([]interface{}{}, g...), l...)
+		}
+	}
+	return local
+}
+
+func isCollection(v interface{}) bool {
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		return true
+	}
+	return false
+}
+
+// samConfigProperty checks if a property is part of the configuration
+func samConfigProperty(name string) bool {
+	switch name {
+	case "Description", "Handler", "Runtime", "Role", "MemorySize", "Timeout",
+		"EphemeralStorage", "Environment", "Layers", "Tracing":
+		return true
+	}
+	return false
+}
+
+// setSAMProperty sets the configuration field of a SAM property. Returns
+// false if the value isn't a literal of the expected type, f.ex. when it
+// uses the long form of an intrinsic function.
+func (fc *FunctionConfig) setSAMProperty(name string, value interface{}) bool {
+	switch name {
+	case "Description", "Handler", "Runtime", "Role", "Tracing":
+		s, ok := value.(string)
+		if !ok {
+			return false
+		}
+		switch name {
+		case "Description":
+			fc.Description = aws.String(s)
+		case "Handler":
+			fc.Handler = aws.String(s)
+		case "Runtime":
+			fc.Runtime = aws.String(s)
+		case "Role":
+			fc.Role = aws.String(s)
+		case "Tracing":
+			fc.Tracing = aws.String(s)
+		}
+	case "MemorySize", "Timeout":
+		n, ok := samInt(value)
+		if !ok {
+			return false
+		}
+		if name == "MemorySize" {
+			fc.MemorySize = aws.Int64(n)
+		} else {
+			fc.Timeout = aws.Int64(n)
+		}
+	case "EphemeralStorage":
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		n, ok := samInt(m["Size"])
+		if !ok {
+			return false
+		}
+		fc.EphemeralStorage = aws.Int64(n)
+	case "Environment":
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		vars, ok := m["Variables"].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		fc.Environment = make(Variables, len(vars))
+		for key, v := range vars {
+			switch v := v.(type) {
+			case string:
+				fc.Environment[key] = v
+			case bool, int, int64, float64:
+				fc.Environment[key] = fmt.Sprint(v)
+			default:
+				fc.Environment = nil
+				return false
+			}
+		}
+	case "Layers":
+		items, ok := value.([]interface{})
+		if !ok {
+			return false
+		}
+		layers := make([]string, 0, len(items))
+		for _, item := range items {
+			s, ok := item.(string)
+			if !ok {
+				return false
+			}
+			layers = append(layers, s)
+		}
+		fc.Layers = layers
+	default:
+		return false
+	}
+	return true
+}
+
+// samInt returns a whole number property value
+func samInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	case float64:
+		if n == float64(int64(n)) {
+			return int64(n), true
+		}
+	}
+	return 0, false
+}
+
+// intrinsicPaths returns the dotted key paths of the values in a YAML
+// document that use the short form of an intrinsic function, f.ex.
+// "!Ref", which the YAML decoder drops silently. The tags are read from
+// the parsed document, so block scalars, flow collections, and aliases of
+// anchors are covered. Values in lists are reported as the path of the key
+// that holds them.
+func intrinsicPaths(data []byte) (map[string]bool, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	paths := map[string]bool{}
+	active := map[*yaml.Node]bool{}
+
+	var walk func(n *yaml.Node, path []string)
+	walk = func(n *yaml.Node, path []string) {
+		if n == nil || active[n] {
+			return
+		}
+		active[n] = true
+		defer delete(active, n)
+
+		if strings.HasPrefix(n.Tag, "!") && !strings.HasPrefix(n.Tag, "!!") {
+			paths[strings.Join(path, ".")] = true
+			return
+		}
+
+		switch n.Kind {
+		case yaml.DocumentNode, yaml.SequenceNode:
+			for _, item := range n.Content {
+				walk(item, path)
+			}
+		case yaml.AliasNode:
+			walk(n.Alias, path)
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				key, value := n.Content[i], n.Content[i+1]
+				if key.Tag == "!!merge" {
+					// The merged keys are at the level of the mapping
+					walk(value, path)
+					continue
+				}
+				walk(value, append(append([]string{}, path...), key.Value))
+			}
+		}
+	}
+	walk(&doc, nil)
+
+	return paths, nil
+}
+
+// hasIntrinsic checks if the value at the path, or a value in it, uses an
+// intrinsic function.
+func hasIntrinsic(paths map[string]bool, path string) bool {
+	for p := range paths {
+		if p == path || strings.HasPrefix(p, path+".") ||
+			strings.HasPrefix(path, p+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package resource
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIntrinsicPaths(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     []string
+	}{
+		{
+			name: "scalars",
+			template: `
+Resources:
+  Fn:
+    Properties:
+      Role: !GetAtt Role.Arn
+      Handler: index.handler
+      Description: "!Ref isn't a tag in quotes"
+      # Runtime: !Ref Runtime
+`,
+			want: []string{"Resources.Fn.Properties.Role"},
+		},
+		{
+			name: "block scalars",
+			template: `
+Resources:
+  Fn:
+    Properties:
+      Description: !Sub |
+        Deployed to ${AWS::Region}
+      Handler: >
+        !Ref isn't a tag in a block scalar
+`,
+			want: []string{"Resources.Fn.Properties.Description"},
+		},
+		{
+			name: "sequences and flow collections",
+			template: `
+Resources:
+  Fn:
+    Properties:
+      Layers:
+        - arn:aws:lambda:eu-west-1:123456789012:layer:base:1
+        - !Ref Layer
+      Environment: {Variables: {A: "a",
+        B: !Ref B}}
+      Role: !GetAtt [Role,
+        Arn]
+`,
+			want: []string{
+				"Resources.Fn.Properties.Environment.Variables.B",
+				"Resources.Fn.Properties.Layers",
+				"Resources.Fn.Properties.Role",
+			},
+		},
+		{
+			name: "anchors, aliases, and merge keys",
+			template: `
+Globals:
+  Function: &globals
+    Timeout: !Ref Timeout
+Resources:
+  Fn:
+    Properties:
+      <<: *globals
+      MemorySize: &memory !Ref Memory
+  Other:
+    Properties:
+      MemorySize: *memory
+`,
+			want: []string{
+				"Globals.Function.Timeout",
+				"Resources.Fn.Properties.MemorySize",
+				"Resources.Fn.Properties.Timeout",
+				"Resources.Other.Properties.MemorySize",
+			},
+		},
+		{
+			name: "quoted keys",
+			template: `
+"Resources":
+  'Fn':
+    "Properties":
+      "Role": !GetAtt Role.Arn
+`,
+			want: []string{"Resources.Fn.Properties.Role"},
+		},
+		{
+			name: "standard tags",
+			template: `
+Resources:
+  Fn:
+    Properties:
+      Timeout: !!int "30"
+      Handler: !!str index.handler
+`,
+		},
+	}
+	for _, tt := range tests {
+		paths, err := intrinsicPaths([]byte(tt.template))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		want := map[string]bool{}
+		for _, p := range tt.want {
+			want[p] = true
+		}
+		if !reflect.DeepEqual(paths, want) {
+			t.Errorf("%s: got %v, want %v", tt.name, paths, want)
+		}
+	}
+}
+
+func TestIntrinsicPathsInvalid(t *testing.T) {
+	if _, err := intrinsicPaths([]byte("Resources: [\n")); err == nil {
+		t.Error("parsed an invalid template")
+	}
+}
+
+func TestHasIntrinsic(t *testing.T) {
+	paths := map[string]bool{
+		"Globals.Function.Environment.Variables.TABLE": true,
+		"Resources.Fn.Properties.Layers":               true,
+	}
+
+	tests := map[string]bool{
+		"Globals.Function.Environment":                 true,
+		"Globals.Function.Environment.Variables.TABLE": true,
+		"Globals.Function.Timeout":                     false,
+		"Resources.Fn.Properties.Layers":               true,
+		"Resources.Fn.Properties.LayersExtra":          false,
+	}
+	for path, want := range tests {
+		if got := hasIntrinsic(paths, path); got != want {
+			t.Errorf("hasIntrinsic(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package resource
+
+import (
+	"regexp"
+)
+
+// semverPattern finds a semantic version in a description, f.ex. the
+// "1.4.2" in "release-1.4.2". Longer dotted numbers, like IP addresses,
+// aren't semantic versions.
+var semverPattern = regexp.MustCompile(
+	`(?:^|[^0-9.])((\d+)\.(\d+)\.(\d+)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?)(?:$|[^0-9.]|\.(?:$|[^0-9]))`)
+
+// descriptionSemver returns the semantic version in a version
+// description, or an empty string if there is none.
+func descriptionSemver(description string) string {
+	m := semverPattern.FindStringSubmatch(description)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
@@ -0,0 +1,24 @@
+package resource
+
+import "testing"
+
+func TestDescriptionSemver(t *testing.T) {
+	tests := map[string]string{
+		"1.4.2":                   "1.4.2",
+		"release-1.4.2":           "1.4.2",
+		"v2.0.0-rc.1 (deploy 42)": "2.0.0-rc.1",
+		"build 1.2.3+sha.5114f85": "1.2.3+sha.5114f85",
+		"first 1.0.0 then 2.0.0":  "1.0.0",
+		"released 1.2.3.":         "1.2.3",
+		"10.1.2.3":                "",
+		"ip 192.168.1.1":          "",
+		"version 42":              "",
+		"":                        "",
+		"1.2":                     "",
+	}
+	for description, want := range tests {
+		if got := descriptionSemver(description); got != want {
+			t.Errorf("descriptionSemver(%q) = %q, want %q", description, got, want)
+		}
+	}
+}
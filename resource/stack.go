@@ -0,0 +1,221 @@
+package resource
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+// changeSetPrefix is the prefix of the change sets that the resource
+// creates, the rest of the name is the time it was created.
+const changeSetPrefix = "lambda-resource-"
+
+// noChanges matches the status reason of change sets that failed because
+// they didn't change anything.
+var noChanges = regexp.MustCompile(`(?i)didn't contain changes|no updates are to be performed`)
+
+// stackDeploy deploys code to a function that's managed by a
+// CloudFormation stack. The package is uploaded to S3 and the stack
+// parameter with the S3 key is updated through a change set, so that the
+// stack and the live function don't drift apart.
+type stackDeploy struct {
+	api StackAPI
+	log *concourse.Logger
+
+	// StackName is the stack that manages the function
+	StackName string
+	// Bucket is the S3 bucket of the packages
+	Bucket string
+	// KeyParameter is the stack parameter with the S3 key of the package
+	KeyParameter string
+	// KeyPrefix is prepended to the S3 keys of the packages
+	KeyPrefix string
+}
+
+// findStack returns the name of the stack that manages the function
+func findStack(ctx aws.Context, api StackAPI, functionName string) (string, error) {
+	out, err := api.DescribeStackResourcesWithContext(ctx, &cloudformation.DescribeStackResourcesInput{
+		PhysicalResourceId: &functionName,
+	})
+	if err != nil {
+		return "", errors.Wrapf(err,
+			"failed to find the stack that manages the function %q", functionName)
+	}
+	if len(out.StackResources) == 0 {
+		return "", errors.Errorf(
+			"the function %q isn't managed by a CloudFormation stack", functionName)
+	}
+	return aws.StringValue(out.StackResources[0].StackName), nil
+}
+
+// packageKey returns the S3 key of a package, the key is based on the
+// digest of the package so that uploading the same code again doesn't
+// change the stack.
+func (d *stackDeploy) packageKey(functionName string, data []byte) string {
+	sum := sha256.Sum256(data)
+	return d.KeyPrefix + functionName + "/" + hex.EncodeToString(sum[:]) + ".zip"
+}
+
+// upload uploads the package to S3 and returns its key
+func (d *stackDeploy) upload(ctx aws.Context, functionName string, data []byte) (string, error) {
+	key := d.packageKey(functionName, data)
+
+	_, err := d.api.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:        &d.Bucket,
+		Key:           &key,
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+		ContentType:   aws.String("application/zip"),
+	}, withUploadProgress(d.log, uploadProgressInterval))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to upload the package to s3://%s/%s", d.Bucket, key)
+	}
+
+	d.log.Infof("uploaded the package to s3://%s/%s", d.Bucket, key)
+	return key, nil
+}
+
+// update points the key parameter of the stack at the package through a
+// change set. Returns false if the stack already used the package.
+func (d *stackDeploy) update(ctx aws.Context, key string) (bool, error) {
+	stacks, err := d.api.DescribeStacksWithContext(ctx, &cloudformation.DescribeStacksInput{
+		StackName: &d.StackName,
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to describe the stack %q", d.StackName)
+	}
+	if len(stacks.Stacks) == 0 {
+		return false, errors.Errorf("the stack %q doesn't exist", d.StackName)
+	}
+	stack := stacks.Stacks[0]
+
+	var params []*cloudformation.Parameter
+	found := false
+	for _, p := range stack.Parameters {
+		name := aws.StringValue(p.ParameterKey)
+		if name != d.KeyParameter {
+			params = append(params, &cloudformation.Parameter{
+				ParameterKey:     p.ParameterKey,
+				UsePreviousValue: aws.Bool(true),
+			})
+			continue
+		}
+
+		found = true
+		if aws.StringValue(p.ParameterValue) == key {
+			d.log.Infof("the stack %s already uses the package", d.StackName)
+			return false, nil
+		}
+		params = append(params, &cloudformation.Parameter{
+			ParameterKey:   p.ParameterKey,
+			ParameterValue: aws.String(key),
+		})
+	}
+	if !found {
+		return false, concourse.ConfigError(errors.Errorf(
+			"the stack %q has no parameter %q", d.StackName, d.KeyParameter))
+	}
+
+	// Templates with transforms, like SAM templates, can only be updated
+	// with the auto expand capability.
+	capabilities := stack.Capabilities
+	autoExpand := false
+	for _, c := range capabilities {
+		if aws.StringValue(c) == cloudformation.CapabilityCapabilityAutoExpand {
+			autoExpand = true
+		}
+	}
+	if !autoExpand {
+		capabilities = append(capabilities, aws.String(cloudformation.CapabilityCapabilityAutoExpand))
+	}
+
+	created, err := d.api.CreateChangeSetWithContext(ctx, &cloudformation.CreateChangeSetInput{
+		StackName:           &d.StackName,
+		ChangeSetName:       aws.String(changeSetPrefix + strconv.FormatInt(time.Now().Unix(), 10)),
+		ChangeSetType:       aws.String(cloudformation.ChangeSetTypeUpdate),
+		UsePreviousTemplate: aws.Bool(true),
+		Parameters:          params,
+		Capabilities:        capabilities,
+		Description:         aws.String("Deploys s3://" + d.Bucket + "/" + key),
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to create a change set for the stack %q", d.StackName)
+	}
+
+	changeSet := &cloudformation.DescribeChangeSetInput{
+		ChangeSetName: created.Id,
+	}
+	if err := d.api.WaitUntilChangeSetCreateCompleteWithContext(ctx, changeSet); err != nil {
+		described, describeErr := d.api.DescribeChangeSetWithContext(ctx, changeSet)
+		if describeErr != nil {
+			return false, errors.Wrap(err, "failed to create the change set")
+		}
+
+		reason := aws.StringValue(described.StatusReason)
+		if !noChanges.MatchString(reason) {
+			return false, errors.Errorf("failed to create the change set: %s", reason)
+		}
+
+		d.log.Infof("the change set didn't change the stack %s", d.StackName)
+		if _, err := d.api.DeleteChangeSetWithContext(ctx, &cloudformation.DeleteChangeSetInput{
+			ChangeSetName: created.Id,
+		}); err != nil {
+			d.log.Warnf("failed to delete the empty change set: %v", err)
+		}
+		return false, nil
+	}
+
+	described, err := d.api.DescribeChangeSetWithContext(ctx, changeSet)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to describe the change set")
+	}
+	d.log.Infof("executing the change set %s:", aws.StringValue(described.ChangeSetName))
+	for _, change := range described.Changes {
+		rc := change.ResourceChange
+		if rc == nil {
+			continue
+		}
+		d.log.Infof("  %s %s (%s), replacement: %s",
+			aws.StringValue(rc.Action), aws.StringValue(rc.LogicalResourceId),
+			aws.StringValue(rc.ResourceType), aws.StringValue(rc.Replacement))
+	}
+
+	if _, err := d.api.ExecuteChangeSetWithContext(ctx, &cloudformation.ExecuteChangeSetInput{
+		ChangeSetName: created.Id,
+	}); err != nil {
+		return false, errors.Wrap(err, "failed to execute the change set")
+	}
+
+	if err := d.api.WaitUntilStackUpdateCompleteWithContext(ctx, &cloudformation.DescribeStacksInput{
+		StackName: &d.StackName,
+	}); err != nil {
+		return false, d.updateFailed(ctx, err)
+	}
+
+	d.log.Infof("successfully updated the stack %s", d.StackName)
+	return true, nil
+}
+
+// updateFailed adds the status of the stack to a failed update
+func (d *stackDeploy) updateFailed(ctx aws.Context, err error) error {
+	stacks, describeErr := d.api.DescribeStacksWithContext(ctx, &cloudformation.DescribeStacksInput{
+		StackName: &d.StackName,
+	})
+	if describeErr != nil || len(stacks.Stacks) == 0 {
+		return errors.Wrapf(err, "failed to update the stack %q", d.StackName)
+	}
+
+	stack := stacks.Stacks[0]
+	return errors.Wrapf(err, "failed to update the stack %q, it's %s: %s",
+		d.StackName, aws.StringValue(stack.StackStatus),
+		aws.StringValue(stack.StackStatusReason))
+}
@@ -0,0 +1,146 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/pkg/errors"
+)
+
+// InvokeModeResponseStream invokes the function with
+// InvokeWithResponseStream.
+const InvokeModeResponseStream = "response_stream"
+
+// StreamStatus is the completion status of a response stream
+type StreamStatus struct {
+	// Completed is set if the stream ended with a completion event
+	Completed bool `json:"completed"`
+	// ErrorCode is set if the function failed while streaming
+	ErrorCode string `json:"error_code,omitempty"`
+	// ErrorDetails describes the error
+	ErrorDetails string `json:"error_details,omitempty"`
+}
+
+// InvokeFunctionStream invokes a lambda function with a streamed response,
+// the qualifier is an optional version or alias. The payload chunks are
+// concatenated into the result payload.
+func InvokeFunctionStream(
+	ctx context.Context, log *concourse.Logger, api LambdaAPI,
+	source Source, qualifier *string,
+	payload PayloadSpec, opts InvokeOptions,
+) (*InvokeResult, error) {
+	name := source.FunctionName
+
+	data, err := payloadData(payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get payload data")
+	}
+
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	if qualifier != nil {
+		name += ":" + *qualifier
+	}
+
+	clientContext, err := opts.clientContext()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, err := opts.timeout()
+	if err != nil {
+		return nil, err
+	}
+
+	retry, err := opts.retryPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	invokeCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		invokeCtx, cancel = context.WithTimeout(invokeCtx, timeout)
+		defer cancel()
+	}
+
+	input := lambda.InvokeWithResponseStreamInput{
+		FunctionName:  &name,
+		Payload:       data,
+		ClientContext: clientContext,
+		LogType:       aws.String(lambda.LogTypeTail),
+	}
+
+	var (
+		req    *request.Request
+		output *lambda.InvokeWithResponseStreamOutput
+	)
+	err = retry.Do(ctx, log, "invocation", func() error {
+		req, output = api.InvokeWithResponseStreamRequest(&input)
+		req.SetContext(invokeCtx)
+		// The attempts are counted by the retry policy alone
+		req.Retryer = client.NoOpRetryer{}
+		return req.Send()
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to invoke function")
+	}
+
+	stream := output.GetStream()
+	defer stream.Close()
+
+	var buf bytes.Buffer
+	status := &StreamStatus{}
+	result := &InvokeResult{
+		InvokeOutput: &lambda.InvokeOutput{
+			StatusCode:      output.StatusCode,
+			ExecutedVersion: output.ExecutedVersion,
+		},
+		RequestID: req.RequestID,
+		Stream:    status,
+	}
+
+	for event := range stream.Events() {
+		switch e := event.(type) {
+		case *lambda.InvokeResponseStreamUpdate:
+			buf.Write(e.Payload)
+		case *lambda.InvokeWithResponseStreamCompleteEvent:
+			status.Completed = true
+			status.ErrorCode = aws.StringValue(e.ErrorCode)
+			status.ErrorDetails = aws.StringValue(e.ErrorDetails)
+			result.LogResult = e.LogResult
+		}
+	}
+	result.Payload = buf.Bytes()
+
+	if err := stream.Err(); err != nil {
+		if invokeCtx.Err() == context.DeadlineExceeded {
+			return result, fmt.Errorf(
+				"the response stream timed out after %s (request id: %s)",
+				timeout, result.RequestID)
+		}
+		return result, errors.Wrap(err, "failed to read the response stream")
+	}
+
+	switch {
+	case !status.Completed:
+		return result, fmt.Errorf(
+			"the response stream ended without completing (request id: %s)",
+			result.RequestID)
+	case status.ErrorCode != "":
+		result.FunctionError = aws.String(string(UnhandledError))
+		return result, fmt.Errorf(
+			"the function failed while streaming the response with %s: %s",
+			status.ErrorCode, status.ErrorDetails)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,159 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/pkg/errors"
+)
+
+// traceTags returns the tags that trace a published version back to the
+// build that produced it.
+func traceTags(
+	meta concourse.BuildMetadata, version string, refFile *string,
+) (map[string]string, error) {
+	tags := map[string]string{
+		"concourse:version": version,
+	}
+
+	build := map[string]string{
+		"concourse:pipeline": meta.PipelineName,
+		"concourse:job":      meta.JobName,
+		"concourse:build":    meta.Name,
+		"concourse:build-id": meta.ID,
+	}
+	for tag, value := range build {
+		if value != "" {
+			tags[tag] = value
+		}
+	}
+
+	if refFile != nil {
+		ref, err := ioutil.ReadFile(*refFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read ref file %q", *refFile)
+		}
+		tags["concourse:ref"] = strings.TrimSpace(string(ref))
+	}
+
+	return tags, nil
+}
+
+// traceMarker starts the trace tags in the description of a version that
+// was published by a put with trace_tags.
+const traceMarker = "concourse-trace:"
+
+// maxVersionDescription is the longest description Lambda accepts
+const maxVersionDescription = 256
+
+// traceDescription returns the description of a version published by a
+// put with trace_tags: the description of the function followed by the
+// trace tags, f.ex. "API concourse-trace:build=12&job=deploy&pipeline=api".
+// The function description is left out if they don't both fit.
+func traceDescription(
+	log *concourse.Logger, description string, tags map[string]string,
+) (string, error) {
+	values := url.Values{}
+	for key, value := range tags {
+		if key != "concourse:version" {
+			values.Set(strings.TrimPrefix(key, "concourse:"), value)
+		}
+	}
+
+	marker := traceMarker + values.Encode()
+	switch {
+	case len(marker) > maxVersionDescription:
+		return "", fmt.Errorf(
+			"the trace tags don't fit in a version description of %d characters: %s",
+			maxVersionDescription, marker)
+	case description == "":
+		return marker, nil
+	case len(description)+1+len(marker) > maxVersionDescription:
+		log.Warnf("the function description was left out of the version description to make room for the trace tags")
+		return marker, nil
+	}
+	return description + " " + marker, nil
+}
+
+// descriptionTraceTags returns the trace tags in the description of a
+// version, and false if the version wasn't published by a put with
+// trace_tags.
+func descriptionTraceTags(description string) (map[string]string, bool) {
+	i := strings.LastIndex(description, traceMarker)
+	if i < 0 {
+		return nil, false
+	}
+	values, err := url.ParseQuery(description[i+len(traceMarker):])
+	if err != nil {
+		return nil, false
+	}
+
+	tags := map[string]string{}
+	for key := range values {
+		tags["concourse:"+key] = values.Get(key)
+	}
+	return tags, true
+}
+
+// tagFunction applies tags to the function that the version belongs to.
+// Lambda doesn't support tagging individual versions.
+func tagFunction(
+	ctx context.Context, api LambdaAPI,
+	config *lambda.FunctionConfiguration, tags map[string]string,
+) error {
+	arn := unqualifiedArn(config)
+
+	_, err := api.TagResourceWithContext(ctx, &lambda.TagResourceInput{
+		Resource: &arn,
+		Tags:     aws.StringMap(tags),
+	})
+	return errors.Wrap(err, "failed to tag function")
+}
+
+// unqualifiedArn returns the ARN of the function that the version belongs
+// to.
+func unqualifiedArn(config *lambda.FunctionConfiguration) string {
+	return strings.TrimSuffix(
+		aws.StringValue(config.FunctionArn), ":"+aws.StringValue(config.Version),
+	)
+}
+
+// persistTags writes the tags of the function to "tags.json"
+func persistTags(
+	ctx *concourse.CommandContext, api LambdaAPI, functionName string,
+) error {
+	config, err := api.GetFunctionConfigurationWithContext(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: &functionName,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to get function configuration")
+	}
+
+	arn := unqualifiedArn(config)
+	out, err := api.ListTagsWithContext(ctx, &lambda.ListTagsInput{
+		Resource: &arn,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to list function tags")
+	}
+
+	tags := aws.StringValueMap(out.Tags)
+	return errors.Wrap(ctx.JSON("tags.json", tags), "failed to persist tags")
+}
+
+// sortedKeys returns the keys of the map in sorted order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
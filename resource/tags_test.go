@@ -0,0 +1,55 @@
+package resource
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+)
+
+func TestTraceDescription(t *testing.T) {
+	log := concourse.NewLogger(ioutil.Discard)
+	tags := map[string]string{
+		"concourse:version":  "7",
+		"concourse:pipeline": "api",
+		"concourse:job":      "deploy prod",
+		"concourse:ref":      "5114f85",
+	}
+	want := map[string]string{
+		"concourse:pipeline": "api",
+		"concourse:job":      "deploy prod",
+		"concourse:ref":      "5114f85",
+	}
+
+	tests := map[string]string{
+		"":                       "concourse-trace:job=deploy+prod&pipeline=api&ref=5114f85",
+		"API release-1.2.3":      "API release-1.2.3 concourse-trace:job=deploy+prod&pipeline=api&ref=5114f85",
+		strings.Repeat("x", 250): "concourse-trace:job=deploy+prod&pipeline=api&ref=5114f85",
+	}
+	for description, wantDescription := range tests {
+		got, err := traceDescription(log, description, tags)
+		if err != nil {
+			t.Errorf("traceDescription(%q) failed: %v", description, err)
+			continue
+		}
+		if got != wantDescription {
+			t.Errorf("traceDescription(%q) = %q, want %q", description, got, wantDescription)
+		}
+
+		traced, ok := descriptionTraceTags(got)
+		if !ok || !reflect.DeepEqual(traced, want) {
+			t.Errorf("descriptionTraceTags(%q) = %v, %v, want %v", got, traced, ok, want)
+		}
+	}
+
+	if _, ok := descriptionTraceTags("API release-1.2.3"); ok {
+		t.Error("found trace tags in a description without them")
+	}
+	if _, err := traceDescription(log, "", map[string]string{
+		"concourse:ref": strings.Repeat("x", 300),
+	}); err == nil {
+		t.Error("expected an error for trace tags that don't fit")
+	}
+}
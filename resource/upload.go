@@ -0,0 +1,133 @@
+package resource
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// uploadProgressInterval is how often the progress of an upload is logged
+const uploadProgressInterval = 10 * time.Second
+
+// withUploadProgress is a request option that logs the progress of the
+// request body upload every interval, until the response of the attempt
+// has been received. Stalled uploads are reported with how long nothing
+// has been sent. Uploads that finish within the interval aren't logged.
+func withUploadProgress(log *concourse.Logger, interval time.Duration) request.Option {
+	return func(r *request.Request) {
+		var pr *progressReader
+		r.Handlers.Send.PushFront(func(r *request.Request) {
+			body := r.HTTPRequest.Body
+			if body == nil || r.HTTPRequest.ContentLength <= 0 {
+				return
+			}
+			pr = newProgressReader(
+				body, r.HTTPRequest.ContentLength, log, interval)
+			r.HTTPRequest.Body = pr
+		})
+		r.Handlers.CompleteAttempt.PushBack(func(r *request.Request) {
+			if pr != nil {
+				pr.stop()
+				pr = nil
+			}
+		})
+	}
+}
+
+// progressReader counts the bytes that are read and logs the transfer
+// progress on every tick until it's stopped.
+type progressReader struct {
+	io.ReadCloser
+
+	log   *concourse.Logger
+	total int64
+	done  chan struct{}
+	once  sync.Once
+
+	mu      sync.Mutex
+	read    int64
+	start   time.Time
+	changed time.Time
+}
+
+func newProgressReader(
+	r io.ReadCloser, total int64, log *concourse.Logger, interval time.Duration,
+) *progressReader {
+	now := time.Now()
+	pr := &progressReader{
+		ReadCloser: r,
+		log:        log,
+		total:      total,
+		done:       make(chan struct{}),
+		start:      now,
+		changed:    now,
+	}
+	go pr.report(interval)
+	return pr
+}
+
+// Read reads from the underlying reader and counts the bytes
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.ReadCloser.Read(p)
+	if n > 0 {
+		pr.mu.Lock()
+		pr.read += int64(n)
+		pr.changed = time.Now()
+		pr.mu.Unlock()
+	}
+	return n, err
+}
+
+// report logs the progress every interval until the reader is stopped
+func (pr *progressReader) report(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	reported := int64(-1)
+	for {
+		select {
+		case <-pr.done:
+			return
+		case now := <-ticker.C:
+			pr.mu.Lock()
+			msg := pr.progress(now, pr.read == reported)
+			reported = pr.read
+			pr.mu.Unlock()
+			pr.log.Infof("%s", msg)
+		}
+	}
+}
+
+// stop stops the progress reports
+func (pr *progressReader) stop() {
+	pr.once.Do(func() {
+		close(pr.done)
+	})
+}
+
+// progress describes the transferred amount, throughput, and the
+// estimated time left. Stalled uploads are described with how long
+// nothing has been sent instead.
+func (pr *progressReader) progress(now time.Time, stalled bool) string {
+	if pr.read >= pr.total {
+		return fmt.Sprintf("uploaded %s, waiting for the response for %s",
+			formatSize(uint64(pr.total)), now.Sub(pr.changed).Round(time.Second))
+	}
+
+	percent := float64(pr.read) / float64(pr.total) * 100
+	msg := fmt.Sprintf("uploaded %s of %s (%.0f%%)",
+		formatSize(uint64(pr.read)), formatSize(uint64(pr.total)), percent)
+	if stalled || pr.read == 0 {
+		return fmt.Sprintf("%s, no progress for %s",
+			msg, now.Sub(pr.changed).Round(time.Second))
+	}
+
+	rate := float64(pr.read) / now.Sub(pr.start).Seconds()
+	left := time.Duration(float64(pr.total-pr.read) / rate * float64(time.Second))
+	return fmt.Sprintf("%s, %s/s, about %s left",
+		msg, formatSize(uint64(rate)), left.Round(time.Second))
+}
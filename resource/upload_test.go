@@ -0,0 +1,76 @@
+package resource
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+)
+
+// syncBuffer is a buffer that can be written from the progress reports
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestProgressReaderStalled(t *testing.T) {
+	var log syncBuffer
+	r, w := io.Pipe()
+	defer w.Close()
+
+	pr := newProgressReader(r, 1024, concourse.NewLogger(&log), 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	pr.stop()
+
+	if !strings.Contains(log.String(), "(0%), no progress for") {
+		t.Errorf("a stalled upload wasn't reported:\n%s", log.String())
+	}
+}
+
+func TestProgress(t *testing.T) {
+	start := time.Now()
+	pr := &progressReader{
+		ReadCloser: ioutil.NopCloser(strings.NewReader("")),
+		total:      100 * 1024 * 1024,
+		read:       50 * 1024 * 1024,
+		start:      start,
+		changed:    start.Add(5 * time.Second),
+	}
+
+	now := start.Add(10 * time.Second)
+	tests := []struct {
+		stalled bool
+		want    string
+	}{
+		{false, "uploaded 50.0MB of 100.0MB (50%), 5.0MB/s, about 10s left"},
+		{true, "uploaded 50.0MB of 100.0MB (50%), no progress for 5s"},
+	}
+	for _, tt := range tests {
+		if got := pr.progress(now, tt.stalled); got != tt.want {
+			t.Errorf("progress(stalled: %v) = %q, want %q", tt.stalled, got, tt.want)
+		}
+	}
+
+	pr.read = pr.total
+	want := "uploaded 100.0MB, waiting for the response for 5s"
+	if got := pr.progress(now, true); got != want {
+		t.Errorf("progress() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,111 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/Sydsvenskan/lambda-resource/internal/concourse"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/pkg/errors"
+)
+
+// ViaFunctionURL invokes the function through its function URL instead of
+// the Invoke API.
+const ViaFunctionURL = "function_url"
+
+// InvokeFunctionURL invokes a lambda function with a HTTP POST request to
+// its function URL, the qualifier is an optional alias. Requests to URLs
+// that use AWS_IAM auth are signed with the source credentials. Responses
+// with a 5xx status code are returned together with an error.
+func InvokeFunctionURL(
+	ctx context.Context, log *concourse.Logger, api LambdaAPI,
+	source Source, qualifier *string,
+	payload PayloadSpec, opts InvokeOptions,
+) (*InvokeResult, error) {
+	data, err := payloadData(payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get payload data")
+	}
+
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	timeout, err := opts.timeout()
+	if err != nil {
+		return nil, err
+	}
+
+	urlConfig, err := api.GetFunctionUrlConfigWithContext(ctx, &lambda.GetFunctionUrlConfigInput{
+		FunctionName: &source.FunctionName,
+		Qualifier:    qualifier,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the function URL")
+	}
+
+	url := aws.StringValue(urlConfig.FunctionUrl)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create request for %q", url)
+	}
+	req.Header.Set("Content-Type", urlContentType(payload, opts))
+
+	if aws.StringValue(urlConfig.AuthType) == lambda.FunctionUrlAuthTypeAwsIam {
+		signer := v4.NewSigner(awsSession(source).Config.Credentials)
+		_, err := signer.Sign(req, bytes.NewReader(data),
+			lambda.ServiceName, source.RegionName, time.Now())
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to sign the function URL request")
+		}
+	}
+
+	log.Infof("invoking %s", url)
+
+	client := http.Client{Timeout: timeout}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to invoke %q", url)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the response body")
+	}
+
+	result := &InvokeResult{
+		InvokeOutput: &lambda.InvokeOutput{
+			StatusCode: aws.Int64(int64(res.StatusCode)),
+			Payload:    body,
+		},
+		RequestID: res.Header.Get("X-Amzn-Requestid"),
+		Headers:   res.Header,
+	}
+
+	if res.StatusCode >= 500 {
+		return result, fmt.Errorf(
+			"the function URL responded with %s (request id: %s)",
+			res.Status, result.RequestID)
+	}
+
+	return result, nil
+}
+
+// urlContentType returns the Content-Type of a function URL request:
+// the content_type param, or the type of the payload.
+func urlContentType(payload PayloadSpec, opts InvokeOptions) string {
+	switch {
+	case opts.ContentType != nil:
+		return *opts.ContentType
+	case aws.StringValue(payload.PayloadEncoding) == PayloadEncodingBinary:
+		return "application/octet-stream"
+	}
+	return "application/json"
+}
@@ -0,0 +1,37 @@
+package resource
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestURLContentType(t *testing.T) {
+	tests := []struct {
+		payload PayloadSpec
+		opts    InvokeOptions
+		want    string
+	}{
+		{want: "application/json"},
+		{
+			payload: PayloadSpec{PayloadEncoding: aws.String(PayloadEncodingBase64)},
+			want:    "application/json",
+		},
+		{
+			payload: PayloadSpec{PayloadEncoding: aws.String(PayloadEncodingBinary)},
+			want:    "application/octet-stream",
+		},
+		{
+			payload: PayloadSpec{PayloadEncoding: aws.String(PayloadEncodingBinary)},
+			opts:    InvokeOptions{ContentType: aws.String("image/png")},
+			want:    "image/png",
+		},
+	}
+	for _, tt := range tests {
+		if got := urlContentType(tt.payload, tt.opts); got != tt.want {
+			t.Errorf("urlContentType(%v, %v) = %q, want %q",
+				aws.StringValue(tt.payload.PayloadEncoding),
+				aws.StringValue(tt.opts.ContentType), got, tt.want)
+		}
+	}
+}
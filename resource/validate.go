@@ -0,0 +1,193 @@
+package resource
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/pkg/errors"
+)
+
+const (
+	// MaxZippedSize is the largest zip archive Lambda accepts for a direct
+	// upload.
+	MaxZippedSize = 50 * 1024 * 1024
+	// MaxUnzippedSize is the largest unzipped code package Lambda accepts.
+	MaxUnzippedSize = 250 * 1024 * 1024
+)
+
+// validatePackageSize checks the zipped and unzipped sizes of the code
+// package against the Lambda limits. The zipped size limit only applies
+// to direct uploads, not to packages that are deployed from S3.
+func validatePackageSize(archive []byte, direct bool) error {
+	r, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return errors.Wrap(err, "failed to read code package")
+	}
+
+	var unzipped uint64
+	for _, f := range r.File {
+		unzipped += f.UncompressedSize64
+	}
+
+	var problem string
+	switch {
+	case direct && len(archive) > MaxZippedSize:
+		problem = fmt.Sprintf(
+			"the zipped package is %s, which exceeds the limit of %s",
+			formatSize(uint64(len(archive))), formatSize(MaxZippedSize),
+		)
+	case unzipped > MaxUnzippedSize:
+		problem = fmt.Sprintf(
+			"the unzipped package is %s, which exceeds the limit of %s",
+			formatSize(unzipped), formatSize(MaxUnzippedSize),
+		)
+	default:
+		return nil
+	}
+
+	files := make([]*zip.File, len(r.File))
+	copy(files, r.File)
+	sort.Sort(bySize(files))
+
+	largest := make([]string, 0, 10)
+	for i := 0; i < len(files) && i < cap(largest); i++ {
+		largest = append(largest, fmt.Sprintf("%s (%s)",
+			files[i].Name, formatSize(files[i].UncompressedSize64)))
+	}
+
+	return fmt.Errorf("%s, the largest files are: %s",
+		problem, strings.Join(largest, ", "))
+}
+
+// bySize sorts zip files by descending uncompressed size
+type bySize []*zip.File
+
+func (l bySize) Len() int      { return len(l) }
+func (l bySize) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l bySize) Less(i, j int) bool {
+	return l[i].UncompressedSize64 > l[j].UncompressedSize64
+}
+
+func formatSize(size uint64) string {
+	return fmt.Sprintf("%.1fMB", float64(size)/(1024*1024))
+}
+
+// verifyChecksumFile verifies the data against the sha256 digest in the
+// checksum file. The file can either contain just the hex encoded digest, or
+// be in the "sha256sum" output format. Returns the digest of the data.
+func verifyChecksumFile(name string, data []byte) ([]byte, error) {
+	contents, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read checksum file %q", name)
+	}
+
+	fields := strings.Fields(string(contents))
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("the checksum file %q is empty", name)
+	}
+
+	expected, err := hex.DecodeString(fields[0])
+	if err != nil || len(expected) != sha256.Size {
+		return nil, fmt.Errorf(
+			"the checksum file %q doesn't contain a valid sha256 digest", name)
+	}
+
+	digest := sha256.Sum256(data)
+	if !bytes.Equal(digest[:], expected) {
+		return nil, fmt.Errorf(
+			"checksum mismatch, the zip file has the sha256 %x, expected %x",
+			digest[:], expected)
+	}
+
+	return digest[:], nil
+}
+
+// validatePackageHandler checks that the handler of the function can be
+// found in the zipped code package.
+func validatePackageHandler(
+	ctx context.Context, api LambdaAPI,
+	source Source, params PutParams, data []byte,
+) error {
+	config, err := api.GetFunctionConfigurationWithContext(ctx, &lambda.GetFunctionConfigurationInput{
+		FunctionName: &source.FunctionName,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to get function configuration")
+	}
+
+	handler := aws.StringValue(config.Handler)
+	if params.Handler != nil {
+		handler = *params.Handler
+	}
+
+	return packagingError(errors.Wrap(
+		validateHandler(data, aws.StringValue(config.Runtime), handler),
+		"handler validation failed",
+	))
+}
+
+// handlerCandidates returns the files that could implement the handler for
+// the given runtime. Returns false if the runtime isn't supported.
+func handlerCandidates(runtime, handler string) ([]string, bool) {
+	sep := strings.LastIndex(handler, ".")
+	if sep <= 0 {
+		return nil, true
+	}
+	module := handler[:sep]
+
+	switch {
+	case strings.HasPrefix(runtime, "nodejs"):
+		return []string{
+			module + ".js", module + ".mjs", module + ".cjs",
+		}, true
+	case strings.HasPrefix(runtime, "python"):
+		module = strings.Replace(module, ".", "/", -1)
+		return []string{
+			module + ".py", module + "/__init__.py",
+		}, true
+	}
+
+	return nil, false
+}
+
+// validateHandler checks that a file implementing the handler is present
+// in the archive.
+func validateHandler(archive []byte, runtime, handler string) error {
+	candidates, supported := handlerCandidates(runtime, handler)
+	if !supported {
+		return nil
+	}
+	if len(candidates) == 0 {
+		return fmt.Errorf("%q is not a valid handler for %s", handler, runtime)
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return errors.Wrap(err, "failed to read code package")
+	}
+
+	files := make(map[string]bool, len(r.File))
+	for _, f := range r.File {
+		files[f.Name] = true
+	}
+
+	for _, name := range candidates {
+		if files[name] {
+			return nil
+		}
+	}
+
+	return fmt.Errorf(
+		"the handler %q can't be found in the package, expected one of: %s",
+		handler, strings.Join(candidates, ", "),
+	)
+}